@@ -0,0 +1,527 @@
+package Base_PKG
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	归档任务: 分批将超过保留时间的记录移动到按月分表的归档表 <table>_archive_YYYYMM
+*/
+
+const (
+	archiveRunning = "running"
+	archiveDone    = "done"
+)
+
+var (
+	// ErrReplicaLagUnknown 从库未在复制, 无法获取延迟
+	ErrReplicaLagUnknown = errors.New("无法获取从库延迟")
+
+	// ErrArchiveConflict 进度被其他进程修改, 同一张表的归档任务同时只能运行一个
+	ErrArchiveConflict = errors.New("归档任务正在其他进程运行")
+)
+
+// archiveCheckpoint 归档进度, 每批与数据移动在同一事务中更新
+type archiveCheckpoint struct {
+	Job       string    `gorm:"primaryKey;size:128"`
+	Cutoff    time.Time `gorm:"not null"`
+	LastKey   int64     `gorm:"not null"`
+	Moved     int64     `gorm:"not null"`
+	Status    string    `gorm:"size:16;not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// ArchiveOptions
+// @Description: 归档配置
+type ArchiveOptions struct {
+	// 源表
+	Table string
+
+	// 整数自增主键列, 默认 id
+	KeyColumn string
+
+	// 判断记录时间的列, 同时决定归档表的月份, 默认 created_at
+	TimeColumn string
+
+	// 保留时间, 早于 now - OlderThan 的记录被归档
+	OlderThan time.Duration
+
+	// 每批记录数, 默认 500
+	BatchSize int
+
+	// 每批之间的间隔, 默认 100ms
+	Sleep time.Duration
+
+	// 从库延迟检查, 延迟超过 MaxReplicaLag 时暂停, 为空时不检查
+	ReplicaLag func(ctx context.Context) (time.Duration, error)
+
+	// 允许的最大从库延迟, 默认 5s
+	MaxReplicaLag time.Duration
+
+	// 进度表, 默认 archive_checkpoints
+	CheckpointTable string
+
+	// 只统计需要归档的记录, 不写入
+	DryRun bool
+}
+
+// ArchiveResult
+// @Description: 归档结果, DryRun 时为将要归档的记录
+type ArchiveResult struct {
+	Cutoff  time.Time        `json:"cutoff"`
+	Batches int              `json:"batches"`
+	Rows    int64            `json:"rows"`
+	Tables  map[string]int64 `json:"tables"`
+	Resumed bool             `json:"resumed"`
+}
+
+/*
+Archiver
+
+	@Description: 归档任务
+*/
+type Archiver struct {
+	db   *gorm.DB
+	opts ArchiveOptions
+	loc  *time.Location
+}
+
+/*
+NewArchiver
+
+	@Description: 创建归档任务
+	@param db: gorm 对象, 为 nil 时使用 GetDBConn
+	@param opts: 归档配置
+	@return *Archiver
+*/
+func NewArchiver(db *gorm.DB, opts ArchiveOptions) *Archiver {
+	if db == nil {
+		db = GetDBConn()
+	}
+	if opts.KeyColumn == "" {
+		opts.KeyColumn = "id"
+	}
+	if opts.TimeColumn == "" {
+		opts.TimeColumn = "created_at"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.Sleep <= 0 {
+		opts.Sleep = 100 * time.Millisecond
+	}
+	if opts.MaxReplicaLag <= 0 {
+		opts.MaxReplicaLag = 5 * time.Second
+	}
+	if opts.CheckpointTable == "" {
+		opts.CheckpointTable = "archive_checkpoints"
+	}
+	return &Archiver{db: db, opts: opts, loc: connLocation(db)}
+}
+
+// connLocation 连接配置的时区. mysql 驱动按 DSN 的 loc 参数(默认 UTC)解析和写入时间, 其他数据库使用本地时区
+func connLocation(db *gorm.DB) *time.Location {
+	if d, ok := db.Dialector.(*mysql.Dialector); ok && d.Config != nil {
+		if d.DSNConfig != nil && d.DSNConfig.Loc != nil {
+			return d.DSNConfig.Loc
+		}
+		return time.UTC
+	}
+	return time.Local
+}
+
+// archiveGroup 一批记录中属于同一张归档表的主键, month 为该归档表月份的开始时间
+type archiveGroup struct {
+	month time.Time
+	keys  []int64
+}
+
+// ArchiveTable 记录时间对应的归档表名
+func ArchiveTable(table string, t time.Time) string {
+	return table + "_archive_" + t.Format("200601")
+}
+
+/*
+Run
+
+	@Description: 执行归档. 上次运行未完成时从进度表记录的位置和截止时间继续, 否则按 OlderThan 重新计算截止时间.
+	ctx 取消时在当前批次结束后返回, 下次运行继续. 每批锁定进度行并检查进度, 与其他进程同时运行时返回 ErrArchiveConflict
+	@return *ArchiveResult
+	@return error
+*/
+func (a *Archiver) Run(ctx context.Context) (*ArchiveResult, error) {
+	if a.opts.Table == "" || a.opts.OlderThan <= 0 {
+		return nil, errors.New("归档需要设置 Table 和 OlderThan")
+	}
+	db := a.db.WithContext(ctx)
+	if !a.opts.DryRun {
+		if err := db.Table(a.opts.CheckpointTable).AutoMigrate(&archiveCheckpoint{}); err != nil {
+			return nil, err
+		}
+	}
+
+	cp, resumed, err := a.checkpoint(db)
+	if err != nil {
+		return nil, err
+	}
+	res := &ArchiveResult{Cutoff: cp.Cutoff, Tables: make(map[string]int64), Resumed: resumed}
+	// 已创建的归档表及复制的列
+	created := make(map[string][]string)
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return res, err
+		}
+		if err = a.waitReplicas(ctx); err != nil {
+			return res, err
+		}
+
+		groups, lastKey, err := a.nextBatch(db, cp)
+		if err != nil {
+			return res, err
+		}
+		if len(groups) == 0 {
+			break
+		}
+
+		var moved int64
+		if a.opts.DryRun {
+			for table, g := range groups {
+				res.Tables[table] += int64(len(g.keys))
+				moved += int64(len(g.keys))
+			}
+		} else {
+			var counts map[string]int64
+			if counts, err = a.move(db, cp, groups, lastKey, created); err != nil {
+				return res, err
+			}
+			for table, n := range counts {
+				res.Tables[table] += n
+				moved += n
+			}
+		}
+		cp.LastKey = lastKey
+		cp.Moved += moved
+		res.Batches++
+		res.Rows += moved
+
+		select {
+		case <-ctx.Done():
+			return res, ctx.Err()
+		case <-time.After(a.opts.Sleep):
+		}
+	}
+
+	if !a.opts.DryRun {
+		cp.Status = archiveDone
+		err = db.Table(a.opts.CheckpointTable).Where("job = ?", cp.Job).
+			Updates(map[string]interface{}{"status": archiveDone, "updated_at": time.Now()}).Error
+	}
+	zap.L().Info("archive finished", zap.String("table", a.opts.Table), zap.Int64("rows", res.Rows), zap.Bool("dryRun", a.opts.DryRun))
+	return res, err
+}
+
+// checkpoint 读取未完成的进度, 没有时创建新的进度
+func (a *Archiver) checkpoint(db *gorm.DB) (*archiveCheckpoint, bool, error) {
+	cp := &archiveCheckpoint{Job: a.opts.Table}
+	if !a.opts.DryRun {
+		err := db.Table(a.opts.CheckpointTable).Where("job = ?", cp.Job).Take(cp).Error
+		if err == nil && cp.Status == archiveRunning {
+			return cp, true, nil
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, err
+		}
+	}
+	*cp = archiveCheckpoint{
+		Job:       a.opts.Table,
+		Cutoff:    time.Now().Add(-a.opts.OlderThan),
+		Status:    archiveRunning,
+		UpdatedAt: time.Now(),
+	}
+	if a.opts.DryRun {
+		return cp, false, nil
+	}
+	return cp, false, db.Table(a.opts.CheckpointTable).Save(cp).Error
+}
+
+// nextBatch 读取下一批记录的主键, 按归档表分组
+func (a *Archiver) nextBatch(db *gorm.DB, cp *archiveCheckpoint) (map[string]*archiveGroup, int64, error) {
+	rows, err := db.Table(a.opts.Table).
+		Select(a.opts.KeyColumn, a.opts.TimeColumn).
+		Where(clause.Gt{Column: clause.Column{Name: a.opts.KeyColumn}, Value: cp.LastKey}).
+		Where(clause.Lt{Column: clause.Column{Name: a.opts.TimeColumn}, Value: cp.Cutoff}).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: a.opts.KeyColumn}}).
+		Limit(a.opts.BatchSize).Rows()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	groups := make(map[string]*archiveGroup)
+	var lastKey int64
+	for rows.Next() {
+		var key int64
+		t := archiveTime{loc: a.loc}
+		if err = rows.Scan(&key, &t); err != nil {
+			return nil, 0, err
+		}
+		table := ArchiveTable(a.opts.Table, t.Time)
+		g := groups[table]
+		if g == nil {
+			g = &archiveGroup{month: time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())}
+			groups[table] = g
+		}
+		g.keys = append(g.keys, key)
+		lastKey = key
+	}
+	return groups, lastKey, rows.Err()
+}
+
+// archiveTime 时间列的值, DSN 没有开启 parseTime 时驱动返回文本, 按 mysql 的时间格式在连接的时区解析
+type archiveTime struct {
+	time.Time
+	loc *time.Location
+}
+
+func (t *archiveTime) Scan(v interface{}) error {
+	switch v := v.(type) {
+	case time.Time:
+		t.Time = v
+		return nil
+	case []byte:
+		return t.parse(string(v))
+	case string:
+		return t.parse(v)
+	}
+	return fmt.Errorf("时间列的值 %v(%T) 无法解析", v, v)
+}
+
+func (t *archiveTime) parse(s string) error {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999", "2006-01-02"} {
+		v, err := time.ParseInLocation(layout, s, t.loc)
+		if err == nil {
+			t.Time = v
+			return nil
+		}
+	}
+	return fmt.Errorf("时间列的值 %q 无法解析", s)
+}
+
+// move 在同一事务中复制到归档表、从源表删除并更新进度, 返回各归档表写入的记录数.
+// 读取主键后记录可能被修改, 复制和删除时重新检查截止时间和归档表的月份
+func (a *Archiver) move(db *gorm.DB, cp *archiveCheckpoint, groups map[string]*archiveGroup, lastKey int64, created map[string][]string) (map[string]int64, error) {
+	tables := make([]string, 0, len(groups))
+	for table := range groups {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	// 建表会隐式提交事务, 在事务外执行
+	for _, table := range tables {
+		if created[table] != nil {
+			continue
+		}
+		columns, err := a.createArchiveTable(db, table)
+		if err != nil {
+			return nil, fmt.Errorf("create archive table %s: %w", table, err)
+		}
+		created[table] = columns
+	}
+
+	counts := make(map[string]int64, len(tables))
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 锁定进度行, 进度与本次读取时不同说明有其他进程在归档
+		var current archiveCheckpoint
+		if err := tx.Table(a.opts.CheckpointTable).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("job = ?", cp.Job).Take(&current).Error; err != nil {
+			return err
+		}
+		if current.LastKey != cp.LastKey || current.Status != archiveRunning {
+			return fmt.Errorf("%w: %s", ErrArchiveConflict, cp.Job)
+		}
+
+		var moved int64
+		src, key, ts := clause.Table{Name: a.opts.Table}, clause.Column{Name: a.opts.KeyColumn}, clause.Column{Name: a.opts.TimeColumn}
+		for _, table := range tables {
+			g := groups[table]
+			monthEnd := g.month.AddDate(0, 1, 0)
+			columns := columnList(created[table])
+			ins := tx.Exec("INSERT INTO ? (?) SELECT ? FROM ? WHERE ? IN ? AND ? < ? AND ? >= ? AND ? < ?",
+				clause.Table{Name: table}, columns, columns, src, key, g.keys, ts, cp.Cutoff, ts, g.month, ts, monthEnd)
+			if ins.Error != nil {
+				return fmt.Errorf("copy to %s: %w", table, ins.Error)
+			}
+			del := tx.Exec("DELETE FROM ? WHERE ? IN ? AND ? < ? AND ? >= ? AND ? < ?",
+				src, key, g.keys, ts, cp.Cutoff, ts, g.month, ts, monthEnd)
+			if del.Error != nil {
+				return fmt.Errorf("delete from %s: %w", a.opts.Table, del.Error)
+			}
+			if ins.RowsAffected != del.RowsAffected {
+				return fmt.Errorf("归档到 %s 的记录数 %d 与删除数 %d 不一致", table, ins.RowsAffected, del.RowsAffected)
+			}
+			counts[table] = del.RowsAffected
+			moved += del.RowsAffected
+		}
+		return tx.Table(a.opts.CheckpointTable).Where("job = ?", cp.Job).Updates(map[string]interface{}{
+			"last_key":   lastKey,
+			"moved":      cp.Moved + moved,
+			"updated_at": time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// createArchiveTable 创建归档表, 返回源表和归档表共有的列. 源表新增的列不在已有的归档表中, 这些列不归档
+func (a *Archiver) createArchiveTable(db *gorm.DB, table string) ([]string, error) {
+	var err error
+	if db.Dialector.Name() == "mysql" {
+		err = db.Exec("CREATE TABLE IF NOT EXISTS ? LIKE ?", clause.Table{Name: table}, clause.Table{Name: a.opts.Table}).Error
+	} else {
+		// 其他数据库(如测试使用的 sqlite)不支持 LIKE, 只复制列
+		err = db.Exec("CREATE TABLE IF NOT EXISTS ? AS SELECT * FROM ? WHERE 1 = 0", clause.Table{Name: table}, clause.Table{Name: a.opts.Table}).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	srcColumns, err := db.Migrator().ColumnTypes(a.opts.Table)
+	if err != nil {
+		return nil, err
+	}
+	dstColumns, err := db.Migrator().ColumnTypes(table)
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool, len(dstColumns))
+	for _, c := range dstColumns {
+		exists[c.Name()] = true
+	}
+	var columns, missing []string
+	for _, c := range srcColumns {
+		if exists[c.Name()] {
+			columns = append(columns, c.Name())
+		} else {
+			missing = append(missing, c.Name())
+		}
+	}
+	if len(missing) > 0 {
+		zap.L().Warn("archive table missing source columns", zap.String("table", table), zap.Strings("columns", missing))
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("归档表 %s 与 %s 没有相同的列", table, a.opts.Table)
+	}
+	return columns, nil
+}
+
+// columnList 逗号分隔的列名
+func columnList(names []string) clause.Expr {
+	vars := make([]interface{}, len(names))
+	for i, name := range names {
+		vars[i] = clause.Column{Name: name}
+	}
+	return clause.Expr{SQL: strings.TrimSuffix(strings.Repeat("?,", len(names)), ","), Vars: vars}
+}
+
+// waitReplicas 从库延迟超过阈值时等待
+func (a *Archiver) waitReplicas(ctx context.Context) error {
+	if a.opts.ReplicaLag == nil {
+		return nil
+	}
+	for {
+		lag, err := a.opts.ReplicaLag(ctx)
+		if err != nil {
+			return fmt.Errorf("check replica lag: %w", err)
+		}
+		if lag <= a.opts.MaxReplicaLag {
+			return nil
+		}
+		zap.L().Warn("archive paused by replica lag", zap.String("table", a.opts.Table), zap.Duration("lag", lag))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(a.opts.MaxReplicaLag):
+		}
+	}
+}
+
+/*
+ReplicaLagChecker
+
+	@Description: 通过 SHOW REPLICA STATUS(MySQL 8.0.22 之前为 SHOW SLAVE STATUS) 获取从库中的最大延迟, 可用于 ArchiveOptions.ReplicaLag
+	@param replicas: 从库, 如 Cluster.Replicas()
+	@return func(ctx context.Context) (time.Duration, error)
+*/
+func ReplicaLagChecker(replicas ...*DB) func(ctx context.Context) (time.Duration, error) {
+	return func(ctx context.Context) (time.Duration, error) {
+		var max time.Duration
+		for _, r := range replicas {
+			lag, err := replicaLag(ctx, r.SQLDB())
+			if err != nil {
+				return 0, err
+			}
+			if lag > max {
+				max = lag
+			}
+		}
+		return max, nil
+	}
+}
+
+// mysqlErrParse 语法错误, 旧版本不支持 SHOW REPLICA STATUS
+const mysqlErrParse = 1064
+
+func replicaLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if code, ok := mysqlErrorCode(err); ok && code == mysqlErrParse {
+		rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		return 0, errors.Join(ErrReplicaLagUnknown, rows.Err())
+	}
+	values := make([]sql.NullString, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+	for i, col := range cols {
+		if col != "Seconds_Behind_Source" && col != "Seconds_Behind_Master" {
+			continue
+		}
+		// 复制线程未运行时为 NULL
+		if !values[i].Valid {
+			return 0, ErrReplicaLagUnknown
+		}
+		seconds, err := strconv.ParseInt(values[i].String, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, ErrReplicaLagUnknown
+}
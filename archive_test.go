@@ -0,0 +1,278 @@
+package Base_PKG
+
+import (
+	"context"
+	"errors"
+	"github.com/glebarez/sqlite"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+type archiveOrder struct {
+	ID        int64
+	Amount    int
+	CreatedAt time.Time
+}
+
+// archiveDB 2024-01 两条、2024-02 一条需要归档的记录和一条新记录
+func archiveDB(t *testing.T) *DB {
+	t.Helper()
+	d := openSQLiteDB(t, nil, &archiveOrder{})
+	db := d.Conn()
+	orders := []archiveOrder{
+		{Amount: 1, CreatedAt: time.Date(2024, 1, 5, 0, 0, 0, 0, time.Local)},
+		{Amount: 2, CreatedAt: time.Date(2024, 2, 5, 0, 0, 0, 0, time.Local)},
+		{Amount: 3, CreatedAt: time.Date(2024, 1, 20, 0, 0, 0, 0, time.Local)},
+		{Amount: 4, CreatedAt: time.Now()},
+	}
+	if err := db.Create(&orders).Error; err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func countRows(t *testing.T, d *DB, table string) int64 {
+	t.Helper()
+	var n int64
+	if err := d.Conn().Table(table).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestArchiver_DryRun(t *testing.T) {
+	d := archiveDB(t)
+	res, err := NewArchiver(d.Conn(), ArchiveOptions{Table: "archive_order", OlderThan: 24 * time.Hour, BatchSize: 2, Sleep: time.Millisecond, DryRun: true}).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Rows != 3 || res.Batches != 2 || res.Tables["archive_order_archive_202401"] != 2 || res.Tables["archive_order_archive_202402"] != 1 {
+		t.Errorf("Run() = %+v", res)
+	}
+	if n := countRows(t, d, "archive_order"); n != 4 {
+		t.Errorf("source rows = %d, want 4", n)
+	}
+	if d.Conn().Migrator().HasTable("archive_checkpoints") || d.Conn().Migrator().HasTable("archive_order_archive_202401") {
+		t.Errorf("dry run created tables")
+	}
+}
+
+func TestArchiver_Run(t *testing.T) {
+	d := archiveDB(t)
+	ctx := context.Background()
+	opts := ArchiveOptions{Table: "archive_order", OlderThan: 24 * time.Hour, BatchSize: 2, Sleep: time.Millisecond}
+
+	res, err := NewArchiver(d.Conn(), opts).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Rows != 3 || res.Batches != 2 || res.Resumed {
+		t.Errorf("Run() = %+v", res)
+	}
+	tests := []struct {
+		table string
+		want  int64
+	}{
+		{table: "archive_order", want: 1},
+		{table: "archive_order_archive_202401", want: 2},
+		{table: "archive_order_archive_202402", want: 1},
+	}
+	for _, tt := range tests {
+		if n := countRows(t, d, tt.table); n != tt.want {
+			t.Errorf("%s rows = %d, want %d", tt.table, n, tt.want)
+		}
+	}
+	var cp archiveCheckpoint
+	if err = d.Conn().Table("archive_checkpoints").Take(&cp).Error; err != nil {
+		t.Fatal(err)
+	}
+	if cp.Status != archiveDone || cp.Moved != 3 || cp.LastKey != 3 {
+		t.Errorf("checkpoint = %+v", cp)
+	}
+
+	// 源表新增列后继续归档到已有的归档表
+	if err = d.Conn().Exec("ALTER TABLE archive_order ADD COLUMN note TEXT").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Conn().Create(&archiveOrder{Amount: 5, CreatedAt: time.Date(2024, 1, 25, 0, 0, 0, 0, time.Local)}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if res, err = NewArchiver(d.Conn(), opts).Run(ctx); err != nil || res.Rows != 1 {
+		t.Fatalf("Run() after new column = %+v, %v", res, err)
+	}
+	if n := countRows(t, d, "archive_order_archive_202401"); n != 3 {
+		t.Errorf("archive rows = %d, want 3", n)
+	}
+}
+
+func TestArchiver_Checkpoint(t *testing.T) {
+	tests := []struct {
+		name string
+		// 第二批之前执行, 模拟中断或其他进程
+		beforeSecond func(d *DB, cancel context.CancelFunc)
+		wantErr      error
+		wantResume   bool
+	}{
+		{
+			name:         "中断后从进度继续",
+			beforeSecond: func(d *DB, cancel context.CancelFunc) { cancel() },
+			wantErr:      context.Canceled,
+			wantResume:   true,
+		},
+		{
+			name: "其他进程修改进度",
+			beforeSecond: func(d *DB, cancel context.CancelFunc) {
+				d.Conn().Exec("UPDATE archive_checkpoints SET last_key = last_key + 1")
+			},
+			wantErr: ErrArchiveConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := archiveDB(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var calls int
+			opts := ArchiveOptions{
+				Table: "archive_order", OlderThan: 24 * time.Hour, BatchSize: 1, Sleep: time.Millisecond,
+				ReplicaLag: func(context.Context) (time.Duration, error) {
+					if calls++; calls == 2 {
+						tt.beforeSecond(d, cancel)
+					}
+					return 0, nil
+				},
+			}
+			res, err := NewArchiver(d.Conn(), opts).Run(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+			if res.Rows != 1 {
+				t.Errorf("first run rows = %d, want 1", res.Rows)
+			}
+			if !tt.wantResume {
+				return
+			}
+
+			opts.ReplicaLag = nil
+			res, err = NewArchiver(d.Conn(), opts).Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Resumed || res.Rows != 2 {
+				t.Errorf("resumed run = %+v", res)
+			}
+			if n := countRows(t, d, "archive_order"); n != 1 {
+				t.Errorf("source rows = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestArchiver_CutoffRecheck(t *testing.T) {
+	d := archiveDB(t)
+	db := d.Conn()
+	a := NewArchiver(db, ArchiveOptions{Table: "archive_order", OlderThan: 24 * time.Hour})
+	if err := db.Table(a.opts.CheckpointTable).AutoMigrate(&archiveCheckpoint{}); err != nil {
+		t.Fatal(err)
+	}
+	cp, _, err := a.checkpoint(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, lastKey, err := a.nextBatch(db, cp)
+	if err != nil || len(groups["archive_order_archive_202401"].keys) != 2 {
+		t.Fatalf("nextBatch() = %v, %v", groups, err)
+	}
+
+	// 读取主键后记录被更新为新记录, 不应归档
+	if err = db.Model(&archiveOrder{}).Where("id = ?", 1).Update("created_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 被修改到其他月份, 不应写入原月份的归档表
+	if err = db.Model(&archiveOrder{}).Where("id = ?", 3).Update("created_at", time.Date(2023, 12, 10, 0, 0, 0, 0, time.Local)).Error; err != nil {
+		t.Fatal(err)
+	}
+	counts, err := a.move(db, cp, groups, lastKey, map[string][]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["archive_order_archive_202401"] != 0 || counts["archive_order_archive_202402"] != 1 {
+		t.Errorf("move() counts = %v", counts)
+	}
+	for _, id := range []int64{1, 3} {
+		var o archiveOrder
+		if err = db.Take(&o, id).Error; err != nil {
+			t.Errorf("updated row %d archived: %v", id, err)
+		}
+	}
+	if n := countRows(t, d, "archive_order"); n != 3 {
+		t.Errorf("source rows = %d, want 3", n)
+	}
+}
+
+func TestArchiveTime_Scan(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	want := time.Date(2024, 1, 5, 10, 30, 0, 0, loc)
+	tests := []struct {
+		name    string
+		value   interface{}
+		loc     *time.Location
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "time.Time", value: want, loc: loc, want: want},
+		{name: "未开启 parseTime 的文本", value: []byte("2024-01-05 10:30:00"), loc: loc, want: want},
+		{name: "带小数秒", value: "2024-01-05 10:30:00.000000", loc: loc, want: want},
+		{name: "DATE 列", value: []byte("2024-01-05"), loc: loc, want: time.Date(2024, 1, 5, 0, 0, 0, 0, loc)},
+		{name: "按连接时区解析", value: []byte("2024-01-05 10:30:00"), loc: time.UTC, want: time.Date(2024, 1, 5, 10, 30, 0, 0, time.UTC)},
+		{name: "无法解析", value: []byte("not a time"), loc: loc, wantErr: true},
+		{name: "不支持的类型", value: int64(1), loc: loc, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := archiveTime{loc: tt.loc}
+			err := got.Scan(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Scan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Equal(tt.want) {
+				t.Errorf("Scan() = %v, want %v", got.Time, tt.want)
+			}
+		})
+	}
+}
+
+func Test_connLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	tests := []struct {
+		name      string
+		dialector gorm.Dialector
+		want      *time.Location
+	}{
+		{name: "DSN 设置 loc", dialector: mysql.New(mysql.Config{DSN: "u:p@tcp(127.0.0.1:3306)/db?loc=Asia%2FShanghai"}), want: mustLoadLocation(t, "Asia/Shanghai")},
+		{name: "DSNConfig 设置 loc", dialector: mysql.New(mysql.Config{DSNConfig: &mysqlDriver.Config{Loc: loc}}), want: loc},
+		{name: "mysql 默认 UTC", dialector: mysql.New(mysql.Config{DSN: "u:p@tcp(127.0.0.1:3306)/db"}), want: time.UTC},
+		{name: "其他数据库使用本地时区", dialector: sqlite.Open(":memory:"), want: time.Local},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 只需要 Dialector, 不建立连接
+			db := &gorm.DB{Config: &gorm.Config{Dialector: tt.dialector}}
+			if got := connLocation(db); got.String() != tt.want.String() {
+				t.Errorf("connLocation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("load location %s: %v", name, err)
+	}
+	return loc
+}
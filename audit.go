@@ -0,0 +1,511 @@
+package Base_PKG
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	行级审计: 记录模型的新增、修改、删除, 包含操作人和变化列的前后值
+*/
+
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+
+	// auditBeforeKey 修改、删除前的记录快照
+	auditBeforeKey = "base_pkg:audit_before"
+
+	// auditTxKey 插件开启的事务, 值为开启前的连接池
+	auditTxKey = "base_pkg:audit_tx"
+)
+
+type actorCtxKey struct{}
+
+// WithActor 在上下文中设置操作人
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorCtxKey{}, actor)
+}
+
+// ActorFromContext 获取上下文中的操作人
+func ActorFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	actor, _ := ctx.Value(actorCtxKey{}).(string)
+	return actor
+}
+
+// AuditLog
+// @Description: 审计记录, Before/After 为变化列的 json
+type AuditLog struct {
+	ID         uint64    `gorm:"primaryKey"`
+	Table      string    `gorm:"column:table_name;size:128;not null;index:idx_audit_record,priority:1"`
+	PrimaryKey string    `gorm:"size:128;not null;index:idx_audit_record,priority:2"`
+	Action     string    `gorm:"size:16;not null"`
+	Actor      string    `gorm:"size:128;index"`
+	Before     string    `gorm:"type:text"`
+	After      string    `gorm:"type:text"`
+	CreatedAt  time.Time `gorm:"not null;index"`
+}
+
+// AuditModel
+// @Description: 需要审计的模型
+type AuditModel struct {
+	// 模型, 如 &User{}
+	Model interface{}
+
+	// 不记录的列, 如密码
+	Ignore []string
+}
+
+// AuditOptions
+// @Description: 审计插件配置
+type AuditOptions struct {
+	// 需要审计的模型, 未列出的模型不记录
+	Models []AuditModel
+
+	// 异步写入, 通过缓冲通道由后台批量写入, 在 WithTx 事务中时提交后写入. db.Transaction 等非 WithTx 事务
+	// (包括 gorm 默认事务)无法得知是否提交, 这时同步写入该事务, 随事务一起提交或回滚;
+	// 默认同步写入, 与业务写入在同一事务, 不在事务中时由插件开启事务, 审计写入失败时业务写入回滚并返回错误.
+	// 插件开启的事务从 gorm:create/update/delete 开始, 之前保存的 belongs to 关联不在事务中
+	Async bool
+
+	// 异步缓冲大小, 缓冲满时阻塞, 默认 1024
+	BufferSize int
+
+	// 修改、删除前读取的最大记录数, 超过的部分不记录, 默认 1000
+	MaxRows int
+}
+
+type auditModel struct {
+	schema *schema.Schema
+	ignore map[string]bool
+}
+
+// auditRow 单条记录的快照
+type auditRow struct {
+	pk       string
+	pkValues []interface{}
+	values   map[string]interface{}
+}
+
+/*
+AuditPlugin
+
+	@Description: 审计插件, 通过 db.Use 注册. 通过 Raw/Exec 执行的写入不会记录
+*/
+type AuditPlugin struct {
+	opts   AuditOptions
+	db     *gorm.DB
+	models map[string]*auditModel
+
+	records chan AuditLog
+
+	// 写入缓冲时持有读锁, 保证 Stop 后不再写入缓冲
+	mu      sync.RWMutex
+	stopped bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+/*
+NewAuditPlugin
+
+	@Description: 创建审计插件
+	@param opts: 插件配置
+	@return *AuditPlugin
+*/
+func NewAuditPlugin(opts AuditOptions) *AuditPlugin {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 1024
+	}
+	if opts.MaxRows <= 0 {
+		opts.MaxRows = 1000
+	}
+	return &AuditPlugin{opts: opts}
+}
+
+// Name 实现 gorm.Plugin
+func (p *AuditPlugin) Name() string {
+	return "base_pkg:audit"
+}
+
+// Initialize 实现 gorm.Plugin, 异步写入时启动后台任务
+func (p *AuditPlugin) Initialize(db *gorm.DB) error {
+	p.db = db
+	p.models = make(map[string]*auditModel, len(p.opts.Models))
+	for _, m := range p.opts.Models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m.Model); err != nil {
+			return fmt.Errorf("parse audit model error: %w", err)
+		}
+		am := &auditModel{schema: stmt.Schema, ignore: make(map[string]bool, len(m.Ignore))}
+		for _, col := range m.Ignore {
+			am.ignore[col] = true
+		}
+		p.models[stmt.Table] = am
+	}
+
+	// 审计记录在 gorm 默认事务提交前写入; 同步写入时插件开启的事务在所有回调之后提交
+	cb := db.Callback()
+	callbacks := []error{
+		cb.Create().Before("gorm:commit_or_rollback_transaction").Register("base_pkg:audit_create", p.afterCreate),
+		cb.Update().Before("gorm:update").Register("base_pkg:audit_before_update", p.before),
+		cb.Update().Before("gorm:commit_or_rollback_transaction").Register("base_pkg:audit_update", p.afterUpdate),
+		cb.Delete().Before("gorm:delete").Register("base_pkg:audit_before_delete", p.before),
+		cb.Delete().Before("gorm:commit_or_rollback_transaction").Register("base_pkg:audit_delete", p.afterDelete),
+	}
+	if !p.opts.Async {
+		callbacks = append([]error{
+			cb.Create().Before("gorm:create").Register("base_pkg:audit_begin", p.beginTx),
+			cb.Update().Before("gorm:update").Register("base_pkg:audit_begin", p.beginTx),
+			cb.Delete().Before("gorm:delete").Register("base_pkg:audit_begin", p.beginTx),
+		}, callbacks...)
+		callbacks = append(callbacks,
+			cb.Create().After("gorm:commit_or_rollback_transaction").Register("base_pkg:audit_commit", p.commitTx),
+			cb.Update().After("gorm:commit_or_rollback_transaction").Register("base_pkg:audit_commit", p.commitTx),
+			cb.Delete().After("gorm:commit_or_rollback_transaction").Register("base_pkg:audit_commit", p.commitTx),
+		)
+	}
+	for _, err := range callbacks {
+		if err != nil {
+			return fmt.Errorf("register audit callback error: %w", err)
+		}
+	}
+
+	if p.opts.Async {
+		p.records = make(chan AuditLog, p.opts.BufferSize)
+		p.stop = make(chan struct{})
+		p.done = make(chan struct{})
+		go p.run()
+	}
+	return nil
+}
+
+// AutoMigrate 创建审计表
+func (p *AuditPlugin) AutoMigrate() error {
+	return p.db.AutoMigrate(&AuditLog{})
+}
+
+// model 本次操作的审计配置, 不需要审计时返回 nil
+func (p *AuditPlugin) model(db *gorm.DB) *auditModel {
+	if db.Error != nil || db.DryRun || db.Statement.Schema == nil {
+		return nil
+	}
+	return p.models[db.Statement.Table]
+}
+
+// beginTx 同步写入且不在事务中时开启事务, 业务写入与审计记录一起提交
+func (p *AuditPlugin) beginTx(db *gorm.DB) {
+	if p.model(db) == nil {
+		return
+	}
+	pool := db.Statement.ConnPool
+	if _, ok := pool.(gorm.TxCommitter); ok {
+		return
+	}
+	tx := db.Begin()
+	if tx.Error != nil {
+		_ = db.AddError(fmt.Errorf("audit begin transaction error: %w", tx.Error))
+		return
+	}
+	db.Statement.ConnPool = tx.Statement.ConnPool
+	db.InstanceSet(auditTxKey, pool)
+}
+
+// commitTx 提交 beginTx 开启的事务, 业务或审计写入失败时回滚
+func (p *AuditPlugin) commitTx(db *gorm.DB) {
+	v, _ := db.InstanceGet(auditTxKey)
+	pool, ok := v.(gorm.ConnPool)
+	if !ok {
+		return
+	}
+	// 链式调用会复用 Statement, 清空后之后的语句不会重复提交
+	db.InstanceSet(auditTxKey, nil)
+	if db.Error != nil {
+		db.Rollback()
+	} else {
+		db.Commit()
+	}
+	db.Statement.ConnPool = pool
+}
+
+// before 修改、删除前读取受影响记录的快照
+func (p *AuditPlugin) before(db *gorm.DB) {
+	am := p.model(db)
+	if am == nil {
+		return
+	}
+	stmt := db.Statement
+	var exprs []clause.Expression
+	if where, ok := stmt.Clauses["WHERE"]; ok && where.Expression != nil {
+		exprs = append(exprs, where.Expression)
+	}
+	if stmt.ReflectValue.Kind() == reflect.Struct {
+		for _, pf := range am.schema.PrimaryFields {
+			if v, zero := pf.ValueOf(stmt.Context, stmt.ReflectValue); !zero {
+				exprs = append(exprs, clause.Where{Exprs: []clause.Expression{
+					clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: pf.DBName}, Value: v},
+				}})
+			}
+		}
+	}
+	// 没有条件的全表写入不读取快照
+	if len(exprs) == 0 {
+		return
+	}
+	rows, err := p.snapshot(db, am, false, exprs...)
+	if err != nil {
+		_ = db.AddError(fmt.Errorf("audit snapshot error: %w", err))
+		return
+	}
+	db.InstanceSet(auditBeforeKey, rows)
+}
+
+// snapshot 在同一连接上读取符合条件的记录. 写入前的快照与写入语句一样只在 Unscoped 时包含软删除的记录,
+// 写入后按主键重新读取时 unscoped 为 true, 包含本次写入软删除的记录
+func (p *AuditPlugin) snapshot(db *gorm.DB, am *auditModel, unscoped bool, exprs ...clause.Expression) ([]auditRow, error) {
+	tx := db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+	if unscoped || db.Statement.Unscoped {
+		tx = tx.Unscoped()
+	}
+	list := reflect.New(reflect.SliceOf(am.schema.ModelType))
+	if err := tx.Model(reflect.New(am.schema.ModelType).Interface()).Clauses(exprs...).
+		Limit(p.opts.MaxRows).Find(list.Interface()).Error; err != nil {
+		return nil, err
+	}
+	return am.rows(db.Statement.Context, list.Elem()), nil
+}
+
+// rows 将模型值转换为快照
+func (am *auditModel) rows(ctx context.Context, rv reflect.Value) []auditRow {
+	rv = reflect.Indirect(rv)
+	if rv.Kind() == reflect.Struct {
+		return []auditRow{am.row(ctx, rv)}
+	}
+	res := make([]auditRow, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		res = append(res, am.row(ctx, reflect.Indirect(rv.Index(i))))
+	}
+	return res
+}
+
+func (am *auditModel) row(ctx context.Context, rv reflect.Value) auditRow {
+	r := auditRow{values: make(map[string]interface{}, len(am.schema.Fields))}
+	pks := make([]string, 0, len(am.schema.PrimaryFields))
+	for _, pf := range am.schema.PrimaryFields {
+		v, _ := pf.ValueOf(ctx, rv)
+		r.pkValues = append(r.pkValues, v)
+		pks = append(pks, fmt.Sprint(v))
+	}
+	r.pk = strings.Join(pks, ",")
+	for _, f := range am.schema.Fields {
+		if f.DBName == "" || am.ignore[f.DBName] {
+			continue
+		}
+		r.values[f.DBName], _ = f.ValueOf(ctx, rv)
+	}
+	return r
+}
+
+// pkCondition 按快照的主键生成条件
+func (am *auditModel) pkCondition(rows []auditRow) clause.Expression {
+	ors := make([]clause.Expression, 0, len(rows))
+	for _, r := range rows {
+		eqs := make([]clause.Expression, 0, len(am.schema.PrimaryFields))
+		for i, pf := range am.schema.PrimaryFields {
+			eqs = append(eqs, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: pf.DBName}, Value: r.pkValues[i]})
+		}
+		ors = append(ors, clause.And(eqs...))
+	}
+	return clause.Where{Exprs: []clause.Expression{clause.Or(ors...)}}
+}
+
+func (p *AuditPlugin) afterCreate(db *gorm.DB) {
+	am := p.model(db)
+	if am == nil || db.RowsAffected == 0 {
+		return
+	}
+	var logs []AuditLog
+	for _, r := range am.rows(db.Statement.Context, db.Statement.ReflectValue) {
+		logs = append(logs, p.newLog(db, AuditCreate, r.pk, nil, r.values))
+	}
+	p.write(db, logs)
+}
+
+func (p *AuditPlugin) afterUpdate(db *gorm.DB) {
+	am := p.model(db)
+	if am == nil || db.RowsAffected == 0 {
+		return
+	}
+	v, ok := db.InstanceGet(auditBeforeKey)
+	before, _ := v.([]auditRow)
+	if !ok || len(before) == 0 {
+		return
+	}
+	// 修改后重新读取, 包含 version + 1 等表达式的结果
+	after, err := p.snapshot(db, am, true, am.pkCondition(before))
+	if err != nil {
+		_ = db.AddError(fmt.Errorf("audit snapshot error: %w", err))
+		return
+	}
+	afterByPK := make(map[string]auditRow, len(after))
+	for _, r := range after {
+		afterByPK[r.pk] = r
+	}
+
+	var logs []AuditLog
+	for _, b := range before {
+		a, ok := afterByPK[b.pk]
+		if !ok {
+			continue
+		}
+		oldValues, newValues := diffValues(b.values, a.values)
+		if len(newValues) == 0 {
+			continue
+		}
+		logs = append(logs, p.newLog(db, AuditUpdate, b.pk, oldValues, newValues))
+	}
+	p.write(db, logs)
+}
+
+func (p *AuditPlugin) afterDelete(db *gorm.DB) {
+	am := p.model(db)
+	if am == nil || db.RowsAffected == 0 {
+		return
+	}
+	v, _ := db.InstanceGet(auditBeforeKey)
+	before, _ := v.([]auditRow)
+	var logs []AuditLog
+	for _, b := range before {
+		logs = append(logs, p.newLog(db, AuditDelete, b.pk, b.values, nil))
+	}
+	p.write(db, logs)
+}
+
+// diffValues 值发生变化的列
+func diffValues(before, after map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	oldValues, newValues := make(map[string]interface{}), make(map[string]interface{})
+	for col, a := range after {
+		b := before[col]
+		bj, _ := json.Marshal(b)
+		aj, _ := json.Marshal(a)
+		if !bytes.Equal(bj, aj) {
+			oldValues[col], newValues[col] = b, a
+		}
+	}
+	return oldValues, newValues
+}
+
+func (p *AuditPlugin) newLog(db *gorm.DB, action string, pk string, before, after map[string]interface{}) AuditLog {
+	return AuditLog{
+		Table:      db.Statement.Table,
+		PrimaryKey: pk,
+		Action:     action,
+		Actor:      ActorFromContext(db.Statement.Context),
+		Before:     marshalAudit(before),
+		After:      marshalAudit(after),
+		CreatedAt:  db.NowFunc(),
+	}
+}
+
+func marshalAudit(values map[string]interface{}) string {
+	if values == nil {
+		return ""
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Sprintf(`{"error": %q}`, err.Error())
+	}
+	return string(b)
+}
+
+// write 同步写入时在业务连接上写入, 异步写入时在 WithTx 提交后或不在事务中时放入缓冲,
+// 在非 WithTx 的事务中同步写入
+func (p *AuditPlugin) write(db *gorm.DB, logs []AuditLog) {
+	if len(logs) == 0 {
+		return
+	}
+	_, inTx := db.Statement.ConnPool.(gorm.TxCommitter)
+	if !p.opts.Async || (inTx && !inWithTx(db.Statement.Context)) {
+		if err := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Create(&logs).Error; err != nil {
+			_ = db.AddError(fmt.Errorf("write audit log error: %w", err))
+		}
+		return
+	}
+	AfterCommit(db.Statement.Context, func() {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		if p.stopped {
+			p.flush(logs)
+			return
+		}
+		for _, l := range logs {
+			p.records <- l
+		}
+	})
+}
+
+// run 异步批量写入, 每 100 条或每秒写入一次
+func (p *AuditPlugin) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	batch := make([]AuditLog, 0, 100)
+	for {
+		select {
+		case l := <-p.records:
+			if batch = append(batch, l); len(batch) >= 100 {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			p.flush(batch)
+			batch = batch[:0]
+		case <-p.stop:
+			for {
+				select {
+				case l := <-p.records:
+					batch = append(batch, l)
+				default:
+					p.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *AuditPlugin) flush(logs []AuditLog) {
+	if len(logs) == 0 {
+		return
+	}
+	if err := p.db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Create(&logs).Error; err != nil {
+		zap.L().Error("write audit log found error", zap.Int("count", len(logs)), zap.Error(err))
+	}
+}
+
+// Stop 停止异步写入并写入缓冲中剩余的记录, 之后的记录直接写入
+func (p *AuditPlugin) Stop() {
+	p.mu.Lock()
+	if p.stopped || p.stop == nil {
+		p.mu.Unlock()
+		return
+	}
+	p.stopped = true
+	p.mu.Unlock()
+	close(p.stop)
+	<-p.done
+}
@@ -0,0 +1,266 @@
+package Base_PKG
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+	"time"
+)
+
+type auditUser struct {
+	ID       uint
+	Name     string
+	Age      int
+	Password string
+}
+
+// auditDB 打开使用默认配置(包含默认超时)的数据库并注册审计插件
+func auditDB(t *testing.T, async bool) (*DB, *AuditPlugin) {
+	t.Helper()
+	d := openSQLiteDB(t, nil, &auditUser{})
+	p := NewAuditPlugin(AuditOptions{
+		Models: []AuditModel{{Model: &auditUser{}, Ignore: []string{"password"}}},
+		Async:  async,
+	})
+	if err := d.Conn().Use(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	return d, p
+}
+
+func auditValues(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	if s == "" {
+		return nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestAuditPlugin_Sync(t *testing.T) {
+	d, _ := auditDB(t, false)
+	ctx := WithActor(context.Background(), "alice")
+	db := d.Conn().WithContext(ctx)
+
+	u := auditUser{Name: "bob", Age: 20, Password: "secret"}
+	if err := db.Create(&u).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := d.Conn().WithContext(ctx).Model(&u).Updates(auditUser{Age: 21, Password: "changed"}).Error; err != nil {
+		t.Fatalf("Updates() error = %v", err)
+	}
+	// 没有变化的修改不记录
+	if err := d.Conn().WithContext(ctx).Model(&u).Update("name", "bob").Error; err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := d.Conn().WithContext(ctx).Delete(&auditUser{}, u.ID).Error; err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	var logs []AuditLog
+	if err := d.Conn().Order("id").Find(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		action     string
+		wantBefore map[string]interface{}
+		wantAfter  map[string]interface{}
+	}{
+		{action: AuditCreate, wantAfter: map[string]interface{}{"id": 1.0, "name": "bob", "age": 20.0}},
+		{action: AuditUpdate, wantBefore: map[string]interface{}{"age": 20.0}, wantAfter: map[string]interface{}{"age": 21.0}},
+		{action: AuditDelete, wantBefore: map[string]interface{}{"id": 1.0, "name": "bob", "age": 21.0}},
+	}
+	if len(logs) != len(tests) {
+		t.Fatalf("audit logs = %+v, want %d", logs, len(tests))
+	}
+	for i, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			l := logs[i]
+			if l.Action != tt.action || l.Table != "audit_user" || l.PrimaryKey != "1" || l.Actor != "alice" {
+				t.Errorf("log = %+v", l)
+			}
+			if got := auditValues(t, l.Before); !equalJSON(got, tt.wantBefore) {
+				t.Errorf("before = %v, want %v", got, tt.wantBefore)
+			}
+			if got := auditValues(t, l.After); !equalJSON(got, tt.wantAfter) {
+				t.Errorf("after = %v, want %v", got, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func TestAuditPlugin_Async(t *testing.T) {
+	d, p := auditDB(t, true)
+	ctx := context.Background()
+
+	if err := d.Conn().WithContext(ctx).Create(&auditUser{Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 回滚的事务不记录
+	errRollback := errors.New("rollback")
+	err := d.WithTx(ctx, func(tx *gorm.DB) error {
+		if err := tx.Create(&auditUser{Name: "b"}).Error; err != nil {
+			return err
+		}
+		return errRollback
+	}, nil)
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx() error = %v", err)
+	}
+	if err = d.WithTx(ctx, func(tx *gorm.DB) error {
+		return tx.Create(&auditUser{Name: "c"}).Error
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 非 WithTx 事务中同步写入, 回滚时一起回滚
+	err = d.Conn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&auditUser{Name: "d"}).Error; err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("Transaction() error = %v", err)
+	}
+	if err = d.Conn().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return tx.Create(&auditUser{Name: "e"}).Error
+	}); err != nil {
+		t.Fatal(err)
+	}
+	p.Stop()
+
+	var logs []AuditLog
+	if err = d.Conn().Order("id").Find(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	var names []interface{}
+	for _, l := range logs {
+		names = append(names, auditValues(t, l.After)["name"])
+	}
+	if len(names) != 3 || !containsAll(names, "a", "c", "e") {
+		t.Errorf("async audit names = %v, want [a c e]", names)
+	}
+}
+
+func containsAll(values []interface{}, want ...interface{}) bool {
+	for _, w := range want {
+		found := false
+		for _, v := range values {
+			found = found || v == w
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+type auditSoftUser struct {
+	ID        uint
+	Name      string
+	DeletedAt gorm.DeletedAt
+}
+
+func TestAuditPlugin_SoftDeleted(t *testing.T) {
+	db := openSQLiteDB(t, nil, &auditSoftUser{}, &AuditLog{}).Conn()
+	p := NewAuditPlugin(AuditOptions{Models: []AuditModel{{Model: &auditSoftUser{}}}})
+	if err := db.Use(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&[]auditSoftUser{{Name: "a"}, {Name: "b"}}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(&auditSoftUser{}, 2).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		write  func() error
+		action string
+		want   []string
+	}{
+		{name: "修改不记录软删除的记录", action: "update", want: []string{"1"}, write: func() error {
+			return db.Model(&auditSoftUser{}).Where("id > ?", 0).Update("name", "x").Error
+		}},
+		{name: "Unscoped 修改记录软删除的记录", action: "update", want: []string{"1", "2"}, write: func() error {
+			return db.Unscoped().Model(&auditSoftUser{}).Where("id > ?", 0).Update("name", "y").Error
+		}},
+		{name: "修改时软删除的记录仍记录", action: "update", want: []string{"1"}, write: func() error {
+			return db.Model(&auditSoftUser{}).Where("id = ?", 1).Update("deleted_at", time.Now()).Error
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var last int64
+			db.Model(&AuditLog{}).Select("COALESCE(MAX(id), 0)").Scan(&last)
+			if err := tt.write(); err != nil {
+				t.Fatal(err)
+			}
+			var pks []string
+			if err := db.Model(&AuditLog{}).Where("id > ? AND action = ?", last, tt.action).Order("primary_key").Pluck("primary_key", &pks).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(pks) != len(tt.want) || strings.Join(pks, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("audited pks = %v, want %v", pks, tt.want)
+			}
+		})
+	}
+}
+
+func equalJSON(a, b map[string]interface{}) bool {
+	aj, _ := json.Marshal(a)
+	bj, _ := json.Marshal(b)
+	return string(aj) == string(bj)
+}
+
+func TestAuditPlugin_SyncWriteFailed(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *DBOptions
+	}{
+		{name: "插件开启事务"},
+		{name: "gorm 默认事务", opts: &DBOptions{DefaultTransaction: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 没有创建审计表, 审计写入失败
+			d := openSQLiteDB(t, tt.opts, &auditUser{})
+			p := NewAuditPlugin(AuditOptions{Models: []AuditModel{{Model: &auditUser{}}}})
+			if err := d.Conn().Use(p); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Conn().Exec("INSERT INTO audit_user (id, name) VALUES (1, 'a')").Error; err != nil {
+				t.Fatal(err)
+			}
+
+			if err := d.Conn().Create(&auditUser{Name: "b"}).Error; err == nil {
+				t.Errorf("Create() error = nil, want audit error")
+			}
+			if err := d.Conn().Model(&auditUser{ID: 1}).Update("name", "c").Error; err == nil {
+				t.Errorf("Update() error = nil, want audit error")
+			}
+			if err := d.Conn().Delete(&auditUser{}, 1).Error; err == nil {
+				t.Errorf("Delete() error = nil, want audit error")
+			}
+
+			// 业务写入随审计写入一起回滚
+			var users []auditUser
+			if err := d.Conn().Find(&users).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(users) != 1 || users[0].Name != "a" {
+				t.Errorf("users after failed audit = %+v, want only a", users)
+			}
+		})
+	}
+}
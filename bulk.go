@@ -0,0 +1,286 @@
+package Base_PKG
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sync"
+	"time"
+)
+
+/*
+	批量写入, 按行数和 max_allowed_packet 估算的字节数分块, 支持 ON DUPLICATE KEY UPDATE
+*/
+
+// 默认 max_allowed_packet, 无法查询时使用
+const defaultMaxPacketBytes = 4 << 20
+
+// 单条预处理语句的最大占位符数量
+const maxPlaceholders = 65535
+
+// BulkOptions
+// @Description: 批量写入配置
+type BulkOptions struct {
+	// 每块最大行数, 默认 1000
+	ChunkRows int
+
+	// 每块最大字节数, 默认取数据库 max_allowed_packet 的 90%
+	MaxPacketBytes int
+
+	// 冲突时需要更新的列, 为空时为普通插入
+	UpdateColumns []string
+
+	// 每块遇到临时错误的最大重试次数, 0 使用默认值 3, 负数不重试.
+	// 连接断开时语句可能已经提交, 只有设置了 UpdateColumns 的 upsert 在连接断开后重试
+	MaxRetries int
+
+	// 重试退避的初始时间, 默认 50ms
+	Backoff time.Duration
+
+	// 某块失败后继续写入后续的块
+	ContinueOnError bool
+}
+
+// ChunkResult
+// @Description: 单块写入结果. upsert 时按影响行数估算: 新增行计 1, 更新行计 2, 值未变化的行计入 Updated
+type ChunkResult struct {
+	Index    int
+	Rows     int
+	Bytes    int
+	Inserted int64
+	Updated  int64
+	Failed   int
+	Attempts int
+	Err      error
+}
+
+// BulkResult
+// @Description: 批量写入汇总
+type BulkResult struct {
+	Chunks   []ChunkResult
+	Inserted int64
+	Updated  int64
+	Failed   int64
+}
+
+/*
+BulkWriter
+
+	@Description: 模型 T 的批量写入器
+*/
+type BulkWriter[T any] struct {
+	db   *gorm.DB
+	opts BulkOptions
+
+	packetOnce sync.Once
+}
+
+/*
+NewBulkWriter
+
+	@Description: 创建批量写入器
+	@param db: gorm 对象, 为 nil 时使用 GetDBConn
+	@param opts: 写入配置, 为 nil 时使用默认配置
+	@return *BulkWriter[T]
+*/
+func NewBulkWriter[T any](db *gorm.DB, opts *BulkOptions) *BulkWriter[T] {
+	if db == nil {
+		db = GetDBConn()
+	}
+	var o BulkOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.ChunkRows <= 0 {
+		o.ChunkRows = 1000
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 3
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = 50 * time.Millisecond
+	}
+	return &BulkWriter[T]{db: db, opts: o}
+}
+
+/*
+Write
+
+	@Description: 分块写入, ContinueOnError 为 false 时在第一个失败的块停止
+	@param rows: 需要写入的记录
+	@return *BulkResult: 已处理块的结果
+	@return error: 失败块的错误
+*/
+func (w *BulkWriter[T]) Write(ctx context.Context, rows []T) (*BulkResult, error) {
+	res := &BulkResult{}
+	if len(rows) == 0 {
+		return res, nil
+	}
+	db := w.db.WithContext(ctx)
+	w.packetOnce.Do(func() {
+		if w.opts.MaxPacketBytes <= 0 {
+			w.opts.MaxPacketBytes = maxPacketBytes(db) * 9 / 10
+		}
+	})
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for i, chunk := range w.chunks(ctx, stmt.Schema, rows) {
+		cr := w.writeChunk(ctx, db, rows[chunk.start:chunk.end])
+		cr.Index, cr.Bytes = i, chunk.bytes
+		res.Chunks = append(res.Chunks, cr)
+		res.Inserted += cr.Inserted
+		res.Updated += cr.Updated
+		res.Failed += int64(cr.Failed)
+		if cr.Err != nil {
+			errs = append(errs, fmt.Errorf("chunk %d: %w", i, cr.Err))
+			if !w.opts.ContinueOnError {
+				break
+			}
+		}
+	}
+	return res, errors.Join(errs...)
+}
+
+type chunkRange struct {
+	start, end, bytes int
+}
+
+// chunks 按行数和估算字节数划分块
+func (w *BulkWriter[T]) chunks(ctx context.Context, sch *schema.Schema, rows []T) []chunkRange {
+	// 语句头部: INSERT INTO ... (列名) VALUES 以及 ON DUPLICATE KEY UPDATE 部分
+	header, columns := 64+len(sch.Table), 0
+	for _, f := range sch.Fields {
+		if f.Creatable && f.DBName != "" {
+			header += len(f.DBName) + 3
+			columns++
+		}
+	}
+	// 预处理语句最多 65535 个占位符
+	maxRows := w.opts.ChunkRows
+	if columns > 0 && maxRows > maxPlaceholders/columns {
+		maxRows = maxPlaceholders / columns
+	}
+	for _, c := range w.opts.UpdateColumns {
+		header += 2*len(c) + 16
+	}
+
+	var (
+		res []chunkRange
+		cur = chunkRange{bytes: header}
+	)
+	for i := range rows {
+		size := estimateRowBytes(ctx, sch, reflect.ValueOf(&rows[i]).Elem())
+		if cur.end > cur.start && (cur.end-cur.start >= maxRows || cur.bytes+size > w.opts.MaxPacketBytes) {
+			res = append(res, cur)
+			cur = chunkRange{start: i, end: i, bytes: header}
+		}
+		cur.end++
+		cur.bytes += size
+	}
+	return append(res, cur)
+}
+
+// writeChunk 写入单块, 临时错误时重试
+func (w *BulkWriter[T]) writeChunk(ctx context.Context, db *gorm.DB, rows []T) ChunkResult {
+	cr := ChunkResult{Rows: len(rows)}
+	for {
+		cr.Attempts++
+		tx := db.Session(&gorm.Session{CreateBatchSize: len(rows)})
+		if len(w.opts.UpdateColumns) > 0 {
+			tx = tx.Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns(w.opts.UpdateColumns)})
+		}
+		result := tx.Create(&rows)
+		if result.Error == nil {
+			cr.Inserted, cr.Updated = splitAffected(int64(len(rows)), result.RowsAffected, len(w.opts.UpdateColumns) > 0)
+			cr.Err = nil
+			return cr
+		}
+		cr.Err = TranslateError(result.Error)
+		if cr.Attempts > w.opts.MaxRetries || !isTransientError(result.Error, len(w.opts.UpdateColumns) > 0) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			cr.Err = errors.Join(cr.Err, ctx.Err())
+			cr.Failed = len(rows)
+			return cr
+		case <-time.After(backoff(cr.Attempts-1, w.opts.Backoff, 20*w.opts.Backoff)):
+		}
+	}
+	cr.Failed = len(rows)
+	return cr
+}
+
+// splitAffected 根据影响行数估算新增和更新的行数
+func splitAffected(rows int64, affected int64, upsert bool) (inserted int64, updated int64) {
+	if !upsert {
+		return affected, 0
+	}
+	if affected >= rows {
+		updated = affected - rows
+		return rows - updated, updated
+	}
+	// 部分行值未变化, 影响行数为 0
+	return affected, rows - affected
+}
+
+// estimateRowBytes 估算单行在 VALUES 中占用的字节数
+func estimateRowBytes(ctx context.Context, sch *schema.Schema, rv reflect.Value) int {
+	size := 3 // (),
+	for _, f := range sch.Fields {
+		if !f.Creatable || f.DBName == "" {
+			continue
+		}
+		v, zero := f.ValueOf(ctx, rv)
+		size++ // 分隔符
+		if zero && v == nil {
+			size += 4
+			continue
+		}
+		switch val := reflect.Indirect(reflect.ValueOf(v)); val.Kind() {
+		case reflect.String:
+			// 转义最多使长度翻倍
+			size += 2*val.Len() + 2
+		case reflect.Slice:
+			size += 2*val.Len() + 3
+		case reflect.Invalid:
+			size += 4
+		default:
+			size += 32
+		}
+	}
+	return size
+}
+
+// maxPacketBytes 查询数据库的 max_allowed_packet
+func maxPacketBytes(db *gorm.DB) int {
+	var size int
+	if err := db.Raw("SELECT @@max_allowed_packet").Scan(&size).Error; err != nil || size <= 0 {
+		return defaultMaxPacketBytes
+	}
+	return size
+}
+
+// isTransientError 是否为可重试的临时错误: 死锁、锁等待超时、连接数过多.
+// 连接失效时语句可能已经提交, 普通插入重试会重复写入自增行或误报重复键, 只有 upsert 可以重复执行
+func isTransientError(err error, upsert bool) bool {
+	code, ok := mysqlErrorCode(err)
+	if IsRetryableTxError(err) || (ok && code == 1040) {
+		return true
+	}
+	if !upsert {
+		return false
+	}
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysqlDriver.ErrInvalidConn) || (ok && (code == 2006 || code == 2013))
+}
@@ -0,0 +1,175 @@
+package Base_PKG
+
+import (
+	"context"
+	"database/sql/driver"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bulkItem struct {
+	ID   uint
+	Name string
+}
+
+func TestBulkWriter_Chunks(t *testing.T) {
+	d := openSQLiteDB(t, nil)
+	stmt := &gorm.Statement{DB: d.Conn()}
+	if err := stmt.Parse(&bulkItem{}); err != nil {
+		t.Fatal(err)
+	}
+	rows := []bulkItem{{Name: "a"}, {Name: "b"}, {Name: strings.Repeat("c", 100)}, {Name: "d"}, {Name: "e"}}
+	tests := []struct {
+		name string
+		opts BulkOptions
+		want [][2]int
+	}{
+		{name: "按行数分块", opts: BulkOptions{ChunkRows: 2, MaxPacketBytes: 1 << 20}, want: [][2]int{{0, 2}, {2, 4}, {4, 5}}},
+		{name: "按字节数分块", opts: BulkOptions{ChunkRows: 10, MaxPacketBytes: 200}, want: [][2]int{{0, 2}, {2, 3}, {3, 5}}},
+		{name: "单行超过字节数时单独成块", opts: BulkOptions{ChunkRows: 10, MaxPacketBytes: 10}, want: [][2]int{{0, 1}, {1, 2}, {2, 3}, {3, 4}, {4, 5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewBulkWriter[bulkItem](d.Conn(), &tt.opts)
+			got := w.chunks(context.Background(), stmt.Schema, rows)
+			if len(got) != len(tt.want) {
+				t.Fatalf("chunks = %+v, want %v", got, tt.want)
+			}
+			for i, c := range got {
+				if c.start != tt.want[i][0] || c.end != tt.want[i][1] {
+					t.Errorf("chunk %d = [%d, %d), want %v", i, c.start, c.end, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBulkWriter_Write(t *testing.T) {
+	d := openSQLiteDB(t, nil, &bulkItem{})
+	db := d.Conn()
+	ctx := context.Background()
+
+	rows := []bulkItem{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	res, err := NewBulkWriter[bulkItem](db, &BulkOptions{ChunkRows: 2}).Write(ctx, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Chunks) != 2 || res.Inserted != 3 || rows[2].ID == 0 {
+		t.Errorf("Write() = %+v, rows = %+v", res, rows)
+	}
+
+	// 主键冲突时更新指定列
+	upsert := []bulkItem{{ID: 1, Name: "a2"}, {ID: 4, Name: "d"}}
+	if _, err = NewBulkWriter[bulkItem](db, &BulkOptions{UpdateColumns: []string{"name"}}).Write(ctx, upsert); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	db.Model(&bulkItem{}).Order("id").Pluck("name", &names)
+	if strings.Join(names, ",") != "a2,b,c,d" {
+		t.Errorf("names = %v, want [a2 b c d]", names)
+	}
+
+	// 失败的块不重试普通错误, ContinueOnError 时继续写入后续的块
+	dup := []bulkItem{{ID: 1, Name: "x"}, {ID: 5, Name: "e"}}
+	res, err = NewBulkWriter[bulkItem](db, &BulkOptions{ChunkRows: 1, ContinueOnError: true}).Write(ctx, dup)
+	if err == nil || res.Failed != 1 || res.Inserted != 1 || res.Chunks[0].Attempts != 1 {
+		t.Errorf("Write() duplicated = %+v, %v", res, err)
+	}
+	res, err = NewBulkWriter[bulkItem](db, &BulkOptions{ChunkRows: 1}).Write(ctx, []bulkItem{{ID: 1}, {ID: 6}})
+	if err == nil || len(res.Chunks) != 1 {
+		t.Errorf("Write() stop on error = %+v, %v", res, err)
+	}
+}
+
+func TestSplitAffected(t *testing.T) {
+	tests := []struct {
+		name                  string
+		rows, affected        int64
+		upsert                bool
+		wantInserted, wantUpd int64
+	}{
+		{name: "普通插入", rows: 3, affected: 3, wantInserted: 3},
+		{name: "全部新增", rows: 3, affected: 3, upsert: true, wantInserted: 3},
+		{name: "一行更新", rows: 3, affected: 4, upsert: true, wantInserted: 2, wantUpd: 1},
+		{name: "全部更新", rows: 3, affected: 6, upsert: true, wantUpd: 3},
+		{name: "值未变化", rows: 3, affected: 1, upsert: true, wantInserted: 1, wantUpd: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inserted, updated := splitAffected(tt.rows, tt.affected, tt.upsert)
+			if inserted != tt.wantInserted || updated != tt.wantUpd {
+				t.Errorf("splitAffected() = %d, %d, want %d, %d", inserted, updated, tt.wantInserted, tt.wantUpd)
+			}
+		})
+	}
+}
+
+func TestBulkWriter_RetryConnLost(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         BulkOptions
+		wantAttempts int
+		wantErr      bool
+		wantRows     int64
+	}{
+		{name: "普通插入连接断开不重试", opts: BulkOptions{Backoff: time.Millisecond}, wantAttempts: 1, wantErr: true},
+		{name: "upsert 连接断开后重试", opts: BulkOptions{Backoff: time.Millisecond, UpdateColumns: []string{"name"}}, wantAttempts: 2, wantRows: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openSQLiteDB(t, nil, &bulkItem{}).Conn()
+			// 第一次写入时模拟连接断开
+			var calls int
+			if err := db.Callback().Create().Before("gorm:create").Register("test:conn_lost", func(db *gorm.DB) {
+				if calls++; calls == 1 {
+					_ = db.AddError(driver.ErrBadConn)
+				}
+			}); err != nil {
+				t.Fatal(err)
+			}
+			res, err := NewBulkWriter[bulkItem](db, &tt.opts).Write(context.Background(), []bulkItem{{ID: 1, Name: "a"}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if res.Chunks[0].Attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", res.Chunks[0].Attempts, tt.wantAttempts)
+			}
+			var n int64
+			db.Model(&bulkItem{}).Count(&n)
+			if n != tt.wantRows {
+				t.Errorf("rows = %d, want %d", n, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantInsert bool
+		wantUpsert bool
+	}{
+		{name: "死锁", err: &mysqlDriver.MySQLError{Number: 1213}, wantInsert: true, wantUpsert: true},
+		{name: "锁等待超时", err: &mysqlDriver.MySQLError{Number: 1205}, wantInsert: true, wantUpsert: true},
+		{name: "连接数过多", err: &mysqlDriver.MySQLError{Number: 1040}, wantInsert: true, wantUpsert: true},
+		{name: "连接失效", err: driver.ErrBadConn, wantUpsert: true},
+		{name: "无效连接", err: mysqlDriver.ErrInvalidConn, wantUpsert: true},
+		{name: "server has gone away", err: &mysqlDriver.MySQLError{Number: 2006}, wantUpsert: true},
+		{name: "查询中连接断开", err: &mysqlDriver.MySQLError{Number: 2013}, wantUpsert: true},
+		{name: "重复键", err: &mysqlDriver.MySQLError{Number: 1062}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientError(tt.err, false); got != tt.wantInsert {
+				t.Errorf("isTransientError(insert) = %v, want %v", got, tt.wantInsert)
+			}
+			if got := isTransientError(tt.err, true); got != tt.wantUpsert {
+				t.Errorf("isTransientError(upsert) = %v, want %v", got, tt.wantUpsert)
+			}
+		})
+	}
+}
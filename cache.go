@@ -0,0 +1,474 @@
+package Base_PKG
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	按主键读取的本地实体缓存, 写入提交后通过 etcd 前缀广播失效
+*/
+
+// cacheAllKey 表示整张表的缓存失效
+const cacheAllKey = "*"
+
+// CacheInvalidator 失效广播, etcd.InvalidatorDO 满足该接口
+type CacheInvalidator interface {
+	Publish(key string) error
+
+	// Watch 阻塞监听失效 key, 空 key 表示全部失效
+	Watch(ctx context.Context, onInvalidate func(key string))
+}
+
+// CacheOptions
+// @Description: 实体缓存配置
+type CacheOptions struct {
+	// 最大缓存条数, 默认 10000
+	Capacity int
+
+	// 缓存有效期, 默认 5min
+	TTL time.Duration
+
+	// 失效广播, 为空时只清理本实例的缓存
+	Invalidator CacheInvalidator
+
+	// 在 db.Transaction 等非 WithTx 事务中写入时无法得知提交时间, 写入后立即失效, 该延迟内读取不写入缓存,
+	// 延迟结束后再次失效并广播. 事务在写入后该延迟内提交时缓存不会保留旧值; 提交更晚时,
+	// 延迟结束到提交之间的读取会把旧值写回缓存, 最长保留 TTL. 长事务需要使用 WithTx. 默认 1s
+	TxInvalidateDelay time.Duration
+}
+
+type cacheEntry[T any] struct {
+	key      string
+	value    T
+	expireAt time.Time
+}
+
+/*
+EntityCache
+
+	@Description: 模型 T 的按主键读取缓存, LRU 淘汰, 同一主键并发未命中时只查询一次.
+	通过 db.Use 注册后, 该表的 Create/Update/Delete 在 WithTx 事务提交后(不在事务中时立即)清理缓存并广播失效 key;
+	在 db.Transaction 中写入时无法得知提交时间, 立即失效, TxInvalidateDelay 内不缓存该 key 并在之后再次失效,
+	晚于该延迟提交的事务可能留下最长 TTL 的旧值. Raw/Exec 执行的写入不会触发失效
+*/
+type EntityCache[T any] struct {
+	db    *gorm.DB
+	table string
+	opts  CacheOptions
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+
+	// 每次失效递增, 查询期间发生失效时不写入缓存
+	gen uint64
+
+	// 非 WithTx 事务写入的 key 及其不缓存的截止时间
+	pending map[string]time.Time
+
+	group singleflight.Group
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+/*
+NewEntityCache
+
+	@Description: 创建实体缓存
+	@param db: gorm 对象, 为 nil 时使用 GetDBConn
+	@param opts: 缓存配置, 为 nil 时使用默认配置
+	@return *EntityCache[T]
+	@return error
+*/
+func NewEntityCache[T any](db *gorm.DB, opts *CacheOptions) (*EntityCache[T], error) {
+	if db == nil {
+		db = GetDBConn()
+	}
+	if db == nil {
+		return nil, errors.New("db 未初始化")
+	}
+	var o CacheOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Capacity <= 0 {
+		o.Capacity = 10000
+	}
+	if o.TTL <= 0 {
+		o.TTL = 5 * time.Minute
+	}
+	if o.TxInvalidateDelay <= 0 {
+		o.TxInvalidateDelay = time.Second
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+	return &EntityCache[T]{
+		db:      db,
+		table:   stmt.Table,
+		opts:    o,
+		items:   make(map[string]*list.Element),
+		order:   list.New(),
+		pending: make(map[string]time.Time),
+	}, nil
+}
+
+// Name 实现 gorm.Plugin
+func (c *EntityCache[T]) Name() string {
+	return "base_pkg:entity_cache:" + c.table
+}
+
+// Initialize 实现 gorm.Plugin, 注册写入后的失效回调
+func (c *EntityCache[T]) Initialize(db *gorm.DB) error {
+	name := "base_pkg:cache_invalidate_" + c.table
+	cb := db.Callback()
+	// 在 gorm 默认事务提交之后执行
+	for _, err := range []error{
+		cb.Create().After("gorm:commit_or_rollback_transaction").Register(name, c.afterWrite),
+		cb.Update().After("gorm:commit_or_rollback_transaction").Register(name, c.afterWrite),
+		cb.Delete().After("gorm:commit_or_rollback_transaction").Register(name, c.afterWrite),
+	} {
+		if err != nil {
+			return fmt.Errorf("register entity cache callback error: %w", err)
+		}
+	}
+	return nil
+}
+
+/*
+Get
+
+	@Description: 按主键读取, 未命中时查询数据库并写入缓存. 在 WithTx 事务中时直接查询事务, 不使用缓存
+	@param id: 主键
+	@return *T: 缓存值的深拷贝, 修改不影响缓存
+	@return error: 记录不存在时为 ErrNotFound
+*/
+func (c *EntityCache[T]) Get(ctx context.Context, id any) (*T, error) {
+	if tx := TxFromContext(ctx); tx != nil {
+		var obj T
+		if err := tx.WithContext(ctx).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).First(&obj).Error; err != nil {
+			return nil, TranslateError(err)
+		}
+		return &obj, nil
+	}
+
+	key := fmt.Sprint(id)
+	if v, ok := c.get(key); ok {
+		v = deepCopy(v)
+		return &v, nil
+	}
+
+	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+		c.mu.Lock()
+		gen := c.gen
+		c.mu.Unlock()
+
+		// 结果由同一主键的并发调用共享, 不受第一个调用者取消的影响
+		var obj T
+		err := c.db.WithContext(context.WithoutCancel(ctx)).
+			Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).First(&obj).Error
+		if err != nil {
+			return nil, TranslateError(err)
+		}
+		c.set(key, obj, gen)
+		return obj, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 并发调用共享同一个结果, 且结果已写入缓存
+	obj := deepCopy(v.(T))
+	return &obj, nil
+}
+
+// deepCopy 复制缓存值, 导出字段中的指针、切片和 map 指向新的内存. 不处理循环引用, 未导出字段为浅拷贝
+func deepCopy[T any](v T) T {
+	return deepCopyValue(reflect.ValueOf(&v).Elem()).Interface().(T)
+}
+
+func deepCopyValue(src reflect.Value) reflect.Value {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return src
+		}
+		dst := reflect.New(src.Type().Elem())
+		dst.Elem().Set(deepCopyValue(src.Elem()))
+		return dst
+	case reflect.Interface:
+		if src.IsNil() {
+			return src
+		}
+		dst := reflect.New(src.Type()).Elem()
+		dst.Set(deepCopyValue(src.Elem()))
+		return dst
+	case reflect.Slice:
+		if src.IsNil() {
+			return src
+		}
+		dst := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			dst.Index(i).Set(deepCopyValue(src.Index(i)))
+		}
+		return dst
+	case reflect.Map:
+		if src.IsNil() {
+			return src
+		}
+		dst := reflect.MakeMapWithSize(src.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			dst.SetMapIndex(iter.Key(), deepCopyValue(iter.Value()))
+		}
+		return dst
+	case reflect.Array, reflect.Struct:
+		dst := reflect.New(src.Type()).Elem()
+		dst.Set(src)
+		if src.Kind() == reflect.Array {
+			for i := 0; i < src.Len(); i++ {
+				dst.Index(i).Set(deepCopyValue(src.Index(i)))
+			}
+			return dst
+		}
+		for i := 0; i < src.NumField(); i++ {
+			if dst.Field(i).CanSet() {
+				dst.Field(i).Set(deepCopyValue(src.Field(i)))
+			}
+		}
+		return dst
+	default:
+		return src
+	}
+}
+
+func (c *EntityCache[T]) get(key string) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var zero T
+	el, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	entry := el.Value.(*cacheEntry[T])
+	if time.Now().After(entry.expireAt) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return entry.value, true
+}
+
+// set 写入缓存, 查询开始后发生过失效时放弃
+func (c *EntityCache[T]) set(key string, value T, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen || c.isPending(key) {
+		return
+	}
+	entry := &cacheEntry[T]{key: key, value: value, expireAt: time.Now().Add(c.opts.TTL)}
+	if el, ok := c.items[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.opts.Capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry[T]).key)
+	}
+}
+
+// isPending key 是否有未确认提交的事务写入, 调用方持有 mu
+func (c *EntityCache[T]) isPending(key string) bool {
+	now := time.Now()
+	for _, k := range []string{key, cacheAllKey} {
+		if deadline, ok := c.pending[k]; ok && now.Before(deadline) {
+			return true
+		}
+	}
+	return false
+}
+
+// markPending 在 TxInvalidateDelay 内不缓存 keys, 之后再次失效
+func (c *EntityCache[T]) markPending(keys []string) {
+	deadline := time.Now().Add(c.opts.TxInvalidateDelay)
+	c.mu.Lock()
+	for _, key := range keys {
+		c.pending[key] = deadline
+	}
+	c.mu.Unlock()
+	c.invalidate(keys)
+
+	time.AfterFunc(c.opts.TxInvalidateDelay, func() {
+		c.mu.Lock()
+		now := time.Now()
+		for _, key := range keys {
+			if d, ok := c.pending[key]; ok && !now.Before(d) {
+				delete(c.pending, key)
+			}
+		}
+		c.mu.Unlock()
+		c.invalidate(keys)
+	})
+}
+
+// evict 清理本实例的缓存, 没有指定 key 时全部清理
+func (c *EntityCache[T]) evict(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for _, key := range keys {
+		if key == cacheAllKey {
+			c.items = make(map[string]*list.Element)
+			c.order.Init()
+			return
+		}
+		if el, ok := c.items[key]; ok {
+			c.order.Remove(el)
+			delete(c.items, key)
+		}
+	}
+}
+
+// Len 当前缓存条数
+func (c *EntityCache[T]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+/*
+Invalidate
+
+	@Description: 手动失效, 用于 Raw/Exec 写入后. 在 WithTx 事务中时提交后执行
+	@param ids: 主键, 为空时整张表失效
+*/
+func (c *EntityCache[T]) Invalidate(ctx context.Context, ids ...any) {
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, fmt.Sprint(id))
+	}
+	if len(keys) == 0 {
+		keys = append(keys, cacheAllKey)
+	}
+	AfterCommit(ctx, func() { c.invalidate(keys) })
+}
+
+// invalidate 清理本实例缓存并广播
+func (c *EntityCache[T]) invalidate(keys []string) {
+	c.evict(keys...)
+	if c.opts.Invalidator == nil {
+		return
+	}
+	for _, key := range keys {
+		if err := c.opts.Invalidator.Publish(c.table + "/" + key); err != nil {
+			zap.L().Error("entity cache publish invalidation found error", zap.String("table", c.table), zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+// afterWrite 写入回调, 无法确定主键时整张表失效
+func (c *EntityCache[T]) afterWrite(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || db.DryRun || stmt.Table != c.table || stmt.Schema == nil {
+		return
+	}
+	keys := c.writtenKeys(stmt)
+	// 不在 WithTx 中的事务没有提交回调, 延迟内不缓存这些 key, 延迟后再失效一次
+	if _, ok := stmt.ConnPool.(gorm.TxCommitter); ok && !inWithTx(stmt.Context) {
+		c.markPending(keys)
+		return
+	}
+	AfterCommit(stmt.Context, func() { c.invalidate(keys) })
+}
+
+// writtenKeys 本次写入的记录主键
+func (c *EntityCache[T]) writtenKeys(stmt *gorm.Statement) []string {
+	all := []string{cacheAllKey}
+	if len(stmt.Schema.PrimaryFields) != 1 {
+		return all
+	}
+	pf := stmt.Schema.PrimaryFields[0]
+	rv := stmt.ReflectValue
+	var keys []string
+	switch rv.Kind() {
+	case reflect.Struct:
+		v, zero := pf.ValueOf(stmt.Context, rv)
+		if zero {
+			return all
+		}
+		keys = append(keys, fmt.Sprint(v))
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			v, zero := pf.ValueOf(stmt.Context, reflect.Indirect(rv.Index(i)))
+			if zero {
+				return all
+			}
+			keys = append(keys, fmt.Sprint(v))
+		}
+	default:
+		return all
+	}
+	return keys
+}
+
+/*
+Start
+
+	@Description: 后台监听失效广播, 没有配置 Invalidator 或重复调用时无效
+	@param ctx: 上下文, 取消后停止监听
+*/
+func (c *EntityCache[T]) Start(ctx context.Context) {
+	c.mu.Lock()
+	if c.cancel != nil || c.opts.Invalidator == nil {
+		c.mu.Unlock()
+		return
+	}
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	done := c.done
+	c.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		c.opts.Invalidator.Watch(ctx, c.onInvalidate)
+	}()
+}
+
+// Stop 停止监听并等待退出
+func (c *EntityCache[T]) Stop() {
+	c.mu.Lock()
+	cancel, done := c.cancel, c.done
+	c.cancel, c.done = nil, nil
+	c.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// onInvalidate 处理广播的失效 key, 格式为 <table>/<主键>
+func (c *EntityCache[T]) onInvalidate(key string) {
+	if key == "" {
+		c.evict(cacheAllKey)
+		return
+	}
+	if id, ok := strings.CutPrefix(key, c.table+"/"); ok {
+		c.evict(id)
+	}
+}
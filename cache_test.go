@@ -0,0 +1,272 @@
+package Base_PKG
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"sync"
+	"testing"
+	"time"
+)
+
+type cacheItem struct {
+	ID   uint
+	Name string
+	Nick *string
+	Tags []string `gorm:"serializer:json"`
+}
+
+// fakeInvalidator 记录广播的 key, Watch 阻塞到 ctx 取消
+type fakeInvalidator struct {
+	mu        sync.Mutex
+	published []string
+	watching  chan func(key string)
+}
+
+func (f *fakeInvalidator) Publish(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published = append(f.published, key)
+	return nil
+}
+
+func (f *fakeInvalidator) Watch(ctx context.Context, onInvalidate func(key string)) {
+	f.watching <- onInvalidate
+	<-ctx.Done()
+}
+
+func (f *fakeInvalidator) take() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := f.published
+	f.published = nil
+	return keys
+}
+
+func cacheDB(t *testing.T, opts *DBOptions) (*DB, *EntityCache[cacheItem], *fakeInvalidator, *int) {
+	t.Helper()
+	d := openSQLiteDB(t, opts, &cacheItem{})
+	db := d.Conn()
+	inv := &fakeInvalidator{watching: make(chan func(key string), 1)}
+	c, err := NewEntityCache[cacheItem](db, &CacheOptions{Invalidator: inv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Use(c); err != nil {
+		t.Fatal(err)
+	}
+	queries := new(int)
+	if err = db.Callback().Query().After("gorm:query").Register("test:count", func(*gorm.DB) { *queries++ }); err != nil {
+		t.Fatal(err)
+	}
+	nick := "n"
+	if err = db.Create(&cacheItem{Name: "a", Nick: &nick, Tags: []string{"x"}}).Error; err != nil {
+		t.Fatal(err)
+	}
+	inv.take()
+	return d, c, inv, queries
+}
+
+func TestEntityCache_Get(t *testing.T) {
+	_, c, _, queries := cacheDB(t, nil)
+	ctx := context.Background()
+
+	first, err := c.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 修改返回值不影响缓存
+	*first.Nick = "changed"
+	first.Tags[0] = "changed"
+	second, err := c.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *queries != 1 {
+		t.Errorf("queries = %d, want 1", *queries)
+	}
+	if *second.Nick != "n" || second.Tags[0] != "x" {
+		t.Errorf("cached value modified: %+v", second)
+	}
+	if _, err = c.Get(ctx, 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() missing error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestEntityCache_Invalidate(t *testing.T) {
+	errRollback := errors.New("rollback")
+	tests := []struct {
+		name        string
+		opts        *DBOptions
+		write       func(d *DB) error
+		wantErr     error
+		wantName    string
+		wantPublish []string
+	}{
+		{
+			name:        "不在事务中立即失效",
+			write:       func(d *DB) error { return d.Conn().Model(&cacheItem{ID: 1}).Update("name", "b").Error },
+			wantName:    "b",
+			wantPublish: []string{"cache_item/1"},
+		},
+		{
+			name:        "gorm 默认事务提交后失效",
+			opts:        &DBOptions{DefaultTransaction: true},
+			write:       func(d *DB) error { return d.Conn().Model(&cacheItem{ID: 1}).Update("name", "b").Error },
+			wantName:    "b",
+			wantPublish: []string{"cache_item/1"},
+		},
+		{
+			name: "WithTx 提交后失效",
+			write: func(d *DB) error {
+				return d.WithTx(context.Background(), func(tx *gorm.DB) error {
+					return tx.Model(&cacheItem{ID: 1}).Update("name", "b").Error
+				}, nil)
+			},
+			wantName:    "b",
+			wantPublish: []string{"cache_item/1"},
+		},
+		{
+			name: "WithTx 回滚不失效",
+			write: func(d *DB) error {
+				return d.WithTx(context.Background(), func(tx *gorm.DB) error {
+					if err := tx.Model(&cacheItem{ID: 1}).Update("name", "b").Error; err != nil {
+						return err
+					}
+					return errRollback
+				}, nil)
+			},
+			wantErr:  errRollback,
+			wantName: "a",
+		},
+		{
+			name: "db.Transaction 中写入立即失效",
+			write: func(d *DB) error {
+				return d.Conn().Transaction(func(tx *gorm.DB) error {
+					return tx.Model(&cacheItem{ID: 1}).Update("name", "b").Error
+				})
+			},
+			wantName:    "b",
+			wantPublish: []string{"cache_item/1"},
+		},
+		{
+			name: "无法确定主键时整张表失效",
+			write: func(d *DB) error {
+				return d.Conn().Model(&cacheItem{}).Where("name = ?", "a").Update("name", "b").Error
+			},
+			wantName:    "b",
+			wantPublish: []string{"cache_item/*"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, c, inv, _ := cacheDB(t, tt.opts)
+			ctx := context.Background()
+			if _, err := c.Get(ctx, 1); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.write(d); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("write error = %v, want %v", err, tt.wantErr)
+			}
+			got, err := c.Get(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != tt.wantName {
+				t.Errorf("Get() name = %s, want %s", got.Name, tt.wantName)
+			}
+			if keys := inv.take(); len(keys) != len(tt.wantPublish) || (len(keys) > 0 && keys[0] != tt.wantPublish[0]) {
+				t.Errorf("published = %v, want %v", keys, tt.wantPublish)
+			}
+		})
+	}
+}
+
+func TestEntityCache_TransactionDelayedInvalidate(t *testing.T) {
+	d := openSQLiteDB(t, nil, &cacheItem{})
+	db := d.Conn()
+	inv := &fakeInvalidator{}
+	c, err := NewEntityCache[cacheItem](db, &CacheOptions{Invalidator: inv, TxInvalidateDelay: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Use(c); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Create(&cacheItem{Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	inv.take()
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&cacheItem{ID: 1}).Update("name", "b").Error; err != nil {
+			return err
+		}
+		// 模拟提交前的并发读取把旧值写回缓存
+		c.mu.Lock()
+		gen := c.gen
+		c.mu.Unlock()
+		c.set("1", cacheItem{ID: 1, Name: "a"}, gen)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 0 {
+		t.Fatalf("stale value cached while transaction write is pending")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if keys := inv.take(); len(keys) != 2 {
+		t.Errorf("published = %v, want immediate and delayed invalidation", keys)
+	}
+
+	// 延迟结束后恢复缓存
+	if _, err = c.Get(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 1 {
+		t.Errorf("key not cached after pending delay")
+	}
+}
+
+func TestEntityCache_Watch(t *testing.T) {
+	d, c, inv, queries := cacheDB(t, nil)
+	ctx := context.Background()
+	c.Start(ctx)
+	defer c.Stop()
+	onInvalidate := <-inv.watching
+
+	if _, err := c.Get(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	// 其他实例修改后广播失效
+	if err := d.Conn().Exec("UPDATE cache_item SET name = ? WHERE id = ?", "c", 1).Error; err != nil {
+		t.Fatal(err)
+	}
+	onInvalidate("other_table/1")
+	if c.Len() != 1 {
+		t.Errorf("other table invalidation evicted cache")
+	}
+	onInvalidate("cache_item/1")
+	got, err := c.Get(ctx, 1)
+	if err != nil || got.Name != "c" || *queries != 2 {
+		t.Errorf("Get() = %+v, %v, queries = %d", got, err, *queries)
+	}
+}
+
+func TestDeepCopy(t *testing.T) {
+	type inner struct{ Values map[string][]int }
+	type outer struct {
+		Ptr   *inner
+		Any   interface{}
+		Array [1][]int
+	}
+	src := outer{Ptr: &inner{Values: map[string][]int{"a": {1}}}, Any: []int{2}, Array: [1][]int{{3}}}
+	dst := deepCopy(src)
+	dst.Ptr.Values["a"][0] = 9
+	dst.Any.([]int)[0] = 9
+	dst.Array[0][0] = 9
+	if src.Ptr.Values["a"][0] != 1 || src.Any.([]int)[0] != 2 || src.Array[0][0] != 3 {
+		t.Errorf("source modified: %+v", src)
+	}
+}
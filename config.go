@@ -0,0 +1,444 @@
+package Base_PKG
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"gopkg.in/yaml.v3"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	结构化的 mysql 连接配置, 渲染为 go-sql-driver 的 DSN
+*/
+
+// 打印配置时密码的掩码
+const secretMask = "******"
+
+// Duration 支持 "5s"、"1m30s" 格式的时长, 用于 yaml/json 配置
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// TLSConfig
+// @Description: mysql TLS 配置, 证书文件为空时使用系统根证书
+type TLSConfig struct {
+	// 注册到驱动的 TLS 配置名称, 默认 base_pkg_<host>_<port>
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+
+	// CA 证书文件
+	CAFile string `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+
+	// 客户端证书和私钥文件, 需要同时设置
+	CertFile string `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+
+	// 校验证书的服务端名称, 默认使用 Host
+	ServerName string `json:"serverName,omitempty" yaml:"serverName,omitempty"`
+
+	// 跳过证书校验, 仅用于测试环境
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"`
+}
+
+// MySQLConfig
+// @Description: mysql 连接配置, 未设置的字段使用默认值
+type MySQLConfig struct {
+	Host string `json:"host" yaml:"host"`
+
+	// 端口, 默认 3306
+	Port int `json:"port,omitempty" yaml:"port,omitempty"`
+
+	User     string `json:"user" yaml:"user"`
+	Password string `json:"password" yaml:"password"`
+	Database string `json:"database" yaml:"database"`
+
+	// 字符集, 只在未设置 Collation 时生效, 连接后驱动执行 SET NAMES 并使用该字符集的默认排序规则
+	Charset string `json:"charset,omitempty" yaml:"charset,omitempty"`
+
+	// 排序规则, 在握手时设置, 字符集由排序规则决定. Charset 和 Collation 都未设置时默认 utf8mb4_general_ci
+	Collation string `json:"collation,omitempty" yaml:"collation,omitempty"`
+
+	// 时区, 默认 Local
+	Loc string `json:"loc,omitempty" yaml:"loc,omitempty"`
+
+	// 建立连接、读、写超时, 0 表示不限制
+	Timeout      Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	ReadTimeout  Duration `json:"readTimeout,omitempty" yaml:"readTimeout,omitempty"`
+	WriteTimeout Duration `json:"writeTimeout,omitempty" yaml:"writeTimeout,omitempty"`
+
+	// 其他连接参数, 如 sql_mode
+	Params map[string]string `json:"params,omitempty" yaml:"params,omitempty"`
+
+	// TLS 配置, 为空时不使用 TLS
+	TLS *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+
+	// 连接池配置, 未设置时使用 DefaultDBOptions
+	MaxIdleConns    int      `json:"maxIdleConns,omitempty" yaml:"maxIdleConns,omitempty"`
+	MaxOpenConns    int      `json:"maxOpenConns,omitempty" yaml:"maxOpenConns,omitempty"`
+	ConnMaxLifetime Duration `json:"connMaxLifetime,omitempty" yaml:"connMaxLifetime,omitempty"`
+	ConnMaxIdleTime Duration `json:"connMaxIdleTime,omitempty" yaml:"connMaxIdleTime,omitempty"`
+}
+
+/*
+Validate
+
+	@Description: 校验配置, 返回所有不合法的字段
+	@return error
+*/
+func (c *MySQLConfig) Validate() error {
+	var errs []error
+	if c.Host == "" {
+		errs = append(errs, errors.New("host 不能为空"))
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d 不合法", c.Port))
+	}
+	if c.User == "" {
+		errs = append(errs, errors.New("user 不能为空"))
+	}
+	if c.Loc != "" {
+		if _, err := time.LoadLocation(c.Loc); err != nil {
+			errs = append(errs, fmt.Errorf("loc %s 不合法: %w", c.Loc, err))
+		}
+	}
+	if c.Timeout < 0 || c.ReadTimeout < 0 || c.WriteTimeout < 0 {
+		errs = append(errs, errors.New("超时时间不能为负数"))
+	}
+	if c.TLS != nil {
+		if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+			errs = append(errs, errors.New("tls certFile 和 keyFile 需要同时设置"))
+		}
+		switch strings.ToLower(c.TLS.Name) {
+		case "true", "false", "skip-verify", "preferred":
+			errs = append(errs, fmt.Errorf("tls name %s 为驱动保留名称", c.TLS.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// withDefaults 未设置的字段填充默认值
+func (c MySQLConfig) withDefaults() MySQLConfig {
+	if c.Port == 0 {
+		c.Port = 3306
+	}
+	if c.Charset == "" && c.Collation == "" {
+		c.Collation = "utf8mb4_general_ci"
+	}
+	if c.Loc == "" {
+		c.Loc = "Local"
+	}
+	return c
+}
+
+// tlsName 注册到驱动的 TLS 配置名称
+func (c MySQLConfig) tlsName() string {
+	if c.TLS.Name != "" {
+		return c.TLS.Name
+	}
+	return fmt.Sprintf("base_pkg_%s_%d", c.Host, c.Port)
+}
+
+// driverConfig 转换为驱动配置, 不注册 TLS
+func (c MySQLConfig) driverConfig() (*mysqlDriver.Config, error) {
+	loc, err := time.LoadLocation(c.Loc)
+	if err != nil {
+		return nil, err
+	}
+	cfg := mysqlDriver.NewConfig()
+	cfg.User = c.User
+	cfg.Passwd = c.Password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	cfg.DBName = c.Database
+	if c.Collation != "" {
+		cfg.Collation = c.Collation
+	}
+	cfg.Loc = loc
+	cfg.ParseTime = true
+	cfg.Timeout = time.Duration(c.Timeout)
+	cfg.ReadTimeout = time.Duration(c.ReadTimeout)
+	cfg.WriteTimeout = time.Duration(c.WriteTimeout)
+	cfg.Params = make(map[string]string, len(c.Params)+1)
+	// charset 参数会执行 SET NAMES 覆盖握手时的排序规则, 设置了排序规则时不使用
+	if c.Collation == "" {
+		cfg.Params["charset"] = c.Charset
+	}
+	for k, v := range c.Params {
+		cfg.Params[k] = v
+	}
+	if c.TLS != nil {
+		cfg.TLSConfig = c.tlsName()
+	}
+	return cfg, nil
+}
+
+/*
+DSN
+
+	@Description: 校验配置并渲染为 DSN, 配置了 TLS 时向驱动注册 TLS 配置
+	@return string
+	@return error
+*/
+func (c *MySQLConfig) DSN() (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	o := c.withDefaults()
+	cfg, err := o.driverConfig()
+	if err != nil {
+		return "", err
+	}
+	if o.TLS != nil {
+		if err = o.registerTLS(); err != nil {
+			return "", err
+		}
+	}
+	return cfg.FormatDSN(), nil
+}
+
+// registerTLS 读取证书文件并注册 TLS 配置
+func (c MySQLConfig) registerTLS() error {
+	tlsCfg := &tls.Config{
+		ServerName:         c.TLS.ServerName,
+		InsecureSkipVerify: c.TLS.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = c.Host
+	}
+	if c.TLS.CAFile != "" {
+		pem, err := os.ReadFile(c.TLS.CAFile)
+		if err != nil {
+			return fmt.Errorf("读取 CA 证书失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("CA 证书 %s 格式错误", c.TLS.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if c.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return fmt.Errorf("读取客户端证书失败: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return mysqlDriver.RegisterTLSConfig(c.tlsName(), tlsCfg)
+}
+
+// Options 转换为连接池配置, 与 NewDB 配合使用
+func (c *MySQLConfig) Options() *DBOptions {
+	opts := DefaultDBOptions()
+	if c.MaxIdleConns > 0 {
+		opts.MaxIdleConns = c.MaxIdleConns
+	}
+	if c.MaxOpenConns > 0 {
+		opts.MaxOpenConns = c.MaxOpenConns
+	}
+	if c.ConnMaxLifetime > 0 {
+		opts.ConnMaxLifetime = time.Duration(c.ConnMaxLifetime)
+	}
+	if c.ConnMaxIdleTime > 0 {
+		opts.ConnMaxIdleTime = time.Duration(c.ConnMaxIdleTime)
+	}
+	// 与驱动解析时间使用相同的时区, 时区无效时 DSN 返回错误
+	if loc, err := time.LoadLocation(c.withDefaults().Loc); err == nil {
+		opts.Location = loc
+	}
+	return &opts
+}
+
+// String 打印配置, 密码以掩码代替
+func (c MySQLConfig) String() string {
+	o := c.withDefaults()
+	if o.Password != "" {
+		o.Password = secretMask
+	}
+	cfg, err := o.driverConfig()
+	if err != nil {
+		return fmt.Sprintf("invalid mysql config: %v", err)
+	}
+	return cfg.FormatDSN()
+}
+
+/*
+LoadMySQLConfigFromEnv
+
+	@Description: 从环境变量读取配置, 变量名为 prefix 加字段名, 如 MYSQL_HOST、MYSQL_PASSWORD、MYSQL_TLS_CA.
+	PARAMS 格式为 k1=v1&k2=v2, 时长格式为 5s
+	@param prefix: 变量名前缀, 如 MYSQL_
+	@return *MySQLConfig
+	@return error
+*/
+func LoadMySQLConfigFromEnv(prefix string) (*MySQLConfig, error) {
+	env := func(name string) string {
+		return os.Getenv(prefix + name)
+	}
+	c := &MySQLConfig{
+		Host:      env("HOST"),
+		User:      env("USER"),
+		Password:  env("PASSWORD"),
+		Database:  env("DATABASE"),
+		Charset:   env("CHARSET"),
+		Collation: env("COLLATION"),
+		Loc:       env("LOC"),
+	}
+
+	var errs []error
+	ints := map[string]*int{
+		"PORT":           &c.Port,
+		"MAX_IDLE_CONNS": &c.MaxIdleConns,
+		"MAX_OPEN_CONNS": &c.MaxOpenConns,
+	}
+	for name, p := range ints {
+		if v := env(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", prefix, name, err))
+			}
+			*p = n
+		}
+	}
+	durations := map[string]*Duration{
+		"TIMEOUT":            &c.Timeout,
+		"READ_TIMEOUT":       &c.ReadTimeout,
+		"WRITE_TIMEOUT":      &c.WriteTimeout,
+		"CONN_MAX_LIFETIME":  &c.ConnMaxLifetime,
+		"CONN_MAX_IDLE_TIME": &c.ConnMaxIdleTime,
+	}
+	for name, p := range durations {
+		if v := env(name); v != "" {
+			if err := p.UnmarshalText([]byte(v)); err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", prefix, name, err))
+			}
+		}
+	}
+
+	if v := env("PARAMS"); v != "" {
+		values, err := url.ParseQuery(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%sPARAMS: %w", prefix, err))
+		}
+		c.Params = make(map[string]string, len(values))
+		for k := range values {
+			c.Params[k] = values.Get(k)
+		}
+	}
+
+	t := TLSConfig{
+		Name:       env("TLS_NAME"),
+		CAFile:     env("TLS_CA"),
+		CertFile:   env("TLS_CERT"),
+		KeyFile:    env("TLS_KEY"),
+		ServerName: env("TLS_SERVER_NAME"),
+	}
+	if v := env("TLS_SKIP_VERIFY"); v != "" {
+		skip, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%sTLS_SKIP_VERIFY: %w", prefix, err))
+		}
+		t.InsecureSkipVerify = skip
+	}
+	if t != (TLSConfig{}) {
+		c.TLS = &t
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return c, c.Validate()
+}
+
+/*
+ParseMySQLConfig
+
+	@Description: 解析 yaml 或 json 格式的配置
+	@param data: 配置内容
+	@return *MySQLConfig
+	@return error
+*/
+func ParseMySQLConfig(data []byte) (*MySQLConfig, error) {
+	c := &MySQLConfig{}
+	var err error
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		err = json.Unmarshal(data, c)
+	} else {
+		err = yaml.Unmarshal(data, c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析 mysql 配置失败: %w", err)
+	}
+	return c, c.Validate()
+}
+
+/*
+LoadMySQLConfigFile
+
+	@Description: 从 yaml 或 json 文件读取配置
+	@param path: 文件路径, 扩展名为 .yaml、.yml 或 .json
+	@return *MySQLConfig
+	@return error
+*/
+func LoadMySQLConfigFile(path string) (*MySQLConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return ParseMySQLConfig(data)
+	default:
+		return nil, fmt.Errorf("不支持的配置文件格式: %s", path)
+	}
+}
+
+/*
+NewDBWithConfig
+
+	@Description: 使用结构化配置创建 mysql 数据库连接, 连接池参数取自配置
+	@param c: 连接配置
+	@return *DB
+	@return error
+*/
+func NewDBWithConfig(c *MySQLConfig) (*DB, error) {
+	dsn, err := c.DSN()
+	if err != nil {
+		return nil, err
+	}
+	return NewDB(dsn, c.Options())
+}
+
+/*
+InitConnWithConfig
+
+	@Description: 使用结构化配置初始化全局连接, 与 InitConn 相同失败时 panic. 需要处理错误时使用 NewDBWithConfig 和 SetDefaultDB
+	@param c: 连接配置
+*/
+func InitConnWithConfig(c *MySQLConfig) {
+	d, err := NewDBWithConfig(c)
+	if err != nil {
+		panic("init db connect fail, error: " + err.Error())
+	}
+	SetDefaultDB(d)
+}
@@ -0,0 +1,123 @@
+package Base_PKG
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMySQLConfig_DSN(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     MySQLConfig
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "默认参数",
+			cfg:  MySQLConfig{Host: "127.0.0.1", User: "root", Password: "p@ss:w/rd", Database: "app"},
+			want: "root:p@ss:w/rd@tcp(127.0.0.1:3306)/app?loc=Local&parseTime=true",
+		},
+		{
+			name: "只设置字符集",
+			cfg:  MySQLConfig{Host: "127.0.0.1", User: "root", Database: "app", Loc: "UTC", Charset: "latin1"},
+			want: "root@tcp(127.0.0.1:3306)/app?parseTime=true&charset=latin1",
+		},
+		{
+			name: "设置排序规则时不使用字符集",
+			cfg:  MySQLConfig{Host: "127.0.0.1", User: "root", Database: "app", Loc: "UTC", Charset: "utf8mb4", Collation: "utf8mb4_unicode_ci"},
+			want: "root@tcp(127.0.0.1:3306)/app?collation=utf8mb4_unicode_ci&parseTime=true",
+		},
+		{
+			name: "超时和自定义参数",
+			cfg: MySQLConfig{
+				Host: "db.local", Port: 3307, User: "app", Database: "app", Loc: "UTC",
+				Timeout: Duration(3 * time.Second), ReadTimeout: Duration(time.Minute),
+				Params: map[string]string{"sql_mode": "'STRICT_ALL_TABLES'"},
+			},
+			want: "app@tcp(db.local:3307)/app?parseTime=true&readTimeout=1m0s&timeout=3s&sql_mode=%27STRICT_ALL_TABLES%27",
+		},
+		{
+			name:    "缺少 host 和 user",
+			cfg:     MySQLConfig{Database: "app"},
+			wantErr: true,
+		},
+		{
+			name:    "tls 证书不完整",
+			cfg:     MySQLConfig{Host: "db.local", User: "app", TLS: &TLSConfig{CertFile: "client.pem"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cfg.DSN()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DSN() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DSN() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMySQLConfig_String(t *testing.T) {
+	cfg := MySQLConfig{Host: "127.0.0.1", User: "root", Password: "secret", Database: "app"}
+	if got := cfg.String(); strings.Contains(got, "secret") || !strings.Contains(got, "root:"+secretMask+"@") {
+		t.Errorf("String() got = %v, want masked password", got)
+	}
+}
+
+func TestParseMySQLConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "yaml",
+			data: "host: 127.0.0.1\nuser: root\ntimeout: 5s\nmaxOpenConns: 20\nparams:\n  autocommit: \"1\"\n",
+		},
+		{
+			name: "json",
+			data: `{"host": "127.0.0.1", "user": "root", "timeout": "5s", "maxOpenConns": 20, "params": {"autocommit": "1"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMySQLConfig([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("ParseMySQLConfig() error = %v", err)
+			}
+			if got.Host != "127.0.0.1" || got.Timeout != Duration(5*time.Second) || got.Params["autocommit"] != "1" {
+				t.Errorf("ParseMySQLConfig() got = %+v", got)
+			}
+			if opts := got.Options(); opts.MaxOpenConns != 20 || opts.MaxIdleConns != 10 {
+				t.Errorf("Options() got = %+v", opts)
+			}
+		})
+	}
+}
+
+func TestMySQLConfig_Options(t *testing.T) {
+	tests := []struct {
+		name string
+		loc  string
+		want *time.Location
+	}{
+		{name: "默认本地时区", loc: "", want: time.Local},
+		{name: "指定时区", loc: "UTC", want: time.UTC},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := MySQLConfig{Host: "127.0.0.1", User: "root", Loc: tt.loc}
+			opts := cfg.Options()
+			if opts.Location != tt.want {
+				t.Errorf("Options() Location = %v, want %v", opts.Location, tt.want)
+			}
+			// gorm 生成的时间与驱动解析时间的时区一致
+			if got := opts.withDefaults().NowFunc().Location(); got != tt.want {
+				t.Errorf("NowFunc() location = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package dbtest
+
+import (
+	"fmt"
+	Base_PKG "github.com/odinfor/Base-PKG"
+	"github.com/odinfor/Base-PKG/internal/sqlitemem"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"os"
+	"testing"
+	"time"
+)
+
+/*
+	数据库层的单元测试辅助: 独立的内存数据库、自动迁移、yaml 数据夹具、每个测试在回滚的事务中执行
+*/
+
+// Options
+// @Description: 测试数据库配置
+type Options struct {
+	// 驱动, 默认为纯 Go 实现的 sqlite 内存数据库, 可替换为 mysql 等
+	Dialector gorm.Dialector
+
+	// 连接配置, 为 nil 时与 InitConn 相同; 使用默认 sqlite 时连接数固定为 1
+	DBOptions *Base_PKG.DBOptions
+
+	// 需要自动迁移的模型
+	Models []interface{}
+
+	// yaml 数据夹具文件, 迁移后按顺序加载
+	Fixtures []string
+}
+
+/*
+Harness
+
+	@Description: 测试数据库
+*/
+type Harness struct {
+	db *Base_PKG.DB
+}
+
+/*
+New
+
+	@Description: 创建测试数据库, 测试结束时关闭
+	@param t: 测试对象
+	@param opts: 测试数据库配置, 为 nil 时为空的 sqlite 内存数据库
+	@return *Harness
+*/
+func New(t testing.TB, opts *Options) *Harness {
+	t.Helper()
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+
+	var dbOpts Base_PKG.DBOptions
+	if o.DBOptions != nil {
+		dbOpts = *o.DBOptions
+	}
+	if o.Dialector == nil {
+		// 内存数据库在最后一个连接关闭后销毁, 使用单个常驻连接
+		o.Dialector = sqlitemem.Open("dbtest")
+		dbOpts.MaxOpenConns, dbOpts.MaxIdleConns = 1, 1
+		dbOpts.ConnMaxLifetime = 24 * time.Hour
+	}
+
+	db, err := Base_PKG.OpenDB(o.Dialector, &dbOpts)
+	if err != nil {
+		t.Fatalf("dbtest: open db: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	h := &Harness{db: db}
+	if len(o.Models) > 0 {
+		if err = db.Conn().AutoMigrate(o.Models...); err != nil {
+			t.Fatalf("dbtest: auto migrate: %v", err)
+		}
+	}
+	if err = h.LoadFixtures(o.Fixtures...); err != nil {
+		t.Fatalf("dbtest: %v", err)
+	}
+	return h
+}
+
+// DB 测试数据库连接
+func (h *Harness) DB() *Base_PKG.DB {
+	return h.db
+}
+
+/*
+LoadFixtures
+
+	@Description: 加载 yaml 数据夹具, 顶层 key 为表名, 值为行的列表, 按文件内的顺序写入
+	@param paths: 文件路径
+	@return error
+*/
+func (h *Harness) LoadFixtures(paths ...string) error {
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read fixture %s: %w", path, err)
+		}
+		if err = loadFixture(h.db.Conn(), data); err != nil {
+			return fmt.Errorf("load fixture %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// loadFixture 以 yaml.Node 解析, 保留表的顺序以满足外键依赖
+func loadFixture(db *gorm.DB, data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("顶层需要为 表名: 行列表")
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		table := root.Content[i].Value
+		var rows []map[string]interface{}
+		if err := root.Content[i+1].Decode(&rows); err != nil {
+			return fmt.Errorf("table %s: %w", table, err)
+		}
+		for _, row := range rows {
+			if err := db.Table(table).Create(row).Error; err != nil {
+				return fmt.Errorf("table %s: %w", table, err)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+Begin
+
+	@Description: 开启事务并在测试结束时回滚, 期间 GetDBConn 返回该事务, 结束后恢复.
+	替换了全局连接, 使用 Begin 的测试不能并行执行
+	@param t: 测试对象
+	@return *gorm.DB
+*/
+func (h *Harness) Begin(t testing.TB) *gorm.DB {
+	t.Helper()
+	tx := h.db.Conn().Begin()
+	if tx.Error != nil {
+		t.Fatalf("dbtest: begin: %v", tx.Error)
+	}
+	prev := Base_PKG.SetDBConn(tx)
+	t.Cleanup(func() {
+		Base_PKG.SetDBConn(prev)
+		if err := tx.Rollback().Error; err != nil {
+			t.Errorf("dbtest: rollback: %v", err)
+		}
+	})
+	return tx
+}
+
+/*
+Run
+
+	@Description: 以子测试执行 fn, fn 中的写入在子测试结束后回滚
+	@param t: 测试对象
+	@param name: 子测试名称
+	@param fn: 测试方法, tx 同时为 GetDBConn 的返回值
+	@return bool: 子测试是否通过
+*/
+func (h *Harness) Run(t *testing.T, name string, fn func(t *testing.T, tx *gorm.DB)) bool {
+	return t.Run(name, func(t *testing.T) {
+		fn(t, h.Begin(t))
+	})
+}
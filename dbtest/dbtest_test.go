@@ -0,0 +1,72 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	Base_PKG "github.com/odinfor/Base-PKG"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+type Team struct {
+	ID   uint
+	Name string
+}
+
+type User struct {
+	ID        uint
+	TeamID    uint
+	Name      string
+	CreatedAt time.Time
+	DeletedAt gorm.DeletedAt
+}
+
+func TestHarness(t *testing.T) {
+	h := New(t, &Options{
+		Models:   []interface{}{&Team{}, &User{}},
+		Fixtures: []string{"testdata/users.yaml"},
+	})
+	repo := Base_PKG.NewRepository[User](nil)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, tx *gorm.DB)
+	}{
+		{
+			name: "读取夹具数据",
+			fn: func(t *testing.T, tx *gorm.DB) {
+				u, err := repo.GetByID(ctx, 2)
+				if err != nil {
+					t.Fatalf("GetByID() error = %v", err)
+				}
+				if u.Name != "bob" || !u.CreatedAt.Equal(time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)) {
+					t.Errorf("GetByID() got = %+v", u)
+				}
+			},
+		},
+		{
+			name: "删除记录",
+			fn: func(t *testing.T, tx *gorm.DB) {
+				if err := repo.Delete(ctx, 1); err != nil {
+					t.Fatalf("Delete() error = %v", err)
+				}
+				if _, err := repo.GetByID(ctx, 1); !errors.Is(err, Base_PKG.ErrNotFound) {
+					t.Errorf("GetByID() error = %v, want ErrNotFound", err)
+				}
+			},
+		},
+		{
+			name: "上一个测试的删除已回滚",
+			fn: func(t *testing.T, tx *gorm.DB) {
+				if n, err := repo.Count(ctx); err != nil || n != 2 {
+					t.Errorf("Count() got = %d, %v, want 2", n, err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		h.Run(t, tt.name, tt.fn)
+	}
+}
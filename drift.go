@@ -0,0 +1,458 @@
+package Base_PKG
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+	结构漂移检测: 对比 INFORMATION_SCHEMA 与 gorm 模型的列、类型、可空和索引, 输出文本、json 报告及修复用的 ALTER 脚本
+*/
+
+// DriftKind 差异类型
+type DriftKind string
+
+const (
+	DriftMissingTable  DriftKind = "missing_table"
+	DriftMissingColumn DriftKind = "missing_column"
+	DriftExtraColumn   DriftKind = "extra_column"
+	DriftTypeMismatch  DriftKind = "type_mismatch"
+	DriftNullMismatch  DriftKind = "nullable_mismatch"
+	DriftMissingIndex  DriftKind = "missing_index"
+	DriftExtraIndex    DriftKind = "extra_index"
+	DriftIndexMismatch DriftKind = "index_mismatch"
+)
+
+// primaryIndexName mysql 主键的索引名
+const primaryIndexName = "PRIMARY"
+
+// ErrDriftDetected 存在结构漂移, 用于 CI 等需要以错误结束的场景
+var ErrDriftDetected = errors.New("数据库结构与模型不一致")
+
+// intWidthRe 整数类型的显示宽度, mysql 8.0.19 之后不再显示
+var intWidthRe = regexp.MustCompile(`^(tinyint|smallint|mediumint|int|bigint)\(\d+\)`)
+
+// DriftItem
+// @Description: 单项差异, Name 为列名或索引名
+type DriftItem struct {
+	Kind     DriftKind `json:"kind"`
+	Name     string    `json:"name,omitempty"`
+	Expected string    `json:"expected,omitempty"`
+	Actual   string    `json:"actual,omitempty"`
+
+	// 修复语句, 删除类语句以注释形式给出
+	fix []string
+}
+
+// TableDrift
+// @Description: 单表的差异
+type TableDrift struct {
+	Table string      `json:"table"`
+	Items []DriftItem `json:"items"`
+}
+
+// DriftReport
+// @Description: 漂移检测报告
+type DriftReport struct {
+	Database string       `json:"database"`
+	Tables   []TableDrift `json:"tables"`
+}
+
+// HasDrift 是否存在差异
+func (r *DriftReport) HasDrift() bool {
+	return len(r.Tables) > 0
+}
+
+// Err 存在差异时返回 ErrDriftDetected
+func (r *DriftReport) Err() error {
+	if r.HasDrift() {
+		return fmt.Errorf("%w: %d 张表", ErrDriftDetected, len(r.Tables))
+	}
+	return nil
+}
+
+// Text 文本格式的报告
+func (r *DriftReport) Text() string {
+	var b strings.Builder
+	if !r.HasDrift() {
+		fmt.Fprintf(&b, "database %s: no drift\n", r.Database)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "database %s:\n", r.Database)
+	for _, t := range r.Tables {
+		fmt.Fprintf(&b, "table %s:\n", t.Table)
+		for _, item := range t.Items {
+			fmt.Fprintf(&b, "  %-18s %s", item.Kind, item.Name)
+			if item.Expected != "" {
+				fmt.Fprintf(&b, " expected: %s", item.Expected)
+			}
+			if item.Actual != "" {
+				fmt.Fprintf(&b, " actual: %s", item.Actual)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// JSON json 格式的报告
+func (r *DriftReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// AlterScript 修复差异的 sql 脚本, 缺失的表、删除列和删除索引只生成注释, 需要人工确认
+func (r *DriftReport) AlterScript() string {
+	var b strings.Builder
+	for _, t := range r.Tables {
+		fmt.Fprintf(&b, "-- %s\n", t.Table)
+		for _, item := range t.Items {
+			for _, s := range item.fix {
+				b.WriteString(s)
+				b.WriteString("\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// DriftOptions
+// @Description: 漂移检测配置
+type DriftOptions struct {
+	// 需要检测的模型
+	Models []interface{}
+
+	// 不检查多余的列和索引, 适用于数据库中存在模型未使用的列的情况
+	IgnoreExtra bool
+}
+
+/*
+DriftDetector
+
+	@Description: 结构漂移检测, 只支持 mysql
+*/
+type DriftDetector struct {
+	db   *gorm.DB
+	opts DriftOptions
+}
+
+/*
+NewDriftDetector
+
+	@Description: 创建漂移检测
+	@param db: gorm 对象, 为 nil 时使用 GetDBConn
+	@param opts: 检测配置
+	@return *DriftDetector
+*/
+func NewDriftDetector(db *gorm.DB, opts DriftOptions) *DriftDetector {
+	if db == nil {
+		db = GetDBConn()
+	}
+	return &DriftDetector{db: db, opts: opts}
+}
+
+// liveColumn INFORMATION_SCHEMA.COLUMNS 中的列
+type liveColumn struct {
+	ColumnName string
+	ColumnType string
+	IsNullable string
+}
+
+// liveIndex INFORMATION_SCHEMA.STATISTICS 中的索引
+type liveIndex struct {
+	unique  bool
+	columns []string
+}
+
+func (i liveIndex) String() string {
+	s := "(" + strings.Join(i.columns, ",") + ")"
+	if i.unique {
+		return "UNIQUE " + s
+	}
+	return s
+}
+
+/*
+Detect
+
+	@Description: 检测全部模型, 没有差异的表不出现在报告中
+	@return *DriftReport
+	@return error
+*/
+func (d *DriftDetector) Detect(ctx context.Context) (*DriftReport, error) {
+	db := d.db.WithContext(ctx)
+	if name := db.Dialector.Name(); name != "mysql" {
+		return nil, fmt.Errorf("漂移检测不支持 %s", name)
+	}
+	report := &DriftReport{}
+	if err := db.Raw("SELECT DATABASE()").Scan(&report.Database).Error; err != nil {
+		return nil, err
+	}
+
+	for _, model := range d.opts.Models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, fmt.Errorf("parse model %T: %w", model, err)
+		}
+		t, err := d.table(db, stmt.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("detect %s: %w", stmt.Schema.Table, err)
+		}
+		if len(t.Items) > 0 {
+			report.Tables = append(report.Tables, t)
+		}
+	}
+	sort.Slice(report.Tables, func(i, j int) bool { return report.Tables[i].Table < report.Tables[j].Table })
+	return report, nil
+}
+
+// table 检测单表
+func (d *DriftDetector) table(db *gorm.DB, s *schema.Schema) (TableDrift, error) {
+	t := TableDrift{Table: s.Table}
+	var columns []liveColumn
+	err := db.Raw("SELECT COLUMN_NAME AS column_name, COLUMN_TYPE AS column_type, IS_NULLABLE AS is_nullable "+
+		"FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION", s.Table).
+		Scan(&columns).Error
+	if err != nil {
+		return t, err
+	}
+	if len(columns) == 0 {
+		t.Items = append(t.Items, DriftItem{
+			Kind: DriftMissingTable,
+			Name: s.Table,
+			fix:  []string{fmt.Sprintf("-- CREATE TABLE %s: 使用 AutoMigrate 或迁移创建", quoteIdent(db, s.Table))},
+		})
+		return t, nil
+	}
+	t.Items = append(t.Items, d.diffColumns(db, s, columns)...)
+
+	indexes, err := liveIndexes(db, s.Table)
+	if err != nil {
+		return t, err
+	}
+	t.Items = append(t.Items, d.diffIndexes(db, s, indexes)...)
+	return t, nil
+}
+
+// diffColumns 对比列的存在、类型和可空
+func (d *DriftDetector) diffColumns(db *gorm.DB, s *schema.Schema, columns []liveColumn) []DriftItem {
+	var items []DriftItem
+	table := quoteIdent(db, s.Table)
+	live := make(map[string]liveColumn, len(columns))
+	for _, c := range columns {
+		live[strings.ToLower(c.ColumnName)] = c
+	}
+	expected := make(map[string]bool)
+
+	for _, f := range migratedFields(s) {
+		expected[strings.ToLower(f.DBName)] = true
+		full := fullDataType(db, f)
+		c, ok := live[strings.ToLower(f.DBName)]
+		if !ok {
+			items = append(items, DriftItem{
+				Kind:     DriftMissingColumn,
+				Name:     f.DBName,
+				Expected: full,
+				fix:      []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, quoteIdent(db, f.DBName), full)},
+			})
+			continue
+		}
+		modify := fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s;", table, quoteIdent(db, f.DBName), full)
+		want, got := normalizeColumnType(string(db.Dialector.DataTypeOf(f))), normalizeColumnType(c.ColumnType)
+		if want != got {
+			items = append(items, DriftItem{Kind: DriftTypeMismatch, Name: f.DBName, Expected: want, Actual: got, fix: []string{modify}})
+		}
+		wantNull, gotNull := !f.NotNull && !f.PrimaryKey, c.IsNullable == "YES"
+		if wantNull != gotNull {
+			item := DriftItem{Kind: DriftNullMismatch, Name: f.DBName, Expected: nullability(wantNull), Actual: nullability(gotNull)}
+			// 类型不一致时已生成 MODIFY
+			if want == got {
+				item.fix = []string{modify}
+			}
+			items = append(items, item)
+		}
+	}
+
+	if d.opts.IgnoreExtra {
+		return items
+	}
+	for _, c := range columns {
+		if !expected[strings.ToLower(c.ColumnName)] {
+			items = append(items, DriftItem{
+				Kind:   DriftExtraColumn,
+				Name:   c.ColumnName,
+				Actual: c.ColumnType,
+				fix:    []string{fmt.Sprintf("-- ALTER TABLE %s DROP COLUMN %s;", table, quoteIdent(db, c.ColumnName))},
+			})
+		}
+	}
+	return items
+}
+
+// diffIndexes 对比主键和索引的列及唯一性
+func (d *DriftDetector) diffIndexes(db *gorm.DB, s *schema.Schema, live map[string]liveIndex) []DriftItem {
+	var items []DriftItem
+	table := quoteIdent(db, s.Table)
+	expected := make(map[string]liveIndex)
+	for name, idx := range s.ParseIndexes() {
+		li := liveIndex{unique: idx.Class == "UNIQUE"}
+		for _, opt := range idx.Fields {
+			li.columns = append(li.columns, opt.DBName)
+		}
+		expected[name] = li
+	}
+	// unique 标签由 mysql 按列名创建索引
+	uniqueColumns := make(map[string]bool)
+	for _, f := range migratedFields(s) {
+		if f.Unique {
+			uniqueColumns[strings.ToLower(f.DBName)] = true
+		}
+	}
+
+	var pk liveIndex
+	for _, f := range s.PrimaryFields {
+		pk.columns = append(pk.columns, f.DBName)
+	}
+	pk.unique = true
+	if len(pk.columns) > 0 {
+		expected[primaryIndexName] = pk
+	}
+
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		want := expected[name]
+		got, ok := live[name]
+		create := createIndex(db, table, name, want)
+		drop := fmt.Sprintf("DROP INDEX %s ON %s;", quoteIdent(db, name), table)
+		if name == primaryIndexName {
+			drop = fmt.Sprintf("ALTER TABLE %s DROP PRIMARY KEY;", table)
+		}
+		switch {
+		case !ok:
+			items = append(items, DriftItem{Kind: DriftMissingIndex, Name: name, Expected: want.String(), fix: []string{create}})
+		case want.String() != got.String():
+			items = append(items, DriftItem{Kind: DriftIndexMismatch, Name: name, Expected: want.String(), Actual: got.String(),
+				fix: []string{drop, create}})
+		}
+	}
+
+	if d.opts.IgnoreExtra {
+		return items
+	}
+	extra := make([]string, 0, len(live))
+	for name, idx := range live {
+		if _, ok := expected[name]; ok {
+			continue
+		}
+		if idx.unique && len(idx.columns) == 1 && uniqueColumns[strings.ToLower(idx.columns[0])] {
+			continue
+		}
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		items = append(items, DriftItem{
+			Kind:   DriftExtraIndex,
+			Name:   name,
+			Actual: live[name].String(),
+			fix:    []string{fmt.Sprintf("-- DROP INDEX %s ON %s;", quoteIdent(db, name), table)},
+		})
+	}
+	return items
+}
+
+// liveIndexes 读取表的全部索引
+func liveIndexes(db *gorm.DB, table string) (map[string]liveIndex, error) {
+	rows, err := db.Raw("SELECT INDEX_NAME, NON_UNIQUE, COLUMN_NAME FROM INFORMATION_SCHEMA.STATISTICS "+
+		"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY INDEX_NAME, SEQ_IN_INDEX", table).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexes := make(map[string]liveIndex)
+	for rows.Next() {
+		var (
+			name, column string
+			nonUnique    int
+		)
+		if err = rows.Scan(&name, &nonUnique, &column); err != nil {
+			return nil, err
+		}
+		idx := indexes[name]
+		idx.unique = nonUnique == 0
+		idx.columns = append(idx.columns, column)
+		indexes[name] = idx
+	}
+	return indexes, rows.Err()
+}
+
+// migratedFields 模型中由迁移创建的列
+func migratedFields(s *schema.Schema) []*schema.Field {
+	var fields []*schema.Field
+	for _, f := range s.Fields {
+		if f.DBName != "" && !f.IgnoreMigration {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// fullDataType 列的完整定义, 如 varchar(64) NOT NULL
+func fullDataType(db *gorm.DB, f *schema.Field) string {
+	expr := db.Migrator().FullDataTypeOf(f)
+	return db.Dialector.Explain(expr.SQL, expr.Vars...)
+}
+
+// normalizeColumnType 统一类型写法: 小写, 去掉整数显示宽度和自增, boolean 转为 tinyint(1)
+func normalizeColumnType(t string) string {
+	t = strings.Join(strings.Fields(strings.ToLower(t)), " ")
+	t = strings.TrimSuffix(t, " auto_increment")
+	switch {
+	case t == "boolean" || t == "bool":
+		return "tinyint(1)"
+	case strings.HasPrefix(t, "tinyint(1)"):
+		return t
+	case strings.HasPrefix(t, "integer"):
+		t = "int" + strings.TrimPrefix(t, "integer")
+	}
+	return intWidthRe.ReplaceAllString(t, "$1")
+}
+
+func nullability(null bool) string {
+	if null {
+		return "NULL"
+	}
+	return "NOT NULL"
+}
+
+func createIndex(db *gorm.DB, table, name string, idx liveIndex) string {
+	cols := make([]string, 0, len(idx.columns))
+	for _, c := range idx.columns {
+		cols = append(cols, quoteIdent(db, c))
+	}
+	if name == primaryIndexName {
+		return fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (%s);", table, strings.Join(cols, ", "))
+	}
+	unique := ""
+	if idx.unique {
+		unique = "UNIQUE "
+	}
+	return fmt.Sprintf("CREATE %sINDEX %s ON %s (%s);", unique, quoteIdent(db, name), table, strings.Join(cols, ", "))
+}
+
+func quoteIdent(db *gorm.DB, name string) string {
+	var b strings.Builder
+	db.Dialector.QuoteTo(&b, name)
+	return b.String()
+}
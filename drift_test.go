@@ -0,0 +1,124 @@
+package Base_PKG
+
+import (
+	"gorm.io/gorm"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type driftUser struct {
+	ID     uint
+	Email  string `gorm:"size:64;unique"`
+	Name   string `gorm:"size:32;index:idx_name_age"`
+	Age    int    `gorm:"not null;index:idx_name_age"`
+	Active bool
+}
+
+func TestNormalizeColumnType(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "int(11)", want: "int"},
+		{in: "BIGINT(20) UNSIGNED", want: "bigint unsigned"},
+		{in: "bigint unsigned AUTO_INCREMENT", want: "bigint unsigned"},
+		{in: "integer", want: "int"},
+		{in: "boolean", want: "tinyint(1)"},
+		{in: "tinyint(1)", want: "tinyint(1)"},
+		{in: "tinyint(4)", want: "tinyint"},
+		{in: "varchar(64)", want: "varchar(64)"},
+		{in: "decimal(10,2)", want: "decimal(10,2)"},
+		{in: "datetime(3)  ", want: "datetime(3)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := normalizeColumnType(tt.in); got != tt.want {
+				t.Errorf("normalizeColumnType(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// driftReport 以 mysql 5.7 的写法模拟线上结构
+func driftReport(t *testing.T, opts DriftOptions) *DriftReport {
+	t.Helper()
+	db := openFakeDB(t, "drift").Conn()
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&driftUser{}); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDriftDetector(db, opts)
+	columns := []liveColumn{
+		{ColumnName: "id", ColumnType: "bigint(20) unsigned", IsNullable: "NO"},
+		{ColumnName: "email", ColumnType: "varchar(64)", IsNullable: "YES"},
+		{ColumnName: "name", ColumnType: "varchar(16)", IsNullable: "YES"},
+		{ColumnName: "age", ColumnType: "bigint(20)", IsNullable: "YES"},
+		{ColumnName: "active", ColumnType: "tinyint(1)", IsNullable: "YES"},
+		{ColumnName: "legacy", ColumnType: "int(11)", IsNullable: "YES"},
+	}
+	indexes := map[string]liveIndex{
+		primaryIndexName: {unique: true, columns: []string{"id"}},
+		// unique 标签由 mysql 按列名创建, 不是多余的索引
+		"email":   {unique: true, columns: []string{"email"}},
+		"idx_old": {columns: []string{"legacy"}},
+	}
+	table := TableDrift{Table: stmt.Schema.Table}
+	table.Items = append(d.diffColumns(db, stmt.Schema, columns), d.diffIndexes(db, stmt.Schema, indexes)...)
+	return &DriftReport{Database: "test", Tables: []TableDrift{table}}
+}
+
+func TestDriftDetector_Diff(t *testing.T) {
+	tests := []struct {
+		name string
+		opts DriftOptions
+		want []DriftItem
+	}{
+		{
+			name: "全部差异",
+			want: []DriftItem{
+				{Kind: DriftTypeMismatch, Name: "name", Expected: "varchar(32)", Actual: "varchar(16)"},
+				{Kind: DriftNullMismatch, Name: "age", Expected: "NOT NULL", Actual: "NULL"},
+				{Kind: DriftExtraColumn, Name: "legacy", Actual: "int(11)"},
+				{Kind: DriftMissingIndex, Name: "idx_name_age", Expected: "(name,age)"},
+				{Kind: DriftExtraIndex, Name: "idx_old", Actual: "(legacy)"},
+			},
+		},
+		{
+			name: "忽略多余的列和索引",
+			opts: DriftOptions{IgnoreExtra: true},
+			want: []DriftItem{
+				{Kind: DriftTypeMismatch, Name: "name", Expected: "varchar(32)", Actual: "varchar(16)"},
+				{Kind: DriftNullMismatch, Name: "age", Expected: "NOT NULL", Actual: "NULL"},
+				{Kind: DriftMissingIndex, Name: "idx_name_age", Expected: "(name,age)"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := driftReport(t, tt.opts)
+			got := report.Tables[0].Items
+			for i := range got {
+				got[i].fix = nil
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("items = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDriftReport_AlterScript(t *testing.T) {
+	script := driftReport(t, DriftOptions{}).AlterScript()
+	want := []string{
+		"-- drift_user",
+		"ALTER TABLE `drift_user` MODIFY COLUMN `name` varchar(32);",
+		"ALTER TABLE `drift_user` MODIFY COLUMN `age` bigint NOT NULL;",
+		"-- ALTER TABLE `drift_user` DROP COLUMN `legacy`;",
+		"CREATE INDEX `idx_name_age` ON `drift_user` (`name`, `age`);",
+		"-- DROP INDEX `idx_old` ON `drift_user`;",
+	}
+	if got := strings.Split(strings.TrimSpace(script), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("AlterScript() =\n%s\nwant\n%s", script, strings.Join(want, "\n"))
+	}
+}
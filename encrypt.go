@@ -0,0 +1,707 @@
+package Base_PKG
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	字段透明加密: serializer:encrypted 使用 AES-GCM 加密, 密文带密钥 id 以支持轮换,
+	密钥 id、表名和列名作为附加数据, 密文复制到其他表或其他列后无法解密;
+	blind_index 标签的列保存明文的 HMAC 用于等值查询
+*/
+
+const (
+	// 密文格式 <密钥 id>$<base64(nonce+密文)>
+	cipherSep = "$"
+
+	// blindIndexTag 哈希列的标签, 值为明文字段名, 如 `blind_index:"Phone"`
+	blindIndexTag = "blind_index"
+)
+
+var (
+	ErrKeyringNotSet    = errors.New("加密密钥未设置")
+	ErrUnknownKeyID     = errors.New("未知的加密密钥 id")
+	ErrInvalidCipher    = errors.New("无效的密文")
+	ErrNoBlindIndex     = errors.New("未设置 blind index 密钥")
+	ErrUnsupportedField = errors.New("加密字段只支持 string、[]byte、*string 类型")
+)
+
+// keyring 全局密钥, serializer 注册时还没有密钥, 使用时读取
+var keyring atomic.Pointer[Keyring]
+
+func init() {
+	schema.RegisterSerializer("encrypted", EncryptedSerializer{})
+}
+
+// EncryptionKeys
+// @Description: 加密密钥配置
+type EncryptionKeys struct {
+	// 新数据使用的密钥 id
+	Current string
+
+	// 全部密钥, 轮换后旧密钥需要保留到重新加密完成, 长度为 16、24 或 32 字节
+	Keys map[string][]byte
+
+	// blind index 的 HMAC 密钥, 修改后已有的哈希列全部失效, 为空时不能使用 blind index
+	IndexKey []byte
+}
+
+/*
+Keyring
+
+	@Description: 加解密使用的密钥集合
+*/
+type Keyring struct {
+	current  string
+	aeads    map[string]cipher.AEAD
+	indexKey []byte
+}
+
+/*
+NewKeyring
+
+	@Description: 根据密钥配置创建密钥集合
+	@param keys: 密钥配置
+	@return *Keyring
+	@return error
+*/
+func NewKeyring(keys EncryptionKeys) (*Keyring, error) {
+	if _, ok := keys.Keys[keys.Current]; !ok {
+		return nil, fmt.Errorf("当前密钥 %q 不存在: %w", keys.Current, ErrUnknownKeyID)
+	}
+	k := &Keyring{
+		current:  keys.Current,
+		aeads:    make(map[string]cipher.AEAD, len(keys.Keys)),
+		indexKey: keys.IndexKey,
+	}
+	for id, key := range keys.Keys {
+		if id == "" || strings.Contains(id, cipherSep) {
+			return nil, fmt.Errorf("密钥 id %q 不能为空或包含 %s", id, cipherSep)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		if k.aeads[id], err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+	}
+	return k, nil
+}
+
+/*
+SetKeyring
+
+	@Description: 设置 serializer:encrypted 和 blind index 使用的全局密钥, 需要在读写加密字段前调用
+	@param k: 密钥集合
+*/
+func SetKeyring(k *Keyring) {
+	keyring.Store(k)
+}
+
+func currentKeyring() (*Keyring, error) {
+	k := keyring.Load()
+	if k == nil {
+		return nil, ErrKeyringNotSet
+	}
+	return k, nil
+}
+
+// CurrentKeyID 新数据使用的密钥 id
+func (k *Keyring) CurrentKeyID() string {
+	return k.current
+}
+
+// Encrypt 使用当前密钥加密, 密钥 id 和 ad 作为附加数据, 解密时需要相同的 ad
+func (k *Keyring) Encrypt(plain, ad []byte) (string, error) {
+	aead := k.aeads[k.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plain, associatedData(k.current, ad))
+	return k.current + cipherSep + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 使用密文中记录的密钥解密, ad 需要与加密时相同
+func (k *Keyring) Decrypt(ciphertext string, ad []byte) ([]byte, error) {
+	id, data, ok := strings.Cut(ciphertext, cipherSep)
+	if !ok {
+		return nil, ErrInvalidCipher
+	}
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidCipher
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], associatedData(id, ad))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCipher, err)
+	}
+	return plain, nil
+}
+
+func associatedData(id string, ad []byte) []byte {
+	return append([]byte(id+"\x00"), ad...)
+}
+
+// fieldAD 字段密文的附加数据, 表名使用模型的表名, 分表和归档表中的数据使用同一模型读取.
+// 不绑定主键: 自增主键在插入后才生成, 绑定主键需要插入后再次写入, 两次写入之间失败的记录无法解密
+func fieldAD(table, column string) []byte {
+	return []byte(table + "\x00" + column)
+}
+
+// KeyID 密文使用的密钥 id
+func KeyID(ciphertext string) string {
+	id, _, _ := strings.Cut(ciphertext, cipherSep)
+	return id
+}
+
+// BlindIndex 明文的 HMAC-SHA256, 十六进制 64 位
+func (k *Keyring) BlindIndex(value string) (string, error) {
+	if len(k.indexKey) == 0 {
+		return "", ErrNoBlindIndex
+	}
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+/*
+BlindIndex
+
+	@Description: 使用全局密钥计算 blind index, 用于按加密字段等值查询, 如 Where("phone_hash = ?", hash)
+	@param value: 明文
+	@return string
+	@return error
+*/
+func BlindIndex(value string) (string, error) {
+	k, err := currentKeyring()
+	if err != nil {
+		return "", err
+	}
+	return k.BlindIndex(value)
+}
+
+/*
+EncryptedSerializer
+
+	@Description: gorm 序列化器, 字段使用 `gorm:"serializer:encrypted"` 时自动加解密, 数据库列需要为字符串类型.
+	密文绑定表名和列名, 同一列的密文在记录之间复制不能被检测
+*/
+type EncryptedSerializer struct{}
+
+// Scan 实现 schema.SerializerInterface, 解密数据库中的值. NULL 和空字符串(如加密前的历史数据、列默认值)读取为零值
+func (EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	fieldValue := reflect.New(field.FieldType).Elem()
+	var ciphertext string
+	switch v := dbValue.(type) {
+	case nil:
+	case []byte:
+		ciphertext = string(v)
+	case string:
+		ciphertext = v
+	default:
+		return fmt.Errorf("%w: %#v", ErrInvalidCipher, dbValue)
+	}
+	if ciphertext != "" {
+		k, err := currentKeyring()
+		if err != nil {
+			return err
+		}
+		plain, err := k.Decrypt(ciphertext, fieldAD(field.Schema.Table, field.DBName))
+		if err != nil {
+			return fmt.Errorf("decrypt %s: %w", field.Name, err)
+		}
+		if err = setPlain(fieldValue, plain); err != nil {
+			return err
+		}
+	}
+	field.ReflectValueOf(ctx, dst).Set(fieldValue)
+	return nil
+}
+
+// Value 实现 schema.SerializerValuerInterface, 使用当前密钥加密
+func (EncryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plain, ok, err := plainOf(fieldValue)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return encryptField(field, plain)
+}
+
+func encryptField(field *schema.Field, plain []byte) (string, error) {
+	k, err := currentKeyring()
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := k.Encrypt(plain, fieldAD(field.Schema.Table, field.DBName))
+	if err != nil {
+		return "", fmt.Errorf("encrypt %s: %w", field.Name, err)
+	}
+	return ciphertext, nil
+}
+
+// plainOf 字段值转换为明文, nil 指针返回 false
+func plainOf(v interface{}) ([]byte, bool, error) {
+	switch x := v.(type) {
+	case string:
+		return []byte(x), true, nil
+	case []byte:
+		return x, x != nil, nil
+	case *string:
+		if x == nil {
+			return nil, false, nil
+		}
+		return []byte(*x), true, nil
+	case nil:
+		return nil, false, nil
+	default:
+		return nil, false, ErrUnsupportedField
+	}
+}
+
+// setPlain 将明文写入字段
+func setPlain(v reflect.Value, plain []byte) error {
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(string(plain))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(plain)
+	case v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.String:
+		s := string(plain)
+		v.Set(reflect.ValueOf(&s))
+	default:
+		return ErrUnsupportedField
+	}
+	return nil
+}
+
+// blindField 哈希列及其明文字段
+type blindField struct {
+	hash   *schema.Field
+	source *schema.Field
+}
+
+// encryptedSchema 模型中的加密字段和哈希列
+type encryptedSchema struct {
+	encrypted []*schema.Field
+	blind     []blindField
+}
+
+/*
+EncryptionPlugin
+
+	@Description: 加密字段的写入插件: 根据 `blind_index:"<明文字段名>"` 标签自动填充哈希列,
+	并加密 Updates(map) 中的加密字段(gorm 不对 map 的值调用序列化器). UpdateColumn 等跳过回调的方法需要自行处理
+*/
+type EncryptionPlugin struct {
+	schemas sync.Map // *schema.Schema -> *encryptedSchema
+}
+
+func NewEncryptionPlugin() *EncryptionPlugin {
+	return &EncryptionPlugin{}
+}
+
+// Name 实现 gorm.Plugin
+func (p *EncryptionPlugin) Name() string {
+	return "base_pkg:encryption"
+}
+
+// Initialize 实现 gorm.Plugin
+func (p *EncryptionPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("base_pkg:encryption", p.fill); err != nil {
+		return err
+	}
+	return cb.Update().Before("gorm:update").Register("base_pkg:encryption", p.fill)
+}
+
+// parse 解析模型中的加密字段和哈希列
+func (p *EncryptionPlugin) parse(s *schema.Schema) (*encryptedSchema, error) {
+	if v, ok := p.schemas.Load(s); ok {
+		return v.(*encryptedSchema), nil
+	}
+	es := &encryptedSchema{}
+	for _, f := range s.Fields {
+		if strings.EqualFold(f.TagSettings["SERIALIZER"], "encrypted") && f.DBName != "" {
+			es.encrypted = append(es.encrypted, f)
+		}
+		name := f.Tag.Get(blindIndexTag)
+		if name == "" {
+			continue
+		}
+		source := s.LookUpField(name)
+		if source == nil {
+			return nil, fmt.Errorf("%s.%s 的 blind index 字段 %s 不存在", s.Name, f.Name, name)
+		}
+		if f.FieldType.Kind() != reflect.String {
+			return nil, fmt.Errorf("%s.%s 的 blind index 列需要为 string 类型", s.Name, f.Name)
+		}
+		es.blind = append(es.blind, blindField{hash: f, source: source})
+	}
+	p.schemas.Store(s, es)
+	return es, nil
+}
+
+// eachStruct 遍历单条记录或切片中的记录
+func eachStruct(rv reflect.Value, fn func(reflect.Value)) {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			fn(reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		fn(rv)
+	}
+}
+
+// fill 填充哈希列并加密 map 中的加密字段, 结构体中的加密字段由序列化器加密
+func (p *EncryptionPlugin) fill(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil {
+		return
+	}
+	es, err := p.parse(stmt.Schema)
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	if len(es.encrypted) == 0 && len(es.blind) == 0 {
+		return
+	}
+
+	if m, ok := stmt.Dest.(map[string]interface{}); ok {
+		if err = fillMap(es, m); err != nil {
+			_ = db.AddError(err)
+		}
+		return
+	}
+
+	targets := []reflect.Value{stmt.ReflectValue}
+	if dest := reflect.Indirect(reflect.ValueOf(stmt.Dest)); dest.IsValid() && dest.Type() == stmt.Schema.ModelType {
+		// Updates(struct) 的值在 Dest 中, 非指针时复制后替换以便写入哈希列
+		if !dest.CanAddr() {
+			cp := reflect.New(dest.Type())
+			cp.Elem().Set(dest)
+			stmt.Dest, dest = cp.Interface(), cp.Elem()
+		}
+		targets = append(targets, dest)
+	}
+	for _, rv := range targets {
+		eachStruct(rv, func(rv reflect.Value) {
+			if err == nil {
+				err = fillStruct(stmt.Context, es.blind, rv)
+			}
+		})
+		if err != nil {
+			_ = db.AddError(err)
+			return
+		}
+	}
+}
+
+// mapValue 按字段名或列名读取 map 中的值
+func mapValue(m map[string]interface{}, f *schema.Field) (string, interface{}, bool) {
+	if v, ok := m[f.Name]; ok {
+		return f.Name, v, true
+	}
+	v, ok := m[f.DBName]
+	return f.DBName, v, ok
+}
+
+// fillMap 先根据明文计算哈希列, 再加密明文
+func fillMap(es *encryptedSchema, m map[string]interface{}) error {
+	for _, bf := range es.blind {
+		_, v, ok := mapValue(m, bf.source)
+		if !ok {
+			continue
+		}
+		hash, err := blindIndexOf(v)
+		if err != nil {
+			return err
+		}
+		m[bf.hash.DBName] = hash
+	}
+	for _, f := range es.encrypted {
+		key, v, ok := mapValue(m, f)
+		if !ok {
+			continue
+		}
+		plain, ok, err := plainOf(v)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if m[key], err = encryptField(f, plain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillStruct 明文为零值时哈希列置空, Updates(struct) 时两者都不更新
+func fillStruct(ctx context.Context, fields []blindField, rv reflect.Value) error {
+	for _, bf := range fields {
+		// 加密字段的 ValueOf 返回序列化器, 直接读取字段值
+		v := bf.source.ReflectValueOf(ctx, rv)
+		hash := ""
+		if !v.IsZero() {
+			var err error
+			if hash, err = blindIndexOf(v.Interface()); err != nil {
+				return err
+			}
+		}
+		if err := bf.hash.Set(ctx, rv, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func blindIndexOf(v interface{}) (string, error) {
+	plain, ok, err := plainOf(v)
+	if err != nil || !ok {
+		return "", err
+	}
+	return BlindIndex(string(plain))
+}
+
+// ReencryptOptions
+// @Description: 重新加密配置
+type ReencryptOptions struct {
+	// 模型, 需要有单一主键
+	Model interface{}
+
+	// 需要重新加密的列名, 为空时使用模型中全部 serializer:encrypted 字段
+	Columns []string
+
+	// 每批记录数, 默认 500
+	BatchSize int
+
+	// 每批之间的间隔, 默认 100ms
+	Sleep time.Duration
+}
+
+// ReencryptResult
+// @Description: 重新加密结果
+type ReencryptResult struct {
+	Batches int   `json:"batches"`
+	Scanned int64 `json:"scanned"`
+
+	// 重新加密的记录数
+	Rotated int64 `json:"rotated"`
+
+	// 处理期间被修改而跳过的记录数, 再次运行时处理
+	Skipped int64 `json:"skipped"`
+}
+
+/*
+Reencryptor
+
+	@Description: 将旧密钥加密的数据按批次使用当前密钥重新加密, 已使用当前密钥的记录不修改, 可重复运行
+*/
+type Reencryptor struct {
+	db   *gorm.DB
+	opts ReencryptOptions
+}
+
+/*
+NewReencryptor
+
+	@Description: 创建重新加密任务
+	@param db: gorm 对象, 为 nil 时使用 GetDBConn
+	@param opts: 重新加密配置
+	@return *Reencryptor
+*/
+func NewReencryptor(db *gorm.DB, opts ReencryptOptions) *Reencryptor {
+	if db == nil {
+		db = GetDBConn()
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.Sleep <= 0 {
+		opts.Sleep = 100 * time.Millisecond
+	}
+	return &Reencryptor{db: db, opts: opts}
+}
+
+/*
+Run
+
+	@Description: 按主键顺序遍历全表重新加密, ctx 取消时在当前批次结束后返回
+	@return *ReencryptResult
+	@return error
+*/
+func (r *Reencryptor) Run(ctx context.Context) (*ReencryptResult, error) {
+	k, err := currentKeyring()
+	if err != nil {
+		return nil, err
+	}
+	db := r.db.WithContext(ctx)
+	stmt := &gorm.Statement{DB: db}
+	if err = stmt.Parse(r.opts.Model); err != nil {
+		return nil, err
+	}
+	if len(stmt.Schema.PrimaryFields) != 1 {
+		return nil, errors.New("重新加密需要模型有单一主键")
+	}
+	pk := stmt.Schema.PrimaryFields[0]
+	columns := r.opts.Columns
+	if len(columns) == 0 {
+		for _, f := range stmt.Schema.Fields {
+			if strings.EqualFold(f.TagSettings["SERIALIZER"], "encrypted") && f.DBName != "" {
+				columns = append(columns, f.DBName)
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("%s 没有加密字段", stmt.Schema.Table)
+	}
+
+	res := &ReencryptResult{}
+	last := reflect.New(pk.FieldType)
+	first := true
+	for {
+		if err = ctx.Err(); err != nil {
+			return res, err
+		}
+		q := db.Table(stmt.Schema.Table).Select(append([]string{pk.DBName}, columns...)).
+			Order(clause.OrderByColumn{Column: clause.Column{Name: pk.DBName}}).Limit(r.opts.BatchSize)
+		if !first {
+			q = q.Where(clause.Gt{Column: clause.Column{Name: pk.DBName}, Value: last.Elem().Interface()})
+		}
+		rows, err := r.batch(q, last, len(columns))
+		if err != nil {
+			return res, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		first = false
+		res.Batches++
+		res.Scanned += int64(len(rows))
+
+		if err = db.Transaction(func(tx *gorm.DB) error {
+			for _, row := range rows {
+				updates, where, err := rotateRow(k, stmt.Schema.Table, columns, row.values)
+				if err != nil {
+					return fmt.Errorf("%s %v: %w", stmt.Schema.Table, row.key, err)
+				}
+				if len(updates) == 0 {
+					continue
+				}
+				q := tx.Table(stmt.Schema.Table).Where(clause.Eq{Column: clause.Column{Name: pk.DBName}, Value: row.key})
+				for col, old := range where {
+					q = q.Where(clause.Eq{Column: clause.Column{Name: col}, Value: old})
+				}
+				result := q.UpdateColumns(updates)
+				if result.Error != nil {
+					return result.Error
+				}
+				if result.RowsAffected == 0 {
+					res.Skipped++
+				} else {
+					res.Rotated++
+				}
+			}
+			return nil
+		}); err != nil {
+			return res, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return res, ctx.Err()
+		case <-time.After(r.opts.Sleep):
+		}
+	}
+	zap.L().Info("reencrypt finished", zap.String("table", stmt.Schema.Table), zap.String("key", k.CurrentKeyID()),
+		zap.Int64("rotated", res.Rotated), zap.Int64("skipped", res.Skipped))
+	return res, nil
+}
+
+// reencryptRow 一条记录的主键和加密列的密文
+type reencryptRow struct {
+	key    interface{}
+	values []*string
+}
+
+// batch 读取一批记录, last 更新为最后一条的主键
+func (r *Reencryptor) batch(q *gorm.DB, last reflect.Value, n int) ([]reencryptRow, error) {
+	rows, err := q.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []reencryptRow
+	for rows.Next() {
+		key := reflect.New(last.Elem().Type())
+		row := reencryptRow{values: make([]*string, n)}
+		dest := []interface{}{key.Interface()}
+		for i := range row.values {
+			dest = append(dest, &row.values[i])
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row.key = key.Elem().Interface()
+		last.Elem().Set(key.Elem())
+		batch = append(batch, row)
+	}
+	return batch, rows.Err()
+}
+
+// rotateRow 返回需要更新的列的新密文及用于检查并发修改的旧密文
+func rotateRow(k *Keyring, table string, columns []string, values []*string) (map[string]interface{}, map[string]string, error) {
+	var (
+		updates map[string]interface{}
+		where   map[string]string
+	)
+	for i, v := range values {
+		// NULL 和空字符串与 Scan 一样视为没有值
+		if v == nil || *v == "" || KeyID(*v) == k.CurrentKeyID() {
+			continue
+		}
+		ad := fieldAD(table, columns[i])
+		plain, err := k.Decrypt(*v, ad)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decrypt %s: %w", columns[i], err)
+		}
+		ciphertext, err := k.Encrypt(plain, ad)
+		if err != nil {
+			return nil, nil, err
+		}
+		if updates == nil {
+			updates, where = make(map[string]interface{}), make(map[string]string)
+		}
+		updates[columns[i]] = ciphertext
+		where[columns[i]] = *v
+	}
+	return updates, where, nil
+}
@@ -0,0 +1,291 @@
+package Base_PKG
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+	"time"
+)
+
+type encryptedUser struct {
+	ID        uint
+	Phone     string `gorm:"serializer:encrypted"`
+	PhoneHash string `gorm:"index" blind_index:"Phone"`
+	Name      string
+}
+
+func testKeyring(t *testing.T, current string) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(EncryptionKeys{
+		Current: current,
+		Keys: map[string][]byte{
+			"k1": bytes.Repeat([]byte{1}, 32),
+			"k2": bytes.Repeat([]byte{2}, 32),
+		},
+		IndexKey: []byte("index"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// useKeyring 设置全局密钥, 测试结束后清除
+func useKeyring(t *testing.T, k *Keyring) {
+	SetKeyring(k)
+	t.Cleanup(func() { keyring.Store(nil) })
+}
+
+func encryptedDB(t *testing.T) *DB {
+	t.Helper()
+	d := openSQLiteDB(t, nil, &encryptedUser{})
+	if err := d.Conn().Use(NewEncryptionPlugin()); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// rawPhone 读取数据库中的密文
+func rawPhone(t *testing.T, d *DB, id uint) string {
+	t.Helper()
+	var s string
+	if err := d.Conn().Raw("SELECT phone FROM encrypted_user WHERE id = ?", id).Row().Scan(&s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestKeyring_EncryptDecrypt(t *testing.T) {
+	k := testKeyring(t, "k1")
+	ad := fieldAD("user", "phone")
+	ciphertext, err := k.Encrypt([]byte("13800000000"), ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, data, _ := strings.Cut(ciphertext, cipherSep)
+	sealed, _ := base64.RawStdEncoding.DecodeString(data)
+	sealed[len(sealed)-1] ^= 1
+	tampered := id + cipherSep + base64.RawStdEncoding.EncodeToString(sealed)
+
+	tests := []struct {
+		name       string
+		ciphertext string
+		ad         []byte
+		wantErr    error
+	}{
+		{name: "解密成功", ciphertext: ciphertext, ad: ad},
+		{name: "密文被修改", ciphertext: tampered, ad: ad, wantErr: ErrInvalidCipher},
+		{name: "其他表的密文", ciphertext: ciphertext, ad: fieldAD("order", "phone"), wantErr: ErrInvalidCipher},
+		{name: "其他列的密文", ciphertext: ciphertext, ad: fieldAD("user", "id_card"), wantErr: ErrInvalidCipher},
+		{name: "修改密钥 id", ciphertext: "k2" + strings.TrimPrefix(ciphertext, "k1"), ad: ad, wantErr: ErrInvalidCipher},
+		{name: "未知密钥 id", ciphertext: "k9" + strings.TrimPrefix(ciphertext, "k1"), ad: ad, wantErr: ErrUnknownKeyID},
+		{name: "格式错误", ciphertext: "13800000000", ad: ad, wantErr: ErrInvalidCipher},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, err := k.Decrypt(tt.ciphertext, tt.ad)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decrypt() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && string(plain) != "13800000000" {
+				t.Errorf("Decrypt() = %s", plain)
+			}
+		})
+	}
+}
+
+func TestEncryptedSerializer(t *testing.T) {
+	useKeyring(t, testKeyring(t, "k1"))
+	d := encryptedDB(t)
+	ctx := context.Background()
+
+	users := []encryptedUser{{Phone: "13800000001", Name: "a"}, {Phone: "13800000002", Name: "b"}}
+	if err := d.Ctx(ctx).Create(&users).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if raw := rawPhone(t, d, users[0].ID); KeyID(raw) != "k1" || strings.Contains(raw, "13800000001") {
+		t.Fatalf("stored phone = %s", raw)
+	}
+
+	// 自增主键的记录一次写入, 可以正常读取
+	var got []encryptedUser
+	if err := d.Ctx(ctx).Order("id").Find(&got).Error; err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if len(got) != 2 || got[0].Phone != "13800000001" || got[1].Phone != "13800000002" {
+		t.Fatalf("Find() = %+v", got)
+	}
+
+	// blind index 等值查询
+	hash, err := BlindIndex("13800000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found encryptedUser
+	if err = d.Ctx(ctx).Where("phone_hash = ?", hash).First(&found).Error; err != nil || found.Name != "b" {
+		t.Fatalf("blind index lookup = %+v, %v", found, err)
+	}
+
+	tests := []struct {
+		name   string
+		update func() error
+		want   string
+	}{
+		{name: "Updates 结构体", update: func() error {
+			return d.Ctx(ctx).Model(&users[0]).Updates(encryptedUser{Phone: "13900000001"}).Error
+		}, want: "13900000001"},
+		{name: "Updates map", update: func() error {
+			return d.Ctx(ctx).Model(&users[0]).Updates(map[string]interface{}{"phone": "13900000002"}).Error
+		}, want: "13900000002"},
+		{name: "Save", update: func() error {
+			u := users[0]
+			u.Phone = "13900000003"
+			return d.Ctx(ctx).Save(&u).Error
+		}, want: "13900000003"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.update(); err != nil {
+				t.Fatalf("update error = %v", err)
+			}
+			var u encryptedUser
+			if err := d.Ctx(ctx).First(&u, users[0].ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			hash, _ := BlindIndex(tt.want)
+			if u.Phone != tt.want || u.PhoneHash != hash {
+				t.Errorf("got %+v, want phone %s", u, tt.want)
+			}
+		})
+	}
+
+	t.Run("不指定主键批量更新", func(t *testing.T) {
+		if err := d.Ctx(ctx).Model(&encryptedUser{}).Where("name = ?", "b").Updates(map[string]interface{}{"phone": "1"}).Error; err != nil {
+			t.Fatalf("Updates() error = %v", err)
+		}
+		var u encryptedUser
+		if err := d.Ctx(ctx).First(&u, users[1].ID).Error; err != nil || u.Phone != "1" {
+			t.Errorf("First() = %+v, %v", u, err)
+		}
+	})
+
+	t.Run("只查询加密列", func(t *testing.T) {
+		var phones []encryptedUser
+		if err := d.Ctx(ctx).Select("phone").Order("id").Find(&phones).Error; err != nil || len(phones) != 2 || phones[1].Phone != "1" {
+			t.Errorf("Find() = %+v, %v", phones, err)
+		}
+	})
+
+	t.Run("NULL 和空字符串读取为零值", func(t *testing.T) {
+		if err := d.Conn().Exec("INSERT INTO encrypted_user (name, phone) VALUES ('null', NULL), ('empty', '')").Error; err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"null", "empty"} {
+			var u encryptedUser
+			if err := d.Ctx(ctx).Where("name = ?", name).First(&u).Error; err != nil || u.Phone != "" {
+				t.Errorf("%s: First() = %+v, %v", name, u, err)
+			}
+		}
+	})
+
+	t.Run("其他表使用模型表名解密", func(t *testing.T) {
+		if err := d.Conn().Exec("CREATE TABLE encrypted_copy AS SELECT * FROM encrypted_user").Error; err != nil {
+			t.Fatal(err)
+		}
+		var u encryptedUser
+		if err := d.Ctx(ctx).Table("encrypted_copy").First(&u, users[0].ID).Error; err != nil {
+			t.Fatalf("First() error = %v", err)
+		}
+		if u.Phone != "13900000003" {
+			t.Errorf("First() phone = %s", u.Phone)
+		}
+	})
+}
+
+func TestEncryptionPlugin_SingleWrite(t *testing.T) {
+	useKeyring(t, testKeyring(t, "k1"))
+	d := encryptedDB(t)
+	ctx := context.Background()
+
+	// 插入之后的写入全部失败, 自增主键的记录仍能读取和轮换
+	if err := d.Conn().Callback().Update().Before("gorm:update").Register("test:fail_update", func(db *gorm.DB) {
+		_ = db.AddError(errors.New("update fail"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	u := encryptedUser{Phone: "13800000001"}
+	if err := d.Ctx(ctx).Create(&u).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	var got encryptedUser
+	if err := d.Ctx(ctx).First(&got, u.ID).Error; err != nil || got.Phone != u.Phone {
+		t.Fatalf("First() = %+v, %v", got, err)
+	}
+	if err := d.Conn().Callback().Update().Remove("test:fail_update"); err != nil {
+		t.Fatal(err)
+	}
+
+	SetKeyring(testKeyring(t, "k2"))
+	res, err := NewReencryptor(d.Conn(), ReencryptOptions{Model: &encryptedUser{}, Sleep: time.Millisecond}).Run(ctx)
+	if err != nil || res.Rotated != 1 {
+		t.Fatalf("Run() = %+v, %v", res, err)
+	}
+	got = encryptedUser{}
+	if err = d.Ctx(ctx).First(&got, u.ID).Error; err != nil || got.Phone != u.Phone {
+		t.Errorf("First() after rotation = %+v, %v", got, err)
+	}
+}
+
+func TestReencryptor(t *testing.T) {
+	useKeyring(t, testKeyring(t, "k1"))
+	d := encryptedDB(t)
+	ctx := context.Background()
+	users := []encryptedUser{{Phone: "1"}, {Phone: "2"}, {Phone: "3"}}
+	if err := d.Ctx(ctx).Create(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 空字符串不轮换
+	if err := d.Conn().Exec("INSERT INTO encrypted_user (name, phone) VALUES ('empty', '')").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	SetKeyring(testKeyring(t, "k2"))
+	r := NewReencryptor(d.Conn(), ReencryptOptions{Model: &encryptedUser{}, BatchSize: 2, Sleep: time.Millisecond})
+	tests := []struct {
+		name string
+		want ReencryptResult
+	}{
+		{name: "轮换到新密钥", want: ReencryptResult{Batches: 2, Scanned: 4, Rotated: 3}},
+		{name: "重复运行不修改", want: ReencryptResult{Batches: 2, Scanned: 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := r.Run(ctx)
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if *res != tt.want {
+				t.Errorf("Run() = %+v, want %+v", *res, tt.want)
+			}
+		})
+	}
+
+	for _, u := range users {
+		if id := KeyID(rawPhone(t, d, u.ID)); id != "k2" {
+			t.Errorf("user %d key = %s, want k2", u.ID, id)
+		}
+	}
+	var got []encryptedUser
+	if err := d.Ctx(ctx).Order("id").Limit(len(users)).Find(&got).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i, u := range got {
+		if u.Phone != users[i].Phone {
+			t.Errorf("user %d phone = %s, want %s", u.ID, u.Phone, users[i].Phone)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package Base_PKG
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+)
+
+const (
+	// mysql 唯一键冲突
+	errCodeDuplicate uint16 = 1062
+
+	// mysql 外键约束失败
+	errCodeForeignKey uint16 = 1452
+)
+
+var (
+	ErrNotFound   = errors.New("记录不存在")
+	ErrDuplicate  = errors.New("记录重复")
+	ErrForeignKey = errors.New("外键约束失败")
+
+	// ErrStaleObject 乐观锁更新时记录已被其他请求修改
+	ErrStaleObject = errors.New("记录已被修改")
+)
+
+/*
+TranslateError
+
+	@Description: 将 gorm 和 mysql 的错误转换为包内的哨兵错误, 保留原始错误供 errors.As 使用
+	@param err: 原始错误
+	@return error
+*/
+func TranslateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	if code, ok := mysqlErrorCode(err); ok {
+		switch code {
+		case errCodeDuplicate:
+			return fmt.Errorf("%w: %w", ErrDuplicate, err)
+		case errCodeForeignKey:
+			return fmt.Errorf("%w: %w", ErrForeignKey, err)
+		}
+	}
+	return err
+}
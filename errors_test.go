@@ -0,0 +1,45 @@
+package Base_PKG
+
+import (
+	"errors"
+	"fmt"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestTranslateError(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		want          error
+		wantRetryable bool
+	}{
+		{name: "nil", err: nil, want: nil},
+		{name: "记录不存在", err: gorm.ErrRecordNotFound, want: ErrNotFound},
+		{name: "唯一键冲突", err: &mysqlDriver.MySQLError{Number: 1062, Message: "Duplicate entry"}, want: ErrDuplicate},
+		{name: "外键约束失败", err: fmt.Errorf("insert: %w", &mysqlDriver.MySQLError{Number: 1452}), want: ErrForeignKey},
+		{name: "死锁保持原始错误", err: &mysqlDriver.MySQLError{Number: 1213}, wantRetryable: true},
+		{name: "锁等待超时保持原始错误", err: &mysqlDriver.MySQLError{Number: 1205}, wantRetryable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TranslateError(tt.err)
+			want := tt.want
+			if want == nil {
+				want = tt.err
+			}
+			if !errors.Is(got, want) {
+				t.Errorf("TranslateError() = %v, want %v", got, want)
+			}
+			// 原始错误仍可通过 errors.As 获取
+			var mysqlErr *mysqlDriver.MySQLError
+			if errors.As(tt.err, &mysqlErr) && !errors.As(got, &mysqlErr) {
+				t.Errorf("TranslateError() = %v lost mysql error", got)
+			}
+			if IsRetryableTxError(got) != tt.wantRetryable {
+				t.Errorf("IsRetryableTxError() = %v, want %v", !tt.wantRetryable, tt.wantRetryable)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	基于 key 前缀的缓存失效广播, 写入方 put 失效 key, 所有实例监听前缀并清理本地缓存
+*/
+
+type (
+	invalidator struct {
+		client *clientv3.Client
+		ctx    context.Context
+
+		// 失效 key 的前缀
+		prefix string
+
+		// 失效 key 的租约时间, 到期自动删除
+		ttl int64
+
+		host string
+
+		// 多次 Publish 复用的租约及其到期时间
+		mu          sync.Mutex
+		lease       clientv3.LeaseID
+		leaseExpire time.Time
+	}
+
+	InvalidatorDO interface {
+
+		// Publish 广播失效 key
+		Publish(key string) error
+
+		/*Watch
+		@Description: 监听失效 key, 阻塞直到 ctx 取消. 监听中断且无法补齐期间的事件时以空 key 回调, 表示全部失效
+		@param onInvalidate: 失效回调, 参数为去掉前缀的 key
+		*/
+		Watch(ctx context.Context, onInvalidate func(key string))
+	}
+)
+
+/*
+NewInvalidator
+
+	@Description: 缓存失效广播
+	@param prefix: 失效 key 的前缀, 如 /cache/invalidate/
+	@param ttl: 失效 key 的保留时间, 单位秒. 租约在多次 Publish 间复用, 实际保留 ttl/2 ~ ttl 秒
+	@return InvalidatorDO
+*/
+func NewInvalidator(prefix string, ttl int64) InvalidatorDO {
+	if !etcdCLI.Invited() {
+		return nil
+	}
+	if etcdCLI.Err() != nil {
+		return nil
+	}
+	host, _ := os.Hostname()
+	return &invalidator{
+		client: etcdCLI.Client(),
+		ctx:    context.Background(),
+		prefix: prefix,
+		ttl:    ttl,
+		host:   host,
+	}
+}
+
+func (i *invalidator) Publish(key string) error {
+	lease, err := i.leaseID()
+	if err != nil {
+		return err
+	}
+	if _, err = i.client.Put(i.ctx, i.prefix+key, i.host, clientv3.WithLease(lease)); err != nil {
+		// 租约可能已失效(如 etcd 重启), 下次重新申请
+		i.resetLease(lease)
+	}
+	return err
+}
+
+// leaseID 复用当前租约, 剩余时间不足一半时重新申请, 避免每次 Publish 都申请租约
+func (i *invalidator) leaseID() (clientv3.LeaseID, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.lease != 0 && time.Until(i.leaseExpire) > time.Duration(i.ttl)*time.Second/2 {
+		return i.lease, nil
+	}
+	leaseGrant, err := i.client.Grant(i.ctx, i.ttl)
+	if err != nil {
+		return 0, err
+	}
+	i.lease = leaseGrant.ID
+	i.leaseExpire = time.Now().Add(time.Duration(leaseGrant.TTL) * time.Second)
+	return i.lease, nil
+}
+
+func (i *invalidator) resetLease(lease clientv3.LeaseID) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.lease == lease {
+		i.lease = 0
+	}
+}
+
+func (i *invalidator) Watch(ctx context.Context, onInvalidate func(key string)) {
+	// 已处理到的 revision, 中断后从 rev+1 续接, 补齐中断期间的失效 key
+	var rev int64
+	for ctx.Err() == nil {
+		if rev == 0 {
+			// 首次监听前记录当前 revision, 首次监听失败时同样可以续接
+			resp, err := i.client.Get(ctx, i.prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+			if err != nil {
+				zap.L().Warn("invalidator get revision found error", zap.String("prefix", i.prefix), zap.Error(err))
+				i.wait(ctx)
+				continue
+			}
+			rev = resp.Header.Revision
+		}
+
+		opts := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithFilterDelete(), clientv3.WithRev(rev + 1)}
+		for resp := range i.client.Watch(clientv3.WithRequireLeader(ctx), i.prefix, opts...) {
+			if resp.CompactRevision > 0 || errors.Is(resp.Err(), rpctypes.ErrCompacted) {
+				// 期间的事件已被压缩, 无法补齐, 全部失效后从压缩点继续
+				zap.L().Warn("invalidator watch compacted", zap.String("prefix", i.prefix), zap.Int64("revision", resp.CompactRevision))
+				if resp.CompactRevision > 0 {
+					rev = resp.CompactRevision - 1
+				} else {
+					rev = 0
+				}
+				onInvalidate("")
+				break
+			}
+			if err := resp.Err(); err != nil {
+				zap.L().Warn("invalidator watch found error", zap.String("prefix", i.prefix), zap.Int64("revision", rev), zap.Error(err))
+				break
+			}
+			for _, ev := range resp.Events {
+				onInvalidate(strings.TrimPrefix(string(ev.Kv.Key), i.prefix))
+				rev = ev.Kv.ModRevision
+			}
+			if resp.Header.Revision > rev {
+				rev = resp.Header.Revision
+			}
+		}
+		i.wait(ctx)
+	}
+}
+
+// wait 重新监听前等待 1s, ctx 取消时立即返回
+func (i *invalidator) wait(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+	}
+}
@@ -136,6 +136,25 @@ FAIL:
 	return err
 }
 
+/*
+IsHeld
+
+	@Description: 检查锁是否仍被持有, 租约过期后锁的 key 被删除或属于其他租约
+	@return bool
+*/
+func (e *EtcdMutex) IsHeld() bool {
+	if !e.lock {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	resp, err := e.client.Get(ctx, e.Key)
+	if err != nil || len(resp.Kvs) == 0 {
+		return false
+	}
+	return clientv3.LeaseID(resp.Kvs[0].Lease) == e.leaseId
+}
+
 /*
 UnLock
 
@@ -0,0 +1,193 @@
+package etcd
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+/*
+	基于 mysql GET_LOCK 的锁, 用于没有 etcd 的部署, 可直接传给 NewWatcher
+*/
+
+const (
+	// mysql 锁名称最长 64 个字符
+	maxLockNameLen = 64
+
+	// 持有锁期间 ping 连接的间隔, 需小于 mysql 的 wait_timeout, 避免空闲连接被服务端断开导致锁被释放
+	mysqlKeepAlive = 30 * time.Second
+)
+
+// ErrMutexLost 释放时锁已不属于当前连接, 如连接断开后锁被自动释放
+var ErrMutexLost = errors.New("锁已丢失")
+
+/*
+MysqlMutex
+
+	@Description: mysql 锁. 锁与持有它的 mysql 会话绑定, 抢锁成功后独占一个连接直到释放,
+	持有期间定时 ping 保持连接. 进程退出或连接断开时 mysql 自动释放锁, 相当于 etcd 锁的租约
+*/
+type MysqlMutex struct {
+	db        *sql.DB
+	Key       string
+	name      string
+	timeout   time.Duration // 等待锁的时间, 0 表示不等待
+	keepAlive time.Duration // ping 连接的间隔
+
+	mu   sync.Mutex
+	conn *sql.Conn     // 持有锁的连接
+	stop chan struct{} // 关闭时停止 ping
+	done chan struct{} // ping 协程退出后关闭
+}
+
+/*
+NewMysqlMutex
+
+	@Description: 创建 mysql 锁
+	@param db: mysql 连接池
+	@param name: 锁名称, 超过 64 个字符时使用其 sha1
+	@param timeout: 等待锁的时间, 0 表示锁被占用时立即返回 MutexGrabFAIL
+	@return EMutex
+*/
+func NewMysqlMutex(db *sql.DB, name string, timeout time.Duration) EMutex {
+	lockName := name
+	if len(lockName) > maxLockNameLen {
+		sum := sha1.Sum([]byte(name))
+		lockName = hex.EncodeToString(sum[:])
+	}
+	return &MysqlMutex{db: db, Key: name, name: lockName, timeout: timeout, keepAlive: mysqlKeepAlive}
+}
+
+/*
+Lock
+
+	@Description: 抢锁, 锁被占用或已持有时返回 MutexGrabFAIL. mysql 锁不保存值, v 只记录在日志中
+	@param v: 锁的值
+	@return error
+*/
+func (m *MysqlMutex) Lock(v string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn != nil {
+		return MutexGrabFAIL
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout+5*time.Second)
+	defer cancel()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	// GET_LOCK 返回 1 成功, 0 超时, NULL 出错. 超时时间支持小数秒
+	var res sql.NullInt64
+	if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", m.name, m.timeout.Seconds()).Scan(&res); err != nil {
+		discard(conn)
+		return err
+	}
+	switch {
+	case !res.Valid:
+		discard(conn)
+		return fmt.Errorf("get lock %s found error", m.Key)
+	case res.Int64 != 1:
+		_ = conn.Close()
+		return MutexGrabFAIL
+	}
+	m.conn = conn
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go m.keepConn(conn, m.stop, m.done)
+	zap.L().Debug("mysql mutex locked", zap.String("key", m.Key), zap.String("value", v))
+	return nil
+}
+
+/*
+IsHeld
+
+	@Description: 检查锁是否仍被当前连接持有, 连接断开后锁已被 mysql 释放
+	@return bool
+*/
+func (m *MysqlMutex) IsHeld() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// IS_USED_LOCK 返回持有锁的连接 id, 没有被持有时为 NULL
+	var held sql.NullBool
+	if err := m.conn.QueryRowContext(ctx, "SELECT IS_USED_LOCK(?) = CONNECTION_ID()", m.name).Scan(&held); err != nil {
+		zap.L().Error("mysql mutex check found error", zap.String("key", m.Key), zap.Error(err))
+		return false
+	}
+	return held.Valid && held.Bool
+}
+
+// keepConn 定时 ping 持有锁的连接直到 stop 关闭. ping 失败说明连接已断开, 锁已被 mysql 释放
+func (m *MysqlMutex) keepConn(conn *sql.Conn, stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(m.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := conn.PingContext(ctx)
+			cancel()
+			if err != nil {
+				zap.L().Error("mysql mutex keepalive failed, lock may be lost", zap.String("key", m.Key), zap.Error(err))
+				return
+			}
+		}
+	}
+}
+
+/*
+UnLock
+
+	@Description: 释放锁, 未持有锁时不处理
+	@return error
+*/
+func (m *MysqlMutex) UnLock() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == nil {
+		return nil
+	}
+	conn := m.conn
+	m.conn = nil
+	close(m.stop)
+	<-m.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// RELEASE_LOCK 返回 1 成功, 0 锁属于其他会话, NULL 锁不存在
+	var res sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", m.name).Scan(&res); err != nil {
+		// 释放失败时关闭连接, 由 mysql 随会话释放锁
+		discard(conn)
+		return fmt.Errorf("释放锁异常: %w", err)
+	}
+	_ = conn.Close()
+	if !res.Valid || res.Int64 != 1 {
+		return fmt.Errorf("release lock %s: %w", m.Key, ErrMutexLost)
+	}
+	return nil
+}
+
+// discard 关闭底层连接而不是放回连接池, 避免连接上残留的锁被其他请求复用
+func discard(conn *sql.Conn) {
+	_ = conn.Raw(func(interface{}) error {
+		return driver.ErrBadConn
+	})
+	_ = conn.Close()
+}
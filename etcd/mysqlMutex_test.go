@@ -0,0 +1,219 @@
+package etcd
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLockServer 模拟 mysql 的 GET_LOCK/RELEASE_LOCK, 记录调用参数和 ping 次数
+type fakeLockServer struct {
+	mu       sync.Mutex
+	getLock  interface{} // GET_LOCK 的返回值, nil 表示 NULL
+	release  interface{}
+	isUsed   interface{} // IS_USED_LOCK(?) = CONNECTION_ID() 的返回值
+	timeouts []interface{}
+	pings    int
+}
+
+func (s *fakeLockServer) Open(string) (driver.Conn, error) {
+	return &fakeLockConn{s: s}, nil
+}
+
+type fakeLockConn struct {
+	s *fakeLockServer
+}
+
+func (c *fakeLockConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *fakeLockConn) Close() error {
+	return nil
+}
+
+func (c *fakeLockConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *fakeLockConn) Ping(context.Context) error {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	c.s.pings++
+	return nil
+}
+
+func (c *fakeLockConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	switch {
+	case strings.Contains(query, "GET_LOCK"):
+		c.s.timeouts = append(c.s.timeouts, args[1].Value)
+		return &fakeLockRows{v: c.s.getLock}, nil
+	case strings.Contains(query, "RELEASE_LOCK"):
+		return &fakeLockRows{v: c.s.release}, nil
+	case strings.Contains(query, "IS_USED_LOCK"):
+		return &fakeLockRows{v: c.s.isUsed}, nil
+	}
+	return nil, errors.New("unexpected query: " + query)
+}
+
+type fakeLockRows struct {
+	v    interface{}
+	read bool
+}
+
+func (r *fakeLockRows) Columns() []string {
+	return []string{"res"}
+}
+
+func (r *fakeLockRows) Close() error {
+	return nil
+}
+
+func (r *fakeLockRows) Next(dest []driver.Value) error {
+	if r.read {
+		return io.EOF
+	}
+	r.read = true
+	dest[0] = r.v
+	return nil
+}
+
+var registerFakeLock sync.Once
+
+// fakeLockDB 每个测试使用独立的 fakeLockServer
+func fakeLockDB(t *testing.T, s *fakeLockServer) *sql.DB {
+	t.Helper()
+	registerFakeLock.Do(func() {
+		sql.Register("etcd_fake_lock", fakeLockDriver{})
+	})
+	fakeLockServers.Store(t.Name(), s)
+	db, err := sql.Open("etcd_fake_lock", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+		fakeLockServers.Delete(t.Name())
+	})
+	return db
+}
+
+var fakeLockServers sync.Map
+
+type fakeLockDriver struct{}
+
+func (fakeLockDriver) Open(name string) (driver.Conn, error) {
+	s, ok := fakeLockServers.Load(name)
+	if !ok {
+		return nil, errors.New("unknown server: " + name)
+	}
+	return s.(*fakeLockServer).Open(name)
+}
+
+func TestMysqlMutex_Lock(t *testing.T) {
+	tests := []struct {
+		name       string
+		getLock    interface{}
+		release    interface{}
+		wantErr    error
+		wantAnyErr bool
+		wantUnlock error
+	}{
+		{name: "抢锁成功", getLock: int64(1), release: int64(1)},
+		{name: "锁被占用", getLock: int64(0), wantErr: MutexGrabFAIL},
+		{name: "GET_LOCK 返回 NULL", getLock: nil, wantAnyErr: true},
+		{name: "释放时锁已丢失", getLock: int64(1), release: nil, wantUnlock: ErrMutexLost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &fakeLockServer{getLock: tt.getLock, release: tt.release}
+			m := NewMysqlMutex(fakeLockDB(t, s), "job", 1500*time.Millisecond)
+
+			err := m.Lock("v")
+			switch {
+			case tt.wantAnyErr:
+				if err == nil || errors.Is(err, MutexGrabFAIL) {
+					t.Fatalf("Lock() error = %v, want non-grab error", err)
+				}
+				return
+			case !errors.Is(err, tt.wantErr):
+				t.Fatalf("Lock() error = %v, want %v", err, tt.wantErr)
+			case err != nil:
+				return
+			}
+			if s.timeouts[0] != 1.5 {
+				t.Errorf("GET_LOCK timeout = %v, want 1.5", s.timeouts[0])
+			}
+			// 已持有时再次抢锁失败
+			if err = m.Lock("v"); !errors.Is(err, MutexGrabFAIL) {
+				t.Errorf("Lock() again error = %v, want %v", err, MutexGrabFAIL)
+			}
+			if err = m.UnLock(); !errors.Is(err, tt.wantUnlock) {
+				t.Errorf("UnLock() error = %v, want %v", err, tt.wantUnlock)
+			}
+		})
+	}
+}
+
+func TestMysqlMutex_KeepAlive(t *testing.T) {
+	s := &fakeLockServer{getLock: int64(1), release: int64(1)}
+	m := NewMysqlMutex(fakeLockDB(t, s), "job", 0).(*MysqlMutex)
+	m.keepAlive = 5 * time.Millisecond
+	if err := m.Lock("v"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := m.UnLock(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	pings := s.pings
+	s.mu.Unlock()
+	if pings == 0 {
+		t.Errorf("pings = 0, want > 0")
+	}
+	// 释放后不再 ping
+	time.Sleep(20 * time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pings != pings {
+		t.Errorf("pings after unlock = %d, want %d", s.pings, pings)
+	}
+}
+
+func TestMysqlMutex_IsHeld(t *testing.T) {
+	tests := []struct {
+		name   string
+		isUsed interface{}
+		want   bool
+	}{
+		{name: "当前连接持有", isUsed: int64(1), want: true},
+		{name: "其他连接持有", isUsed: int64(0), want: false},
+		{name: "锁已被释放", isUsed: nil, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &fakeLockServer{getLock: int64(1), release: int64(1), isUsed: tt.isUsed}
+			m := NewMysqlMutex(fakeLockDB(t, s), "job", 0).(*MysqlMutex)
+			if m.IsHeld() {
+				t.Errorf("IsHeld() before Lock() = true")
+			}
+			if err := m.Lock("v"); err != nil {
+				t.Fatal(err)
+			}
+			defer m.UnLock()
+			if got := m.IsHeld(); got != tt.want {
+				t.Errorf("IsHeld() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+	雪花算法 id 生成器, worker id 通过 etcd 租约抢占
+	id 结构: 1 位符号位 | 41 位毫秒时间戳 | 10 位 worker id | 12 位序列号
+*/
+
+const (
+	snowflakeWorkerBits   = 10
+	snowflakeSequenceBits = 12
+
+	snowflakeMaxWorker   = 1<<snowflakeWorkerBits - 1
+	snowflakeMaxSequence = 1<<snowflakeSequenceBits - 1
+
+	// 起始时间 2024-01-01 00:00:00 UTC, 毫秒
+	snowflakeEpoch int64 = 1704067200000
+
+	// 可等待的最大时钟回拨
+	snowflakeMaxBackward = 5 * time.Millisecond
+)
+
+var (
+	ErrNoWorkerID      = errors.New("没有可用的 worker id")
+	ErrWorkerLeaseLost = errors.New("worker id 租约已失效, 停止生成 id")
+	ErrClockRollback   = errors.New("时钟回拨")
+)
+
+type (
+	snowflake struct {
+		client *clientv3.Client
+
+		// worker id 的 key 前缀
+		prefix   string
+		workerID int64
+
+		lease   clientv3.Lease
+		leaseId clientv3.LeaseID
+		cancel  context.CancelFunc
+
+		mu sync.Mutex
+
+		// 租约到期时间, 超过后不再生成 id
+		expireAt time.Time
+		lost     bool
+		lastTs   int64
+		sequence int64
+
+		now func() time.Time
+	}
+
+	// SnowflakeID 解析后的 id
+	SnowflakeID struct {
+		Time     time.Time
+		WorkerID int64
+		Sequence int64
+	}
+
+	SnowflakeDO interface {
+
+		// NextID 生成 id, 租约失效或时钟回拨时返回错误
+		NextID() (int64, error)
+
+		// WorkerID 抢占到的 worker id
+		WorkerID() int64
+
+		// Close 停止续租并释放 worker id
+		Close() error
+	}
+)
+
+/*
+NewSnowflake
+
+	@Description: 创建 id 生成器, 在 prefix 下抢占一个 worker id 并自动续租
+	@param prefix: worker id 的 key 前缀, 如 /snowflake/order/
+	@param ttl: 租约时间, 单位秒
+	@return SnowflakeDO
+	@return error
+*/
+func NewSnowflake(prefix string, ttl int64) (SnowflakeDO, error) {
+	if !etcdCLI.Invited() {
+		return nil, errors.New("etcd 客户端未初始化")
+	}
+	if etcdCLI.Err() != nil {
+		return nil, etcdCLI.Err()
+	}
+
+	s := &snowflake{
+		client: etcdCLI.Client(),
+		prefix: prefix,
+		lease:  clientv3.NewLease(etcdCLI.Client()),
+		now:    time.Now,
+	}
+	leaseResp, err := s.lease.Grant(context.TODO(), ttl)
+	if err != nil {
+		return nil, err
+	}
+	s.leaseId = leaseResp.ID
+	s.expireAt = time.Now().Add(time.Duration(leaseResp.TTL) * time.Second)
+
+	if s.workerID, err = s.claim(); err != nil {
+		_, _ = s.lease.Revoke(context.TODO(), s.leaseId)
+		return nil, err
+	}
+
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.TODO())
+	keepResChan, err := s.lease.KeepAlive(ctx, s.leaseId)
+	if err != nil {
+		s.cancel()
+		_, _ = s.lease.Revoke(context.TODO(), s.leaseId)
+		return nil, err
+	}
+	go s.keepAlive(keepResChan)
+	return s, nil
+}
+
+// claim 按序号依次尝试抢占 worker id
+func (s *snowflake) claim() (int64, error) {
+	host, _ := os.Hostname()
+	for id := int64(0); id <= snowflakeMaxWorker; id++ {
+		key := s.prefix + strconv.FormatInt(id, 10)
+		resp, err := s.client.Txn(context.TODO()).
+			If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+			Then(clientv3.OpPut(key, host, clientv3.WithLease(s.leaseId))).
+			Commit()
+		if err != nil {
+			return 0, err
+		}
+		if resp.Succeeded {
+			return id, nil
+		}
+	}
+	return 0, ErrNoWorkerID
+}
+
+// keepAlive 处理续租响应, 通道关闭说明租约已失效
+func (s *snowflake) keepAlive(keepResChan <-chan *clientv3.LeaseKeepAliveResponse) {
+	for keepRes := range keepResChan {
+		if keepRes == nil {
+			break
+		}
+		s.mu.Lock()
+		s.expireAt = time.Now().Add(time.Duration(keepRes.TTL) * time.Second)
+		s.mu.Unlock()
+	}
+	s.mu.Lock()
+	s.lost = true
+	s.mu.Unlock()
+	zap.L().Warn("snowflake worker id 租约失效", zap.String("prefix", s.prefix), zap.Int64("workerId", s.workerID))
+}
+
+func (s *snowflake) WorkerID() int64 {
+	return s.workerID
+}
+
+/*
+NextID
+
+	@Description: 生成 id, 同一毫秒内序列号用完时等待下一毫秒; 小幅时钟回拨时等待, 超过 5ms 返回 ErrClockRollback
+	@return int64
+	@return error
+*/
+func (s *snowflake) NextID() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.lost || !now.Before(s.expireAt) {
+		return 0, ErrWorkerLeaseLost
+	}
+
+	ts := now.UnixMilli()
+	if ts < s.lastTs {
+		backward := time.Duration(s.lastTs-ts) * time.Millisecond
+		if backward > snowflakeMaxBackward {
+			return 0, fmt.Errorf("%w: %s", ErrClockRollback, backward)
+		}
+		ts = s.waitUntil(s.lastTs)
+	}
+
+	if ts == s.lastTs {
+		s.sequence = (s.sequence + 1) & snowflakeMaxSequence
+		if s.sequence == 0 {
+			ts = s.waitUntil(s.lastTs + 1)
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.lastTs = ts
+
+	return (ts-snowflakeEpoch)<<(snowflakeWorkerBits+snowflakeSequenceBits) |
+		s.workerID<<snowflakeSequenceBits |
+		s.sequence, nil
+}
+
+// waitUntil 等待到指定毫秒
+func (s *snowflake) waitUntil(ts int64) int64 {
+	now := s.now().UnixMilli()
+	for now < ts {
+		time.Sleep(time.Duration(ts-now) * time.Millisecond)
+		now = s.now().UnixMilli()
+	}
+	return now
+}
+
+func (s *snowflake) Close() error {
+	s.mu.Lock()
+	s.lost = true
+	s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if _, err := s.lease.Revoke(context.TODO(), s.leaseId); err != nil {
+		return fmt.Errorf("释放 worker id 异常: %v", err)
+	}
+	return nil
+}
+
+/*
+DecodeSnowflake
+
+	@Description: 解析 id 的生成时间、worker id 和序列号
+	@param id: 雪花算法生成的 id
+	@return SnowflakeID
+*/
+func DecodeSnowflake(id int64) SnowflakeID {
+	return SnowflakeID{
+		Time:     time.UnixMilli(id>>(snowflakeWorkerBits+snowflakeSequenceBits) + snowflakeEpoch),
+		WorkerID: id >> snowflakeSequenceBits & snowflakeMaxWorker,
+		Sequence: id & snowflakeMaxSequence,
+	}
+}
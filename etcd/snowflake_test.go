@@ -0,0 +1,70 @@
+package etcd
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_snowflake_NextID(t *testing.T) {
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		clock    []time.Duration
+		lost     bool
+		wantErr  error
+		wantSeqs []int64
+	}{
+		{
+			name:     "同一毫秒内序列号递增",
+			clock:    []time.Duration{0, 0, 0},
+			wantSeqs: []int64{0, 1, 2},
+		},
+		{
+			name:     "跨毫秒序列号归零",
+			clock:    []time.Duration{0, 0, time.Millisecond},
+			wantSeqs: []int64{0, 1, 0},
+		},
+		{
+			name:    "时钟回拨超过容忍范围",
+			clock:   []time.Duration{time.Second, 0},
+			wantErr: ErrClockRollback,
+		},
+		{
+			name:    "租约失效后停止生成",
+			clock:   []time.Duration{0},
+			lost:    true,
+			wantErr: ErrWorkerLeaseLost,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var i int
+			s := &snowflake{
+				workerID: 37,
+				expireAt: base.Add(time.Minute),
+				lost:     tt.lost,
+				now: func() time.Time {
+					return base.Add(tt.clock[min(i, len(tt.clock)-1)])
+				},
+			}
+			var err error
+			for ; i < len(tt.clock); i++ {
+				var id int64
+				if id, err = s.NextID(); err != nil {
+					break
+				}
+				if i >= len(tt.wantSeqs) {
+					continue
+				}
+				got := DecodeSnowflake(id)
+				if got.WorkerID != 37 || got.Sequence != tt.wantSeqs[i] || !got.Time.Equal(base.Add(tt.clock[i])) {
+					t.Errorf("DecodeSnowflake() = %+v, want worker 37, seq %d, time %v", got, tt.wantSeqs[i], base.Add(tt.clock[i]))
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("NextID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package Base_PKG
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+	连接池健康检查, 定期 ping 底层 sql.DB, 跟踪健康状态变化
+	sql.DB 在 ping 时会重新拨号, 数据库恢复后连接池自动重连
+*/
+
+// HealthOptions
+// @Description: 健康检查配置
+type HealthOptions struct {
+	// 检查间隔, 默认 10s
+	Interval time.Duration
+
+	// 单次 ping 超时时间, 默认 3s
+	Timeout time.Duration
+
+	// 连续失败多少次判定为不健康, 默认 3
+	FailureThreshold int
+
+	// 连续成功多少次判定为恢复, 默认 1
+	RecoverThreshold int
+}
+
+// HealthEvent
+// @Description: 健康状态变化事件
+type HealthEvent struct {
+	// 检查目标名称
+	Name string
+
+	DB *DB
+
+	// 变化后的状态
+	Healthy bool
+
+	// 最近一次 ping 的错误, 恢复时为 nil
+	Err error
+
+	At time.Time
+}
+
+// HealthCallback 健康状态变化时的回调
+type HealthCallback func(event HealthEvent)
+
+type healthTarget struct {
+	name      string
+	db        *DB
+	healthy   bool
+	fails     int
+	successes int
+
+	// onChange 目标自身的状态变化处理, 如从库摘除和恢复
+	onChange func(healthy bool)
+}
+
+/*
+HealthChecker
+
+	@Description: 后台健康检查器
+*/
+type HealthChecker struct {
+	opts HealthOptions
+
+	mu        sync.Mutex
+	targets   []*healthTarget
+	callbacks []HealthCallback
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+/*
+NewHealthChecker
+
+	@Description: 创建健康检查器, 调用 Start 后开始后台检查
+	@param opts: 检查配置
+	@return *HealthChecker
+*/
+func NewHealthChecker(opts HealthOptions) *HealthChecker {
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 3 * time.Second
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 3
+	}
+	if opts.RecoverThreshold <= 0 {
+		opts.RecoverThreshold = 1
+	}
+	return &HealthChecker{opts: opts}
+}
+
+/*
+Watch
+
+	@Description: 添加检查目标, 初始状态视为健康
+	@param name: 目标名称
+	@param db: 数据库连接
+*/
+func (h *HealthChecker) Watch(name string, db *DB) {
+	h.watch(name, db, nil)
+}
+
+/*
+WatchCluster
+
+	@Description: 检查集群的主库和全部从库, 从库不健康时摘除出读轮询, 恢复后重新加入
+	@param c: 数据库集群
+*/
+func (h *HealthChecker) WatchCluster(c *Cluster) {
+	h.watch(c.name+"/primary", c.primary, nil)
+	for i, r := range c.replicas {
+		replica := r
+		h.watch(c.name+"/replica-"+strconv.Itoa(i), replica, func(healthy bool) {
+			if healthy {
+				c.restore(replica)
+			} else {
+				c.eject(replica)
+			}
+		})
+	}
+}
+
+func (h *HealthChecker) watch(name string, db *DB, onChange func(healthy bool)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.targets = append(h.targets, &healthTarget{name: name, db: db, healthy: true, onChange: onChange})
+}
+
+// OnChange 注册健康状态变化回调
+func (h *HealthChecker) OnChange(cb HealthCallback) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.callbacks = append(h.callbacks, cb)
+}
+
+// Healthy 目标当前是否健康, 未知目标返回 false
+func (h *HealthChecker) Healthy(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, t := range h.targets {
+		if t.name == name {
+			return t.healthy
+		}
+	}
+	return false
+}
+
+/*
+Start
+
+	@Description: 启动后台检查, 重复调用无效
+	@param ctx: 上下文, 取消后检查停止
+*/
+func (h *HealthChecker) Start(ctx context.Context) {
+	h.mu.Lock()
+	if h.cancel != nil {
+		h.mu.Unlock()
+		return
+	}
+	ctx, h.cancel = context.WithCancel(ctx)
+	h.done = make(chan struct{})
+	done := h.done
+	h.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(h.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				h.CheckOnce(ctx)
+			}
+		}
+	}()
+}
+
+// Stop 停止后台检查并等待退出
+func (h *HealthChecker) Stop() {
+	h.mu.Lock()
+	cancel, done := h.cancel, h.done
+	h.cancel, h.done = nil, nil
+	h.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+/*
+CheckOnce
+
+	@Description: 对所有目标执行一次检查, 并触发状态变化回调
+	@param ctx: 上下文
+*/
+func (h *HealthChecker) CheckOnce(ctx context.Context) {
+	h.mu.Lock()
+	targets := append([]*healthTarget(nil), h.targets...)
+	h.mu.Unlock()
+
+	for _, t := range targets {
+		pingCtx, cancel := context.WithTimeout(ctx, h.opts.Timeout)
+		err := t.db.SQLDB().PingContext(pingCtx)
+		cancel()
+
+		if event, changed := h.record(t, err); changed {
+			if t.onChange != nil {
+				t.onChange(event.Healthy)
+			}
+			h.mu.Lock()
+			callbacks := append([]HealthCallback(nil), h.callbacks...)
+			h.mu.Unlock()
+			for _, cb := range callbacks {
+				cb(event)
+			}
+		}
+	}
+}
+
+// record 记录一次检查结果, 返回状态是否发生变化
+func (h *HealthChecker) record(t *healthTarget, err error) (HealthEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil {
+		t.fails++
+		t.successes = 0
+	} else {
+		t.successes++
+		t.fails = 0
+	}
+
+	changed := false
+	switch {
+	case t.healthy && t.fails >= h.opts.FailureThreshold:
+		t.healthy, changed = false, true
+	case !t.healthy && t.successes >= h.opts.RecoverThreshold:
+		t.healthy, changed = true, true
+	}
+	return HealthEvent{Name: t.name, DB: t.db, Healthy: t.healthy, Err: err, At: time.Now()}, changed
+}
+
+// eject 从读轮询中摘除从库
+func (c *Cluster) eject(r *DB) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	active := make([]*DB, 0, len(c.active))
+	for _, a := range c.active {
+		if a != r {
+			active = append(active, a)
+		}
+	}
+	c.active = active
+}
+
+// restore 从库恢复后重新加入读轮询, 保持配置中的顺序
+func (c *Cluster) restore(r *DB) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	in := make(map[*DB]bool, len(c.active)+1)
+	for _, a := range c.active {
+		in[a] = true
+	}
+	in[r] = true
+	active := make([]*DB, 0, len(in))
+	for _, replica := range c.replicas {
+		if in[replica] {
+			active = append(active, replica)
+		}
+	}
+	c.active = active
+}
@@ -0,0 +1,152 @@
+package Base_PKG
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"gorm.io/driver/mysql"
+	"sync"
+	"testing"
+)
+
+// fakeDriver 按需失败的测试驱动, dsn 作为节点名称
+type fakeDriver struct {
+	mu   sync.Mutex
+	down map[string]bool
+}
+
+var testDriver = &fakeDriver{down: make(map[string]bool)}
+
+func init() {
+	sql.Register("basepkg_fake", testDriver)
+}
+
+func (d *fakeDriver) setDown(name string, down bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.down[name] = down
+}
+
+func (d *fakeDriver) isDown(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.down[name]
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	if d.isDown(name) {
+		return nil, errors.New("fake driver: " + name + " down")
+	}
+	return &fakeConn{name: name}, nil
+}
+
+type fakeConn struct {
+	name string
+}
+
+func (c *fakeConn) Ping(context.Context) error {
+	if testDriver.isDown(c.name) {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fake driver: prepare not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fake driver: begin not supported")
+}
+
+func openFakeDB(t *testing.T, name string) *DB {
+	sqlDB, err := sql.Open("basepkg_fake", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenDB(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// healthCluster 为每个用例创建独立节点的集群和检查器, 节点名带用例名前缀互不影响
+func healthCluster(t *testing.T) (*Cluster, *HealthChecker, *[]HealthEvent, func(string) string) {
+	t.Helper()
+	node := func(name string) string {
+		return t.Name() + "/" + name
+	}
+	primary := openFakeDB(t, node("primary"))
+	replicas := []*DB{openFakeDB(t, node("replica-a")), openFakeDB(t, node("replica-b"))}
+	c, err := NewCluster("test", primary, replicas, PolicyRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, name := range []string{"primary", "replica-a", "replica-b"} {
+			testDriver.setDown(node(name), false)
+		}
+		_ = c.Close()
+	})
+
+	h := NewHealthChecker(HealthOptions{FailureThreshold: 2})
+	h.WatchCluster(c)
+	events := new([]HealthEvent)
+	h.OnChange(func(event HealthEvent) {
+		*events = append(*events, event)
+	})
+	return c, h, events, node
+}
+
+func TestHealthChecker_WatchCluster(t *testing.T) {
+	tests := []struct {
+		name string
+		// 每轮检查前各节点的状态
+		rounds      []map[string]bool
+		wantActive  int
+		wantEvents  int
+		wantHealthy bool
+	}{
+		{name: "全部健康", rounds: []map[string]bool{{}}, wantActive: 2, wantEvents: 0, wantHealthy: true},
+		{name: "从库首次失败未达阈值", rounds: []map[string]bool{{"replica-a": true}}, wantActive: 2, wantEvents: 0, wantHealthy: true},
+		{
+			name:       "从库连续失败被摘除",
+			rounds:     []map[string]bool{{"replica-a": true}, {"replica-a": true}},
+			wantActive: 1, wantEvents: 1, wantHealthy: false,
+		},
+		{
+			name:       "从库恢复重新加入",
+			rounds:     []map[string]bool{{"replica-a": true}, {"replica-a": true}, {"replica-a": false}},
+			wantActive: 2, wantEvents: 2, wantHealthy: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, h, events, node := healthCluster(t)
+			for _, round := range tt.rounds {
+				for name, down := range round {
+					testDriver.setDown(node(name), down)
+				}
+				h.CheckOnce(context.Background())
+			}
+			if got := len(c.readable()); got != tt.wantActive {
+				t.Errorf("active replicas = %d, want %d", got, tt.wantActive)
+			}
+			if len(*events) != tt.wantEvents {
+				t.Errorf("events = %d, want %d", len(*events), tt.wantEvents)
+			}
+			if got := h.Healthy("test/replica-0"); got != tt.wantHealthy {
+				t.Errorf("Healthy(test/replica-0) = %v, want %v", got, tt.wantHealthy)
+			}
+			if !h.Healthy("test/primary") {
+				t.Errorf("primary should stay healthy")
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package Base_PKG
+
+import (
+	"github.com/odinfor/Base-PKG/internal/sqlitemem"
+	"testing"
+)
+
+// openSQLiteDB 使用 OpenDB 打开 sqlite 内存数据库并迁移 models, 连接数固定为 1
+func openSQLiteDB(t *testing.T, opts *DBOptions, models ...interface{}) *DB {
+	t.Helper()
+	var o DBOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.MaxOpenConns, o.MaxIdleConns = 1, 1
+	db, err := OpenDB(sqlitemem.Open("basepkg"), &o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if len(models) > 0 {
+		if err = db.Conn().AutoMigrate(models...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// testItem 通用的测试模型
+type testItem struct {
+	ID   uint
+	Name string
+}
@@ -0,0 +1,27 @@
+package sqlitemem
+
+import (
+	"fmt"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"sync/atomic"
+)
+
+/*
+	测试使用的 sqlite 内存数据库, 包内测试和 dbtest 共用
+*/
+
+// 内存数据库序号, 保证每次打开的都是独立的数据库
+var seq atomic.Int64
+
+/*
+Open
+
+	@Description: 打开独立的 sqlite 内存数据库. 内存数据库在最后一个连接关闭后销毁,
+	调用方需要将连接池固定为 1 个常驻连接
+	@param prefix: 数据库名前缀
+	@return gorm.Dialector
+*/
+func Open(prefix string) gorm.Dialector {
+	return sqlite.Open(fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", prefix, seq.Add(1)))
+}
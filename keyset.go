@@ -0,0 +1,249 @@
+package Base_PKG
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+/*
+	游标(keyset)分页, 基于排序列的值定位下一页, 避免大偏移量扫描
+*/
+
+var ErrInvalidCursor = errors.New("无效的分页游标")
+
+// SortField 排序列
+type SortField struct {
+	// 列名, 可带表名前缀
+	Column string
+
+	// 是否倒序
+	Desc bool
+}
+
+// KeysetQuery
+// @Description: 游标分页参数, After 和 Before 最多指定一个
+type KeysetQuery struct {
+	// 排序列, 最后一列必须唯一(如主键)作为区分相同值的依据.
+	// 排序列不能为 NULL, 定位条件 col > ? 会跳过 NULL 行, 指针和 sql.NullXxx 类型的字段直接拒绝
+	Sort []SortField
+
+	// 每页数量, 小于等于 0 时为 20
+	Limit int
+
+	// 向后翻页, 取该游标之后的记录
+	After string
+
+	// 向前翻页, 取该游标之前的记录
+	Before string
+}
+
+// KeysetPage
+// @Description: 游标分页结果
+type KeysetPage[T any] struct {
+	Items []T `json:"items"`
+
+	// 下一页游标, 没有下一页时为空
+	Next string `json:"next"`
+
+	// 上一页游标, 没有上一页时为空
+	Prev string `json:"prev"`
+}
+
+// cursor 游标内容, 记录排序列及其取值
+type cursor struct {
+	Columns []string          `json:"c"`
+	Values  []json.RawMessage `json:"v"`
+}
+
+/*
+KeysetPaginate
+
+	@Description: 游标分页查询
+	@param db: gorm 对象, 为 nil 时使用 GetDBConn
+	@param q: 分页参数
+	@param scopes: 查询条件, 不要在 scope 中指定排序
+	@return *KeysetPage[T]
+	@return error
+*/
+func KeysetPaginate[T any](ctx context.Context, db *gorm.DB, q KeysetQuery, scopes ...Scope) (*KeysetPage[T], error) {
+	if len(q.Sort) == 0 {
+		return nil, errors.New("keyset 分页必须指定排序列")
+	}
+	if q.After != "" && q.Before != "" {
+		return nil, errors.New("After 和 Before 不能同时指定")
+	}
+	if q.Limit <= 0 {
+		q.Limit = 20
+	}
+	if db == nil {
+		db = GetDBConn()
+	}
+	db = db.WithContext(ctx)
+
+	fields, err := sortFields[T](db, q.Sort)
+	if err != nil {
+		return nil, err
+	}
+	// NULL 不满足 > 和 = 条件, 可为 NULL 的排序列会跳过记录
+	for i, f := range fields {
+		if nullableField(f) {
+			return nil, fmt.Errorf("排序列 %s 可为 NULL, 不能用于 keyset 分页", q.Sort[i].Column)
+		}
+	}
+
+	backward := q.Before != ""
+	tx := db.Model(new(T)).Scopes(scopes...)
+	if token := q.After + q.Before; token != "" {
+		values, err := decodeCursor(token, q.Sort, fields)
+		if err != nil {
+			return nil, err
+		}
+		tx = tx.Where(keysetCondition(q.Sort, values, backward))
+	}
+	for _, s := range q.Sort {
+		// 向前翻页时反向排序, 取出后再翻转
+		tx = tx.Order(clause.OrderByColumn{Column: clause.Column{Name: s.Column}, Desc: s.Desc != backward})
+	}
+
+	items := make([]T, 0, q.Limit+1)
+	if err = tx.Limit(q.Limit + 1).Find(&items).Error; err != nil {
+		return nil, TranslateError(err)
+	}
+	more := len(items) > q.Limit
+	if more {
+		items = items[:q.Limit]
+	}
+	if backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	page := &KeysetPage[T]{Items: items}
+	if len(items) == 0 {
+		return page, nil
+	}
+	hasNext, hasPrev := more, q.After != ""
+	if backward {
+		hasNext, hasPrev = true, more
+	}
+	if hasNext {
+		if page.Next, err = encodeCursor(ctx, q.Sort, fields, &items[len(items)-1]); err != nil {
+			return nil, err
+		}
+	}
+	if hasPrev {
+		if page.Prev, err = encodeCursor(ctx, q.Sort, fields, &items[0]); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// sortFields 查找排序列对应的模型字段
+func sortFields[T any](db *gorm.DB, sort []SortField) ([]*schema.Field, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(new(T)); err != nil {
+		return nil, err
+	}
+	fields := make([]*schema.Field, 0, len(sort))
+	for _, s := range sort {
+		name := s.Column
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			name = name[i+1:]
+		}
+		f := stmt.Schema.LookUpField(name)
+		if f == nil {
+			return nil, fmt.Errorf("排序列 %s 不存在于模型 %s", s.Column, stmt.Schema.Name)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// nullableField 字段类型是否可以表示 NULL: 指针, 或带 Valid 标记的结构体(sql.NullXxx, gorm.DeletedAt 等)
+func nullableField(f *schema.Field) bool {
+	t := f.FieldType
+	if t.Kind() == reflect.Ptr {
+		return true
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	valid, ok := t.FieldByName("Valid")
+	return ok && valid.Type.Kind() == reflect.Bool
+}
+
+// keysetCondition 构造 (c1 > v1) OR (c1 = v1 AND c2 > v2) ... 形式的定位条件
+func keysetCondition(sort []SortField, values []interface{}, backward bool) clause.Expression {
+	ors := make([]clause.Expression, 0, len(sort))
+	for i, s := range sort {
+		ands := make([]clause.Expression, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, clause.Eq{Column: clause.Column{Name: sort[j].Column}, Value: values[j]})
+		}
+		col := clause.Column{Name: s.Column}
+		if s.Desc != backward {
+			ands = append(ands, clause.Lt{Column: col, Value: values[i]})
+		} else {
+			ands = append(ands, clause.Gt{Column: col, Value: values[i]})
+		}
+		ors = append(ors, clause.And(ands...))
+	}
+	return clause.Or(ors...)
+}
+
+// encodeCursor 将记录的排序列取值编码为游标
+func encodeCursor(ctx context.Context, sort []SortField, fields []*schema.Field, item interface{}) (string, error) {
+	c := cursor{Columns: make([]string, len(sort)), Values: make([]json.RawMessage, len(sort))}
+	rv := reflect.ValueOf(item).Elem()
+	for i, f := range fields {
+		v, _ := f.ValueOf(ctx, rv)
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		c.Columns[i], c.Values[i] = sort[i].Column, b
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeCursor 解码游标, 按字段类型还原取值, 排序列不一致时返回 ErrInvalidCursor
+func decodeCursor(token string, sort []SortField, fields []*schema.Field) ([]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	var c cursor
+	if err = json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	if len(c.Columns) != len(sort) || len(c.Values) != len(sort) {
+		return nil, ErrInvalidCursor
+	}
+
+	values := make([]interface{}, len(sort))
+	for i, f := range fields {
+		if c.Columns[i] != sort[i].Column {
+			return nil, ErrInvalidCursor
+		}
+		v := reflect.New(f.FieldType)
+		if err = json.Unmarshal(c.Values[i], v.Interface()); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+		}
+		values[i] = v.Elem().Interface()
+	}
+	return values, nil
+}
@@ -0,0 +1,192 @@
+package Base_PKG
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type keysetOrder struct {
+	ID        uint64
+	Amount    int
+	CreatedAt time.Time
+}
+
+func dryRunDB(t *testing.T) *gorm.DB {
+	return openFakeDB(t, "dry-run").Conn().Session(&gorm.Session{DryRun: true})
+}
+
+func Test_cursor_roundTrip(t *testing.T) {
+	db := dryRunDB(t)
+	sort := []SortField{{Column: "created_at", Desc: true}, {Column: "id"}}
+	fields, err := sortFields[keysetOrder](db, sort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := keysetOrder{ID: 1 << 60, CreatedAt: time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)}
+	token, err := encodeCursor(context.Background(), sort, fields, &item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		sort    []SortField
+		wantErr bool
+	}{
+		{name: "正常解码", token: token, sort: sort},
+		{name: "排序列不一致", token: token, sort: []SortField{{Column: "amount"}, {Column: "id"}}, wantErr: true},
+		{name: "非法游标", token: "not-a-cursor", sort: sort, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, err := sortFields[keysetOrder](db, tt.sort)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values, err := decodeCursor(tt.token, tt.sort, fs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeCursor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := values[0].(time.Time); !got.Equal(item.CreatedAt) {
+				t.Errorf("created_at = %v, want %v", got, item.CreatedAt)
+			}
+			if got := values[1].(uint64); got != item.ID {
+				t.Errorf("id = %v, want %v", got, item.ID)
+			}
+		})
+	}
+}
+
+func Test_keysetCondition(t *testing.T) {
+	db := dryRunDB(t)
+	sort := []SortField{{Column: "created_at", Desc: true}, {Column: "id"}}
+	values := []interface{}{"2024-05-01", 10}
+
+	tests := []struct {
+		name     string
+		backward bool
+		want     string
+	}{
+		{
+			name: "向后翻页",
+			want: "SELECT * FROM `keyset_order` WHERE (`created_at` < ? OR (`created_at` = ? AND `id` > ?))",
+		},
+		{
+			name:     "向前翻页",
+			backward: true,
+			want:     "SELECT * FROM `keyset_order` WHERE (`created_at` > ? OR (`created_at` = ? AND `id` < ?))",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var items []keysetOrder
+			stmt := db.Where(keysetCondition(sort, values, tt.backward)).Find(&items).Statement
+			if got := stmt.SQL.String(); got != tt.want {
+				t.Errorf("sql = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+type keysetNullable struct {
+	ID        uint64
+	DeletedAt gorm.DeletedAt
+	Score     *int
+}
+
+func TestKeysetPaginate_nullable(t *testing.T) {
+	db := dryRunDB(t)
+	for _, column := range []string{"deleted_at", "score"} {
+		_, err := KeysetPaginate[keysetNullable](context.Background(), db, KeysetQuery{Sort: []SortField{{Column: column}, {Column: "id"}}, Limit: 10})
+		if err == nil || !strings.Contains(err.Error(), "NULL") {
+			t.Errorf("KeysetPaginate(%s) error = %v, want nullable column rejected", column, err)
+		}
+	}
+	if _, err := sortFields[keysetNullable](db, []SortField{{Column: "score"}, {Column: "id"}}); err != nil {
+		t.Errorf("sortFields(score) error = %v", err)
+	}
+}
+
+// keysetIDs 取出一页记录的 id
+func keysetIDs(page *KeysetPage[keysetOrder]) []uint64 {
+	ids := make([]uint64, 0, len(page.Items))
+	for _, item := range page.Items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestKeysetPaginate(t *testing.T) {
+	db := openSQLiteDB(t, nil, &keysetOrder{}).Conn()
+	// amount 存在相同值, 由 id 区分先后
+	amounts := []int{50, 30, 50, 10, 30, 50, 20}
+	for i, amount := range amounts {
+		if err := db.Create(&keysetOrder{ID: uint64(i + 1), Amount: amount}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	sort := []SortField{{Column: "amount", Desc: true}, {Column: "id"}}
+	wantPages := [][]uint64{{1, 3, 6}, {2, 5, 7}, {4}}
+
+	// 向后翻到最后一页
+	var pages []*KeysetPage[keysetOrder]
+	after := ""
+	for i := range wantPages {
+		page, err := KeysetPaginate[keysetOrder](ctx, db, KeysetQuery{Sort: sort, Limit: 3, After: after})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := keysetIDs(page); !reflect.DeepEqual(got, wantPages[i]) {
+			t.Fatalf("forward page %d = %v, want %v", i, got, wantPages[i])
+		}
+		if (page.Prev == "") != (i == 0) {
+			t.Errorf("forward page %d prev = %q", i, page.Prev)
+		}
+		if (page.Next == "") != (i == len(wantPages)-1) {
+			t.Errorf("forward page %d next = %q", i, page.Next)
+		}
+		pages = append(pages, page)
+		after = page.Next
+	}
+
+	// 从最后一页向前翻回第一页, 结果按原顺序返回
+	before := pages[len(pages)-1].Prev
+	for i := len(wantPages) - 2; i >= 0; i-- {
+		page, err := KeysetPaginate[keysetOrder](ctx, db, KeysetQuery{Sort: sort, Limit: 3, Before: before})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := keysetIDs(page); !reflect.DeepEqual(got, wantPages[i]) {
+			t.Fatalf("backward page %d = %v, want %v", i, got, wantPages[i])
+		}
+		if (page.Prev == "") != (i == 0) {
+			t.Errorf("backward page %d prev = %q", i, page.Prev)
+		}
+		if page.Next == "" {
+			t.Errorf("backward page %d next is empty", i)
+		}
+		before = page.Prev
+	}
+
+	// 向前翻页得到的 Next 与向后翻页一致
+	page, err := KeysetPaginate[keysetOrder](ctx, db, KeysetQuery{Sort: sort, Limit: 3, Before: pages[1].Prev})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := KeysetPaginate[keysetOrder](ctx, db, KeysetQuery{Sort: sort, Limit: 3, After: page.Next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := keysetIDs(next); !reflect.DeepEqual(got, wantPages[1]) {
+		t.Errorf("page after backward page = %v, want %v", got, wantPages[1])
+	}
+}
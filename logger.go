@@ -0,0 +1,223 @@
+package Base_PKG
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+	"sync"
+	"time"
+)
+
+/*
+	基于 zap 的 gorm 日志, 支持慢查询阈值、按级别采样和参数脱敏
+*/
+
+// RedactMode 参数脱敏方式
+type RedactMode int
+
+const (
+	// RedactPlaceholder 参数替换为 ? 占位符
+	RedactPlaceholder RedactMode = iota
+
+	// RedactHash 参数替换为哈希值, 相同参数哈希相同, 便于排查
+	RedactHash
+
+	// RedactNone 不脱敏, 仅用于开发环境
+	RedactNone
+)
+
+// SamplingRule
+// @Description: 采样规则, 每个周期内前 First 条全部输出, 之后每 Thereafter 条输出一条
+type SamplingRule struct {
+	// 统计周期, 默认 1s
+	Tick time.Duration
+
+	First int
+
+	// 为 0 时丢弃超出 First 的日志
+	Thereafter int
+}
+
+// LoggerOptions
+// @Description: gorm 日志配置
+type LoggerOptions struct {
+	// zap 日志对象, 为空时使用 zap.L()
+	Logger *zap.Logger
+
+	// 日志级别, 默认 logger.Warn
+	Level logger.LogLevel
+
+	// 慢查询阈值, 默认 200ms
+	SlowThreshold time.Duration
+
+	// 只输出超过慢查询阈值的语句, 错误日志不受影响
+	SlowOnly bool
+
+	// 参数脱敏方式
+	Redact RedactMode
+
+	// RedactHash 模式下的哈希盐值
+	HashSalt string
+
+	// 忽略记录不存在的错误
+	IgnoreRecordNotFound bool
+
+	// 按 gorm 日志级别采样, 未配置的级别不采样
+	Sampling map[logger.LogLevel]SamplingRule
+}
+
+type zapLogger struct {
+	opts     LoggerOptions
+	zl       *zap.Logger
+	samplers map[logger.LogLevel]*sampler
+}
+
+/*
+NewZapLogger
+
+	@Description: 创建 gorm 日志, 通过 DBOptions.Logger 使用
+	@param opts: 日志配置
+	@return logger.Interface
+*/
+func NewZapLogger(opts LoggerOptions) logger.Interface {
+	if opts.Level == 0 {
+		opts.Level = logger.Warn
+	}
+	if opts.SlowThreshold <= 0 {
+		opts.SlowThreshold = 200 * time.Millisecond
+	}
+	l := &zapLogger{opts: opts, zl: opts.Logger, samplers: make(map[logger.LogLevel]*sampler)}
+	for level, rule := range opts.Sampling {
+		l.samplers[level] = newSampler(rule)
+	}
+	return l
+}
+
+func (l *zapLogger) logger() *zap.Logger {
+	if l.zl != nil {
+		return l.zl
+	}
+	return zap.L()
+}
+
+// LogMode 修改日志级别
+func (l *zapLogger) LogMode(level logger.LogLevel) logger.Interface {
+	nl := *l
+	nl.opts.Level = level
+	return &nl
+}
+
+func (l *zapLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.opts.Level >= logger.Info && l.sample(logger.Info) {
+		l.logger().Info(fmt.Sprintf(msg, data...), zap.String("caller", utils.FileWithLineNum()))
+	}
+}
+
+func (l *zapLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.opts.Level >= logger.Warn && l.sample(logger.Warn) {
+		l.logger().Warn(fmt.Sprintf(msg, data...), zap.String("caller", utils.FileWithLineNum()))
+	}
+}
+
+func (l *zapLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.opts.Level >= logger.Error && l.sample(logger.Error) {
+		l.logger().Error(fmt.Sprintf(msg, data...), zap.String("caller", utils.FileWithLineNum()))
+	}
+}
+
+// Trace 输出 sql 执行日志
+func (l *zapLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.opts.Level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	// 在 Trace 中获取调用位置, 闭包中获取会多一层栈帧, 得到的是本文件
+	caller := utils.FileWithLineNum()
+	fields := func() []zap.Field {
+		sql, rows := fc()
+		return []zap.Field{
+			zap.String("sql", sql),
+			zap.Int64("rows", rows),
+			zap.Duration("elapsed", elapsed),
+			zap.String("caller", caller),
+		}
+	}
+
+	switch {
+	case err != nil && l.opts.Level >= logger.Error && !(l.opts.IgnoreRecordNotFound && errors.Is(err, gorm.ErrRecordNotFound)):
+		if l.sample(logger.Error) {
+			l.logger().Error("sql error", append(fields(), zap.Error(err))...)
+		}
+	case elapsed > l.opts.SlowThreshold && l.opts.Level >= logger.Warn:
+		if l.sample(logger.Warn) {
+			l.logger().Warn("slow sql", append(fields(), zap.Duration("threshold", l.opts.SlowThreshold))...)
+		}
+	case l.opts.Level >= logger.Info && !l.opts.SlowOnly:
+		if l.sample(logger.Info) {
+			l.logger().Info("sql", fields()...)
+		}
+	}
+}
+
+// ParamsFilter 输出日志前对 sql 参数脱敏, 实现 gorm.ParamsFilter
+func (l *zapLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	switch l.opts.Redact {
+	case RedactNone:
+		return sql, params
+	case RedactHash:
+		res := make([]interface{}, len(params))
+		for i, p := range params {
+			if p == nil {
+				continue
+			}
+			sum := sha256.Sum256([]byte(l.opts.HashSalt + fmt.Sprintf("%v", p)))
+			res[i] = "sha256:" + hex.EncodeToString(sum[:8])
+		}
+		return sql, res
+	default:
+		return sql, nil
+	}
+}
+
+func (l *zapLogger) sample(level logger.LogLevel) bool {
+	if s, ok := l.samplers[level]; ok {
+		return s.allow()
+	}
+	return true
+}
+
+type sampler struct {
+	rule SamplingRule
+
+	mu     sync.Mutex
+	window time.Time
+	count  int
+}
+
+func newSampler(rule SamplingRule) *sampler {
+	if rule.Tick <= 0 {
+		rule.Tick = time.Second
+	}
+	return &sampler{rule: rule}
+}
+
+// allow 本条日志是否输出
+func (s *sampler) allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.window) >= s.rule.Tick {
+		s.window, s.count = now, 0
+	}
+	s.count++
+	if s.count <= s.rule.First {
+		return true
+	}
+	return s.rule.Thereafter > 0 && (s.count-s.rule.First)%s.rule.Thereafter == 0
+}
@@ -0,0 +1,140 @@
+package Base_PKG
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"gorm.io/gorm/logger"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestZapLogger_Trace(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       LoggerOptions
+		query      func(d *DB) error
+		wantLevel  zapcore.Level
+		wantSQL    string
+		notWantSQL string
+	}{
+		{
+			name:      "参数替换为占位符",
+			opts:      LoggerOptions{Level: logger.Info},
+			query:     func(d *DB) error { return d.Conn().Where("name = ?", "secret").Find(&[]testItem{}).Error },
+			wantLevel: zapcore.InfoLevel, wantSQL: "name = ?", notWantSQL: "secret",
+		},
+		{
+			name:      "参数替换为哈希",
+			opts:      LoggerOptions{Level: logger.Info, Redact: RedactHash, HashSalt: "salt"},
+			query:     func(d *DB) error { return d.Conn().Where("name = ?", "secret").Find(&[]testItem{}).Error },
+			wantLevel: zapcore.InfoLevel, wantSQL: "sha256:", notWantSQL: "secret",
+		},
+		{
+			name:      "不脱敏",
+			opts:      LoggerOptions{Level: logger.Info, Redact: RedactNone},
+			query:     func(d *DB) error { return d.Conn().Where("name = ?", "secret").Find(&[]testItem{}).Error },
+			wantLevel: zapcore.InfoLevel, wantSQL: "secret",
+		},
+		{
+			name:      "错误日志",
+			opts:      LoggerOptions{},
+			query:     func(d *DB) error { return d.Conn().Exec("SELECT * FROM missing").Error },
+			wantLevel: zapcore.ErrorLevel, wantSQL: "missing",
+		},
+		{
+			name:      "慢查询",
+			opts:      LoggerOptions{SlowThreshold: time.Nanosecond},
+			query:     func(d *DB) error { return d.Conn().Find(&[]testItem{}).Error },
+			wantLevel: zapcore.WarnLevel, wantSQL: "test_item",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := openSQLiteDB(t, nil, &testItem{})
+			core, logs := observer.New(zapcore.DebugLevel)
+			tt.opts.Logger = zap.New(core)
+			d.Conn().Logger = NewZapLogger(tt.opts)
+
+			_ = tt.query(d)
+			entries := logs.All()
+			if len(entries) != 1 {
+				t.Fatalf("entries = %d, want 1", len(entries))
+			}
+			e := entries[0]
+			fields := e.ContextMap()
+			sql, _ := fields["sql"].(string)
+			if e.Level != tt.wantLevel || !strings.Contains(sql, tt.wantSQL) {
+				t.Errorf("entry = %v %q, want %v containing %q", e.Level, sql, tt.wantLevel, tt.wantSQL)
+			}
+			if tt.notWantSQL != "" && strings.Contains(sql, tt.notWantSQL) {
+				t.Errorf("sql not redacted: %s", sql)
+			}
+			// 调用位置为业务代码而不是日志实现
+			if caller, _ := fields["caller"].(string); !strings.Contains(caller, "logger_test.go") {
+				t.Errorf("caller = %s, want logger_test.go", caller)
+			}
+		})
+	}
+}
+
+func TestZapLogger_IgnoreRecordNotFound(t *testing.T) {
+	d := openSQLiteDB(t, nil, &testItem{})
+	core, logs := observer.New(zapcore.DebugLevel)
+	d.Conn().Logger = NewZapLogger(LoggerOptions{Logger: zap.New(core), IgnoreRecordNotFound: true})
+
+	err := d.Conn().First(&testItem{}).Error
+	if err == nil || logs.Len() != 0 {
+		t.Errorf("First() error = %v, logs = %d", err, logs.Len())
+	}
+}
+
+func TestSampler(t *testing.T) {
+	tests := []struct {
+		name string
+		rule SamplingRule
+		want []bool
+	}{
+		{name: "前 2 条之后每 3 条输出一条", rule: SamplingRule{Tick: time.Hour, First: 2, Thereafter: 3}, want: []bool{true, true, false, false, true, false, false, true}},
+		{name: "丢弃超出的日志", rule: SamplingRule{Tick: time.Hour, First: 1}, want: []bool{true, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSampler(tt.rule)
+			for i, want := range tt.want {
+				if got := s.allow(); got != want {
+					t.Errorf("allow() #%d = %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("周期结束后重新计数", func(t *testing.T) {
+		s := newSampler(SamplingRule{Tick: 10 * time.Millisecond, First: 1})
+		if !s.allow() || s.allow() {
+			t.Fatal("first window")
+		}
+		time.Sleep(20 * time.Millisecond)
+		if !s.allow() {
+			t.Errorf("allow() after tick = false")
+		}
+	})
+}
+
+func TestZapLogger_Sampling(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := NewZapLogger(LoggerOptions{
+		Logger:   zap.New(core),
+		Sampling: map[logger.LogLevel]SamplingRule{logger.Error: {Tick: time.Hour, First: 2}},
+	})
+	fc := func() (string, int64) { return "SELECT 1", 0 }
+	for i := 0; i < 5; i++ {
+		l.Trace(context.Background(), time.Now(), fc, errors.New("boom"))
+	}
+	if logs.Len() != 2 {
+		t.Errorf("logged %d errors, want 2", logs.Len())
+	}
+}
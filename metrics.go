@@ -0,0 +1,354 @@
+package Base_PKG
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	连接池和查询指标, 以 Prometheus 文本格式输出
+*/
+
+const (
+	RolePrimary = "primary"
+	RoleReplica = "replica"
+
+	metricsStartKey = "base_pkg:metrics_start"
+)
+
+// ErrMetricsRegistered 同一个 gorm 对象只能注册一个指标收集器, 重复注册会覆盖之前的回调
+var ErrMetricsRegistered = errors.New("gorm 对象已注册指标回调")
+
+// MetricsOptions
+// @Description: 指标配置
+type MetricsOptions struct {
+	// 指标名前缀, 默认 mysql
+	Namespace string
+
+	// 连接池采样间隔, 默认 15s
+	Interval time.Duration
+
+	// 查询耗时直方图的桶(秒), 默认 1ms ~ 10s
+	Buckets []float64
+}
+
+type poolTarget struct {
+	name  string
+	role  string
+	db    *DB
+	stats sql.DBStats
+}
+
+type opKey struct {
+	db   string
+	role string
+	op   string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+/*
+Metrics
+
+	@Description: 数据库指标收集器, 实现 http.Handler
+*/
+type Metrics struct {
+	opts MetricsOptions
+
+	mu         sync.Mutex
+	pools      []*poolTarget
+	histograms map[opKey]*histogram
+	errors     map[opKey]uint64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+/*
+NewMetrics
+
+	@Description: 创建指标收集器, 调用 Start 后开始采样连接池
+	@param opts: 指标配置
+	@return *Metrics
+*/
+func NewMetrics(opts MetricsOptions) *Metrics {
+	if opts.Namespace == "" {
+		opts.Namespace = "mysql"
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 15 * time.Second
+	}
+	if len(opts.Buckets) == 0 {
+		opts.Buckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	}
+	sort.Float64s(opts.Buckets)
+	return &Metrics{
+		opts:       opts,
+		histograms: make(map[opKey]*histogram),
+		errors:     make(map[opKey]uint64),
+	}
+}
+
+/*
+Register
+
+	@Description: 采集单个数据库的连接池指标, 并注册查询耗时回调
+	@param name: 数据库名称, 作为 db 标签
+	@param role: 角色, RolePrimary 或 RoleReplica
+	@param db: 数据库连接
+	@return error: db 已注册过指标回调时返回 ErrMetricsRegistered
+*/
+func (m *Metrics) Register(name string, role string, db *DB) error {
+	if err := m.registerCallbacks(db.Conn(), name, func(*gorm.DB) string { return role }); err != nil {
+		return err
+	}
+	m.addPool(name, role, db)
+	return nil
+}
+
+/*
+RegisterCluster
+
+	@Description: 采集集群主从库的连接池指标, 查询按实际执行的节点区分角色
+	@param c: 数据库集群
+	@return error: 主库已注册过指标回调时返回 ErrMetricsRegistered
+*/
+func (m *Metrics) RegisterCluster(c *Cluster) error {
+	replicas := make(map[gorm.ConnPool]bool, len(c.replicas))
+	for _, r := range c.replicas {
+		replicas[r.SQLDB()] = true
+	}
+	if err := m.registerCallbacks(c.primary.Conn(), c.name, func(db *gorm.DB) string {
+		if replicas[db.Statement.ConnPool] {
+			return RoleReplica
+		}
+		return RolePrimary
+	}); err != nil {
+		return err
+	}
+	m.addPool(c.name, RolePrimary, c.primary)
+	for _, r := range c.replicas {
+		m.addPool(c.name, RoleReplica, r)
+	}
+	return nil
+}
+
+func (m *Metrics) addPool(name string, role string, db *DB) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pools = append(m.pools, &poolTarget{name: name, role: role, db: db, stats: db.SQLDB().Stats()})
+}
+
+func (m *Metrics) registerCallbacks(gdb *gorm.DB, name string, roleOf func(db *gorm.DB) string) error {
+	before := func(db *gorm.DB) {
+		db.InstanceSet(metricsStartKey, time.Now())
+	}
+	after := func(op string) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			v, ok := db.InstanceGet(metricsStartKey)
+			if !ok {
+				return
+			}
+			failed := db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound)
+			m.observe(opKey{db: name, role: roleOf(db), op: op}, time.Since(v.(time.Time)), failed)
+		}
+	}
+
+	cb := gdb.Callback()
+	if cb.Query().Get("base_pkg:metrics_after") != nil {
+		return ErrMetricsRegistered
+	}
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("base_pkg:metrics_before", before),
+		cb.Create().After("gorm:create").Register("base_pkg:metrics_after", after("create")),
+		cb.Query().Before("gorm:query").Register("base_pkg:metrics_before", before),
+		cb.Query().After("gorm:query").Register("base_pkg:metrics_after", after("query")),
+		cb.Update().Before("gorm:update").Register("base_pkg:metrics_before", before),
+		cb.Update().After("gorm:update").Register("base_pkg:metrics_after", after("update")),
+		cb.Delete().Before("gorm:delete").Register("base_pkg:metrics_before", before),
+		cb.Delete().After("gorm:delete").Register("base_pkg:metrics_after", after("delete")),
+		cb.Row().Before("gorm:row").Register("base_pkg:metrics_before", before),
+		cb.Row().After("gorm:row").Register("base_pkg:metrics_after", after("row")),
+		cb.Raw().Before("gorm:raw").Register("base_pkg:metrics_before", before),
+		cb.Raw().After("gorm:raw").Register("base_pkg:metrics_after", after("raw")),
+	} {
+		if err != nil {
+			return fmt.Errorf("register metrics callback error: %w", err)
+		}
+	}
+	return nil
+}
+
+// observe 记录一次操作耗时
+func (m *Metrics) observe(key opKey, elapsed time.Duration, failed bool) {
+	seconds := elapsed.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.histograms[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.opts.Buckets))}
+		m.histograms[key] = h
+	}
+	for i, b := range m.opts.Buckets {
+		if seconds <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+	if failed {
+		m.errors[key]++
+	}
+}
+
+// Sample 立即采样一次连接池状态
+func (m *Metrics) Sample() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.pools {
+		p.stats = p.db.SQLDB().Stats()
+	}
+}
+
+/*
+Start
+
+	@Description: 启动连接池定时采样, 重复调用无效
+	@param ctx: 上下文, 取消后采样停止
+*/
+func (m *Metrics) Start(ctx context.Context) {
+	m.mu.Lock()
+	if m.cancel != nil {
+		m.mu.Unlock()
+		return
+	}
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	done := m.done
+	m.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(m.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.Sample()
+			}
+		}
+	}()
+}
+
+// Stop 停止定时采样并等待退出
+func (m *Metrics) Stop() {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel, m.done = nil, nil
+	m.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// ServeHTTP 以 Prometheus 文本格式输出指标
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.Write(w)
+}
+
+// Handler 指标输出的 http.Handler
+func (m *Metrics) Handler() http.Handler {
+	return m
+}
+
+// Write 以 Prometheus 文本格式写出全部指标
+func (m *Metrics) Write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	ns := m.opts.Namespace
+
+	gauges := []struct {
+		name, typ, help string
+		value           func(s sql.DBStats) float64
+	}{
+		{"pool_max_open_connections", "gauge", "Maximum number of open connections.", func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"pool_open_connections", "gauge", "Number of established connections.", func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"pool_in_use_connections", "gauge", "Number of connections currently in use.", func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"pool_idle_connections", "gauge", "Number of idle connections.", func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"pool_wait_count_total", "counter", "Total number of connections waited for.", func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"pool_wait_duration_seconds_total", "counter", "Total time blocked waiting for a new connection.", func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+	}
+	for _, g := range gauges {
+		name := ns + "_" + g.name
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, g.help, name, g.typ)
+		for _, p := range m.pools {
+			fmt.Fprintf(&b, "%s{db=%s,role=%s} %s\n", name, quoteLabel(p.name), quoteLabel(p.role), formatFloat(g.value(p.stats)))
+		}
+	}
+
+	keys := make([]opKey, 0, len(m.histograms))
+	for k := range m.histograms {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, c := keys[i], keys[j]
+		if a.db != c.db {
+			return a.db < c.db
+		}
+		if a.role != c.role {
+			return a.role < c.role
+		}
+		return a.op < c.op
+	})
+
+	name := ns + "_query_duration_seconds"
+	fmt.Fprintf(&b, "# HELP %s Latency of gorm operations.\n# TYPE %s histogram\n", name, name)
+	for _, k := range keys {
+		h := m.histograms[k]
+		labels := fmt.Sprintf("db=%s,role=%s,operation=%s", quoteLabel(k.db), quoteLabel(k.role), quoteLabel(k.op))
+		for i, bound := range m.opts.Buckets {
+			fmt.Fprintf(&b, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(&b, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+		fmt.Fprintf(&b, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+
+	name = ns + "_query_errors_total"
+	fmt.Fprintf(&b, "# HELP %s Number of failed gorm operations.\n# TYPE %s counter\n", name, name)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s{db=%s,role=%s,operation=%s} %d\n", name, quoteLabel(k.db), quoteLabel(k.role), quoteLabel(k.op), m.errors[k])
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func quoteLabel(v string) string {
+	v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+	return `"` + v + `"`
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,96 @@
+package Base_PKG
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Observe(t *testing.T) {
+	m := NewMetrics(MetricsOptions{Namespace: "test", Buckets: []float64{1, 0.1}})
+	key := opKey{db: "main", role: RolePrimary, op: "query"}
+	m.observe(key, 50*time.Millisecond, false)
+	m.observe(key, 500*time.Millisecond, true)
+	m.observe(key, 2*time.Second, false)
+
+	var b strings.Builder
+	if err := m.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	labels := `db="main",role="primary",operation="query"`
+	for _, want := range []string{
+		`test_query_duration_seconds_bucket{` + labels + `,le="0.1"} 1`,
+		`test_query_duration_seconds_bucket{` + labels + `,le="1"} 2`,
+		`test_query_duration_seconds_bucket{` + labels + `,le="+Inf"} 3`,
+		`test_query_duration_seconds_sum{` + labels + `} 2.55`,
+		`test_query_duration_seconds_count{` + labels + `} 3`,
+		`test_query_errors_total{` + labels + `} 1`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestMetrics_Register(t *testing.T) {
+	d := openSQLiteDB(t, nil, &testItem{})
+	m := NewMetrics(MetricsOptions{})
+	if err := m.Register("main", RolePrimary, d); err != nil {
+		t.Fatal(err)
+	}
+	// 同一个 gorm 对象的第二个收集器不能覆盖第一个的回调
+	if err := NewMetrics(MetricsOptions{}).Register("replica", RoleReplica, d); !errors.Is(err, ErrMetricsRegistered) {
+		t.Errorf("Register() twice error = %v, want ErrMetricsRegistered", err)
+	}
+	d.Conn().Create(&testItem{Name: "a"})
+	d.Conn().Find(&[]testItem{})
+	d.Conn().First(&testItem{}, 9) // 未找到记录不计为错误
+	d.Conn().Exec("SELECT * FROM missing")
+	m.Sample()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %s", ct)
+	}
+	for _, want := range []string{
+		`mysql_pool_max_open_connections{db="main",role="primary"} 1`,
+		`mysql_query_duration_seconds_count{db="main",role="primary",operation="create"} 1`,
+		`mysql_query_duration_seconds_count{db="main",role="primary",operation="query"} 2`,
+		`mysql_query_errors_total{db="main",role="primary",operation="query"} 0`,
+		`mysql_query_errors_total{db="main",role="primary",operation="raw"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("output missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetrics_RegisterCluster(t *testing.T) {
+	c := clusterDB(t, PolicyRoundRobin, "replica")
+	m := NewMetrics(MetricsOptions{})
+	if err := m.RegisterCluster(c); err != nil {
+		t.Fatal(err)
+	}
+	c.DB().Find(&[]registryItem{})
+	c.DB().Create(&registryItem{Source: "a"})
+	c.Primary().Find(&[]registryItem{})
+
+	var b strings.Builder
+	if err := m.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`mysql_pool_open_connections{db="test",role="replica"}`,
+		`mysql_query_duration_seconds_count{db="test",role="replica",operation="query"} 1`,
+		`mysql_query_duration_seconds_count{db="test",role="primary",operation="query"} 1`,
+		`mysql_query_duration_seconds_count{db="test",role="primary",operation="create"} 1`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, b.String())
+		}
+	}
+}
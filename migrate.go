@@ -0,0 +1,524 @@
+package Base_PKG
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+	版本化的数据库迁移, 支持嵌入的 sql 文件和 go 方法,
+	mysql 中通过 GET_LOCK 保证同一时间只有一个实例执行迁移
+*/
+
+var (
+	ErrMigrationDirty    = errors.New("存在未完成的迁移, 需要处理后调用 Force 恢复")
+	ErrChecksumMismatch  = errors.New("已执行迁移的内容被修改")
+	ErrMigrationLocked   = errors.New("获取迁移锁超时")
+	ErrMigrationNotFound = errors.New("迁移版本不存在")
+)
+
+// migrationFileRe 迁移文件名, 如 0001_create_user.up.sql
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration
+// @Description: 单个迁移版本, sql 和 go 方法二选一, go 方法优先
+type Migration struct {
+	Version uint64
+	Name    string
+
+	UpSQL   string
+	DownSQL string
+
+	Up   func(db *gorm.DB) error
+	Down func(db *gorm.DB) error
+}
+
+// checksum 迁移内容的校验和, go 方法迁移只校验版本和名称
+func (m *Migration) checksum() string {
+	h := sha256.New()
+	h.Write([]byte(strconv.FormatUint(m.Version, 10) + "_" + m.Name + "\n"))
+	if m.Up == nil {
+		h.Write([]byte(m.UpSQL))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// schemaMigration 迁移记录表
+type schemaMigration struct {
+	Version   uint64    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"size:255;not null"`
+	Checksum  string    `gorm:"size:64;not null"`
+	Dirty     bool      `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// MigrationStatus
+// @Description: 迁移版本的执行状态
+type MigrationStatus struct {
+	Version   uint64     `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	Dirty     bool       `json:"dirty"`
+	Modified  bool       `json:"modified"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+// MigrateOptions
+// @Description: 迁移配置
+type MigrateOptions struct {
+	// 迁移记录表名, 默认 schema_migrations
+	Table string
+
+	// 迁移锁名称, 默认 schema_migrations:<数据库名>
+	LockName string
+
+	// 等待迁移锁的时间, 默认 60s
+	LockTimeout time.Duration
+}
+
+/*
+Migrator
+
+	@Description: 数据库迁移执行器
+*/
+type Migrator struct {
+	db         *gorm.DB
+	opts       MigrateOptions
+	migrations map[uint64]*Migration
+}
+
+/*
+NewMigrator
+
+	@Description: 创建迁移执行器
+	@param db: gorm 对象, 为 nil 时使用 GetDBConn
+	@param opts: 迁移配置, 为 nil 时使用默认配置
+	@return *Migrator
+*/
+func NewMigrator(db *gorm.DB, opts *MigrateOptions) *Migrator {
+	if db == nil {
+		db = GetDBConn()
+	}
+	var o MigrateOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Table == "" {
+		o.Table = "schema_migrations"
+	}
+	if o.LockTimeout <= 0 {
+		o.LockTimeout = 60 * time.Second
+	}
+	return &Migrator{db: db, opts: o, migrations: make(map[uint64]*Migration)}
+}
+
+// Add 添加迁移, 版本号重复时返回错误
+func (m *Migrator) Add(migrations ...*Migration) error {
+	for _, mg := range migrations {
+		if _, ok := m.migrations[mg.Version]; ok {
+			return fmt.Errorf("迁移版本 %d 重复", mg.Version)
+		}
+		m.migrations[mg.Version] = mg
+	}
+	return nil
+}
+
+/*
+LoadFS
+
+	@Description: 从文件系统(如 embed.FS)加载 <版本>_<名称>.up.sql / .down.sql 迁移文件
+	@param fsys: 文件系统
+	@param dir: 迁移文件所在目录
+	@return error
+*/
+func (m *Migrator) LoadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	loaded := make(map[uint64]*Migration)
+	for _, e := range entries {
+		match := migrationFileRe.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("迁移文件 %s 版本号错误: %w", e.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+
+		mg, ok := loaded[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: match[2]}
+			loaded[version] = mg
+		} else if mg.Name != match[2] {
+			return fmt.Errorf("迁移版本 %d 名称不一致: %s, %s", version, mg.Name, match[2])
+		}
+		if match[3] == "up" {
+			mg.UpSQL = string(content)
+		} else {
+			mg.DownSQL = string(content)
+		}
+	}
+	for _, mg := range loaded {
+		if err = m.Add(mg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sorted 按版本号升序排列的迁移
+func (m *Migrator) sorted() []*Migration {
+	res := make([]*Migration, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		res = append(res, mg)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res
+}
+
+/*
+Up
+
+	@Description: 执行所有未执行的迁移
+	@return []uint64: 本次执行的版本
+	@return error
+*/
+func (m *Migrator) Up(ctx context.Context) ([]uint64, error) {
+	var done []uint64
+	err := m.locked(ctx, func(db *gorm.DB) error {
+		applied, err := m.applied(db)
+		if err != nil {
+			return err
+		}
+		if err = m.verify(applied); err != nil {
+			return err
+		}
+		for _, mg := range m.sorted() {
+			if _, ok := applied[mg.Version]; ok {
+				continue
+			}
+			if err = m.apply(db, mg); err != nil {
+				return err
+			}
+			done = append(done, mg.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+/*
+DownTo
+
+	@Description: 按版本号倒序回滚, 直到只保留小于等于 version 的迁移
+	@param version: 目标版本, 0 表示全部回滚
+	@return []uint64: 本次回滚的版本
+	@return error
+*/
+func (m *Migrator) DownTo(ctx context.Context, version uint64) ([]uint64, error) {
+	var done []uint64
+	err := m.locked(ctx, func(db *gorm.DB) error {
+		applied, err := m.applied(db)
+		if err != nil {
+			return err
+		}
+		if err = m.verify(applied); err != nil {
+			return err
+		}
+		versions := make([]uint64, 0, len(applied))
+		for v := range applied {
+			if v > version {
+				versions = append(versions, v)
+			}
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		for _, v := range versions {
+			mg, ok := m.migrations[v]
+			if !ok {
+				return fmt.Errorf("%w: %d", ErrMigrationNotFound, v)
+			}
+			if err = m.revert(db, mg); err != nil {
+				return err
+			}
+			done = append(done, v)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status 所有已知迁移和已执行迁移的状态
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	db := m.db.WithContext(ctx)
+	if err := m.ensureTable(db); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mg := range m.sorted() {
+		s := MigrationStatus{Version: mg.Version, Name: mg.Name}
+		if rec, ok := applied[mg.Version]; ok {
+			appliedAt := rec.AppliedAt
+			s.Applied, s.Dirty, s.AppliedAt = true, rec.Dirty, &appliedAt
+			s.Modified = rec.Checksum != mg.checksum()
+			delete(applied, mg.Version)
+		}
+		res = append(res, s)
+	}
+	// 数据库中存在但本地缺失的迁移
+	for _, rec := range applied {
+		appliedAt := rec.AppliedAt
+		res = append(res, MigrationStatus{Version: rec.Version, Name: rec.Name, Applied: true, Dirty: rec.Dirty, AppliedAt: &appliedAt})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res, nil
+}
+
+/*
+Force
+
+	@Description: 处理未完成迁移后恢复状态, 版本小于等于 version 的记录标记为完成, 大于 version 的记录删除
+	@param version: 数据库实际所处的版本
+	@return error
+*/
+func (m *Migrator) Force(ctx context.Context, version uint64) error {
+	return m.locked(ctx, func(db *gorm.DB) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Table(m.opts.Table).Where("version > ?", version).Delete(&schemaMigration{}).Error; err != nil {
+				return err
+			}
+			return tx.Table(m.opts.Table).Where("version <= ? AND dirty = ?", version, true).Update("dirty", false).Error
+		})
+	})
+}
+
+// locked 持有迁移锁执行, GET_LOCK 只在 mysql 中可用, 其他数据库不加锁
+func (m *Migrator) locked(ctx context.Context, fn func(db *gorm.DB) error) error {
+	if m.db.Dialector.Name() == "mysql" {
+		unlock, err := m.lock(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	db := m.db.WithContext(ctx)
+	if err := m.ensureTable(db); err != nil {
+		return err
+	}
+	return fn(db)
+}
+
+// lock 获取迁移锁, 返回释放方法
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	lockConn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	lockName := m.opts.LockName
+	if lockName == "" {
+		if err = lockConn.QueryRowContext(ctx, "SELECT CONCAT(?, ':', IFNULL(DATABASE(), ''))", m.opts.Table).Scan(&lockName); err != nil {
+			_ = lockConn.Close()
+			return nil, err
+		}
+	}
+	var got sql.NullInt64
+	if err = lockConn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, m.opts.LockTimeout.Seconds()).Scan(&got); err != nil {
+		_ = lockConn.Close()
+		return nil, err
+	}
+	if !got.Valid || got.Int64 != 1 {
+		_ = lockConn.Close()
+		return nil, ErrMigrationLocked
+	}
+	return func() {
+		_, _ = lockConn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", lockName)
+		_ = lockConn.Close()
+	}, nil
+}
+
+func (m *Migrator) ensureTable(db *gorm.DB) error {
+	return db.Table(m.opts.Table).AutoMigrate(&schemaMigration{})
+}
+
+// applied 已执行的迁移记录
+func (m *Migrator) applied(db *gorm.DB) (map[uint64]schemaMigration, error) {
+	var records []schemaMigration
+	if err := db.Table(m.opts.Table).Order("version").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	res := make(map[uint64]schemaMigration, len(records))
+	for _, r := range records {
+		res[r.Version] = r
+	}
+	return res, nil
+}
+
+// verify 检查未完成和被修改的迁移
+func (m *Migrator) verify(applied map[uint64]schemaMigration) error {
+	for v, rec := range applied {
+		if rec.Dirty {
+			return fmt.Errorf("%w: %d", ErrMigrationDirty, v)
+		}
+		if mg, ok := m.migrations[v]; ok && mg.checksum() != rec.Checksum {
+			return fmt.Errorf("%w: %d", ErrChecksumMismatch, v)
+		}
+	}
+	return nil
+}
+
+// apply 执行迁移, 执行前标记为未完成, 成功后清除标记
+func (m *Migrator) apply(db *gorm.DB, mg *Migration) error {
+	rec := schemaMigration{Version: mg.Version, Name: mg.Name, Checksum: mg.checksum(), Dirty: true, AppliedAt: time.Now()}
+	if err := db.Table(m.opts.Table).Create(&rec).Error; err != nil {
+		return err
+	}
+	if err := runMigration(db, mg.Up, mg.UpSQL); err != nil {
+		return fmt.Errorf("迁移 %d_%s 执行失败: %w", mg.Version, mg.Name, err)
+	}
+	return db.Table(m.opts.Table).Where("version = ?", mg.Version).
+		Updates(map[string]interface{}{"dirty": false, "applied_at": time.Now()}).Error
+}
+
+// revert 回滚迁移, 执行前标记为未完成, 成功后删除记录
+func (m *Migrator) revert(db *gorm.DB, mg *Migration) error {
+	if mg.Down == nil && strings.TrimSpace(mg.DownSQL) == "" {
+		return fmt.Errorf("迁移 %d_%s 没有回滚内容", mg.Version, mg.Name)
+	}
+	if err := db.Table(m.opts.Table).Where("version = ?", mg.Version).Update("dirty", true).Error; err != nil {
+		return err
+	}
+	if err := runMigration(db, mg.Down, mg.DownSQL); err != nil {
+		return fmt.Errorf("迁移 %d_%s 回滚失败: %w", mg.Version, mg.Name, err)
+	}
+	return db.Table(m.opts.Table).Where("version = ?", mg.Version).Delete(&schemaMigration{}).Error
+}
+
+func runMigration(db *gorm.DB, fn func(db *gorm.DB) error, script string) error {
+	if fn != nil {
+		return fn(db)
+	}
+	for _, stmt := range splitStatements(script) {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delimiterRe mysql 客户端修改语句分隔符的命令, 如 DELIMITER $$
+var delimiterRe = regexp.MustCompile(`(?i)^[ \t]*DELIMITER[ \t]+(\S+)[ \t]*(?:\r?\n|$)`)
+
+// splitStatements 按分号拆分 sql 脚本, 忽略引号内的分号和注释, 支持用 DELIMITER 修改分隔符以编写存储过程和触发器
+func splitStatements(script string) []string {
+	var (
+		res   []string
+		buf   strings.Builder
+		quote rune
+		delim = []rune(";")
+	)
+	flush := func() {
+		if s := strings.TrimSpace(buf.String()); s != "" {
+			res = append(res, s)
+		}
+		buf.Reset()
+	}
+	hasPrefix := func(runes, prefix []rune) bool {
+		if len(runes) < len(prefix) {
+			return false
+		}
+		for i, r := range prefix {
+			if runes[i] != r {
+				return false
+			}
+		}
+		return true
+	}
+
+	runes := []rune(script)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		// DELIMITER 只能出现在行首
+		if quote == 0 && (i == 0 || runes[i-1] == '\n') {
+			// 只匹配当前行, 避免每行都复制剩余脚本
+			end := i
+			for end < len(runes) && runes[end] != '\n' {
+				end++
+			}
+			if end < len(runes) {
+				end++
+			}
+			if match := delimiterRe.FindStringSubmatch(string(runes[i:end])); match != nil {
+				flush()
+				delim = []rune(match[1])
+				i += len([]rune(match[0])) - 1
+				continue
+			}
+		}
+		switch {
+		case quote != 0:
+			buf.WriteRune(r)
+			if r == '\\' && quote != '`' && i+1 < len(runes) {
+				i++
+				buf.WriteRune(runes[i])
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+			buf.WriteRune(r)
+		// mysql 中 -- 后需要有空白字符才是注释, 如 a--1 为 a - (-1)
+		case r == '#' || (r == '-' && hasPrefix(runes[i:], []rune("--")) && (i+2 == len(runes) || unicode.IsSpace(runes[i+2]))):
+			for i+1 < len(runes) && runes[i+1] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			// /*! ... */ 可执行注释和 /*+ ... */ 优化器提示原样保留
+			keep := i+2 < len(runes) && (runes[i+2] == '!' || runes[i+2] == '+')
+			start := i
+			for i += 2; i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/'); i++ {
+			}
+			i++ // 跳过结尾的 '/'
+			if keep {
+				buf.WriteString(string(runes[start:min(i+1, len(runes))]))
+			} else {
+				buf.WriteRune(' ')
+			}
+		case hasPrefix(runes[i:], delim):
+			i += len(delim) - 1
+			flush()
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	flush()
+	return res
+}
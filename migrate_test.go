@@ -0,0 +1,196 @@
+package Base_PKG
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{
+			name:   "按分号拆分",
+			script: "CREATE TABLE a (id INT);\nINSERT INTO a VALUES (1);",
+			want:   []string{"CREATE TABLE a (id INT)", "INSERT INTO a VALUES (1)"},
+		},
+		{
+			name:   "引号内的分号和注释",
+			script: `INSERT INTO a VALUES ('x;y', "-- z", 'it\'s');`,
+			want:   []string{`INSERT INTO a VALUES ('x;y', "-- z", 'it\'s')`},
+		},
+		{
+			name:   "注释",
+			script: "-- 建表;\nCREATE TABLE a (id INT); # 注释;\n/* 块;注释 */ DROP TABLE b;--\n",
+			want:   []string{"CREATE TABLE a (id INT)", "DROP TABLE b"},
+		},
+		{
+			name:   "-- 后没有空白字符不是注释",
+			script: "UPDATE a SET n = n--1;",
+			want:   []string{"UPDATE a SET n = n--1"},
+		},
+		{
+			name:   "DELIMITER 修改分隔符",
+			script: "DROP PROCEDURE IF EXISTS p;\nDELIMITER $$\nCREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\n  SELECT 2;\nEND$$\ndelimiter ;\nCALL p();",
+			want:   []string{"DROP PROCEDURE IF EXISTS p", "CREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\n  SELECT 2;\nEND", "CALL p()"},
+		},
+		{
+			name:   "保留可执行注释和优化器提示",
+			script: "/*!40101 SET @OLD_CHARSET=@@CHARACTER_SET_CLIENT; */;\n/*!40101 SET NAMES utf8mb4 */;\nSELECT /*+ MAX_EXECUTION_TIME(1000) */ * FROM a;",
+			want: []string{
+				"/*!40101 SET @OLD_CHARSET=@@CHARACTER_SET_CLIENT; */",
+				"/*!40101 SET NAMES utf8mb4 */",
+				"SELECT /*+ MAX_EXECUTION_TIME(1000) */ * FROM a",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.script); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitStatements_LargeScript(t *testing.T) {
+	const n = 50000
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "INSERT INTO a VALUES (%d, 'x;y');\n", i)
+	}
+	start := time.Now()
+	got := splitStatements(b.String())
+	if len(got) != n {
+		t.Fatalf("splitStatements() = %d statements, want %d", len(got), n)
+	}
+	// 按行匹配 DELIMITER 时拆分为线性耗时, 逐行复制剩余脚本时耗时随行数平方增长
+	if cost := time.Since(start); cost > 5*time.Second {
+		t.Errorf("splitStatements() took %v", cost)
+	}
+}
+
+func migrateFS() fstest.MapFS {
+	return fstest.MapFS{
+		"migrations/0002_add_name.up.sql":      {Data: []byte("ALTER TABLE mg_user ADD COLUMN name TEXT;")},
+		"migrations/0002_add_name.down.sql":    {Data: []byte("ALTER TABLE mg_user DROP COLUMN name;")},
+		"migrations/0001_create_user.up.sql":   {Data: []byte("CREATE TABLE mg_user (id INTEGER PRIMARY KEY);")},
+		"migrations/0001_create_user.down.sql": {Data: []byte("DROP TABLE mg_user;")},
+		"migrations/README.md":                 {Data: []byte("ignored")},
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	d := openSQLiteDB(t, nil)
+	ctx := context.Background()
+	var order []uint64
+	m := NewMigrator(d.Conn(), nil)
+	if err := m.LoadFS(migrateFS(), "migrations"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(&Migration{Version: 3, Name: "seed", Up: func(db *gorm.DB) error {
+		order = append(order, 3)
+		return db.Exec("INSERT INTO mg_user (id, name) VALUES (1, 'a')").Error
+	}, Down: func(db *gorm.DB) error {
+		return db.Exec("DELETE FROM mg_user").Error
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(&Migration{Version: 3}); err == nil {
+		t.Errorf("Add() duplicated version error = nil")
+	}
+
+	done, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(done, []uint64{1, 2, 3}) || len(order) != 1 {
+		t.Errorf("Up() = %v", done)
+	}
+	if done, err = m.Up(ctx); err != nil || len(done) != 0 {
+		t.Errorf("Up() again = %v, %v", done, err)
+	}
+
+	done, err = m.DownTo(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(done, []uint64{3, 2}) {
+		t.Errorf("DownTo() = %v, want [3 2]", done)
+	}
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 3 || !status[0].Applied || status[1].Applied || status[2].Applied {
+		t.Errorf("Status() = %+v", status)
+	}
+}
+
+func TestMigrator_Verify(t *testing.T) {
+	tests := []struct {
+		name    string
+		second  *Migration
+		wantErr error
+		// 修改记录表模拟迁移中断
+		dirty bool
+	}{
+		{
+			name:    "已执行的迁移内容被修改",
+			second:  &Migration{Version: 1, Name: "create", UpSQL: "CREATE TABLE mg_item (id INTEGER, name TEXT);"},
+			wantErr: ErrChecksumMismatch,
+		},
+		{
+			name:    "存在未完成的迁移",
+			second:  &Migration{Version: 1, Name: "create", UpSQL: "CREATE TABLE mg_item (id INTEGER);"},
+			dirty:   true,
+			wantErr: ErrMigrationDirty,
+		},
+		{
+			name:   "内容未修改",
+			second: &Migration{Version: 1, Name: "create", UpSQL: "CREATE TABLE mg_item (id INTEGER);"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := openSQLiteDB(t, nil)
+			ctx := context.Background()
+			first := NewMigrator(d.Conn(), nil)
+			if err := first.Add(&Migration{Version: 1, Name: "create", UpSQL: "CREATE TABLE mg_item (id INTEGER);"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := first.Up(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if tt.dirty {
+				d.Conn().Exec("UPDATE schema_migrations SET dirty = ?", true)
+			}
+
+			second := NewMigrator(d.Conn(), nil)
+			if err := second.Add(tt.second); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := second.Up(ctx); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Up() error = %v, want %v", err, tt.wantErr)
+			}
+			if !tt.dirty {
+				return
+			}
+			if err := second.Force(ctx, 1); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := second.Up(ctx); err != nil {
+				t.Errorf("Up() after Force error = %v", err)
+			}
+		})
+	}
+}
@@ -1,41 +1,245 @@
 package Base_PKG
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
+	"sync/atomic"
 	"time"
 )
 
-var conn *gorm.DB
+// conn 全局连接, 热更新时原子替换
+var conn atomic.Pointer[gorm.DB]
 
-func GetDBConn() *gorm.DB {
-	return conn
+// DBOptions
+// @Description: 数据库连接配置,未设置的字段使用 DefaultDBOptions 中的默认值
+type DBOptions struct {
+	// 连接池最大空闲连接数
+	MaxIdleConns int
+
+	// 连接池最大打开连接数
+	MaxOpenConns int
+
+	// 连接最大存活时间
+	ConnMaxLifetime time.Duration
+
+	// 连接最大空闲时间, 0 表示不限制
+	ConnMaxIdleTime time.Duration
+
+	// 生成当前时间的方法, 为空时使用 Location 时区的当前时间
+	NowFunc func() time.Time
+
+	// 时区, 为空时使用本地时区
+	Location *time.Location
+
+	// 命名策略, 为空时禁用表名复数
+	NamingStrategy schema.Namer
+
+	// gorm 日志, 为空时使用 gorm 默认日志
+	Logger logger.Interface
+
+	// 开启 gorm 单条写操作的默认事务, 默认关闭
+	DefaultTransaction bool
+
+	// 通过 Ctx/DB.Ctx 执行的语句的默认超时, 为空时使用 DefaultTimeoutOptions, 不需要时传入零值.
+	// 这些语句多一次 context.WithTimeout, 开启 KillOnCancel 时还要独占连接, 见 TimeoutOptions
+	Timeouts *TimeoutOptions
 }
 
-func InitConn(dsn string) {
-	var err error
-	conn, err = gorm.Open(mysql.New(mysql.Config{
-		DSN:                      dsn,
-		DisableDatetimePrecision: true, // 禁用 datetime 精度，MySQL 5.6 之前的数据库不支持
-	}), &gorm.Config{
-		NowFunc: func() time.Time {
-			return time.Now().Local()
-		},
+/*
+DefaultDBOptions
+
+	@Description: 默认的数据库连接配置, Ctx/DB.Ctx 执行的语句开启默认超时和 KILL QUERY
+	@return DBOptions
+*/
+func DefaultDBOptions() DBOptions {
+	timeouts := DefaultTimeoutOptions()
+	return DBOptions{
+		Timeouts:        &timeouts,
+		MaxIdleConns:    10,
+		MaxOpenConns:    100,
+		ConnMaxLifetime: 60 * time.Minute,
+		Location:        time.Local,
 		NamingStrategy: schema.NamingStrategy{
 			SingularTable: true, // 禁用表名复数
 		},
-		SkipDefaultTransaction: true,
-	})
+	}
+}
+
+// withDefaults 未设置的字段填充默认值
+func (o *DBOptions) withDefaults() DBOptions {
+	def := DefaultDBOptions()
+	if o == nil {
+		return def
+	}
+	opts := *o
+	if opts.MaxIdleConns <= 0 {
+		opts.MaxIdleConns = def.MaxIdleConns
+	}
+	if opts.MaxOpenConns <= 0 {
+		opts.MaxOpenConns = def.MaxOpenConns
+	}
+	if opts.ConnMaxLifetime <= 0 {
+		opts.ConnMaxLifetime = def.ConnMaxLifetime
+	}
+	if opts.Location == nil {
+		opts.Location = def.Location
+	}
+	if opts.NamingStrategy == nil {
+		opts.NamingStrategy = def.NamingStrategy
+	}
+	if opts.Timeouts == nil {
+		opts.Timeouts = def.Timeouts
+	}
+	if opts.NowFunc == nil {
+		loc := opts.Location
+		opts.NowFunc = func() time.Time {
+			return time.Now().In(loc)
+		}
+	}
+	return opts
+}
+
+// gormConfig 转换为 gorm 配置
+func (o DBOptions) gormConfig() *gorm.Config {
+	return &gorm.Config{
+		NowFunc:                o.NowFunc,
+		NamingStrategy:         o.NamingStrategy,
+		Logger:                 o.Logger,
+		SkipDefaultTransaction: !o.DefaultTransaction,
+	}
+}
+
+/*
+DB
+
+	@Description: 数据库连接,持有 gorm 对象及其底层连接池
+*/
+type DB struct {
+	gdb   *gorm.DB
+	sqlDB *sql.DB
+	opts  DBOptions
+}
+
+/*
+NewDB
+
+	@Description: 创建 mysql 数据库连接. opts 为 nil 或未设置 Timeouts 时, 通过 Ctx/DB.Ctx 执行的语句
+	在上下文没有截止时间时读 10s、写 30s 超时, 取消或超时时 KILL QUERY 并独占连接直到语句结束;
+	直接使用 Conn() 的语句不受影响. 不需要时设置 Timeouts 为零值或关闭 KillOnCancel
+	@param dsn: 数据库连接串
+	@param opts: 连接配置, 为 nil 时使用默认配置
+	@return *DB
+	@return error
+*/
+func NewDB(dsn string, opts *DBOptions) (*DB, error) {
+	return OpenDB(mysql.New(mysql.Config{
+		DSN:                      dsn,
+		DisableDatetimePrecision: true, // 禁用 datetime 精度，MySQL 5.6 之前的数据库不支持
+	}), opts)
+}
+
+/*
+OpenDB
+
+	@Description: 使用指定的 gorm Dialector 创建数据库连接
+	@param dialector: gorm 驱动
+	@param opts: 连接配置, 为 nil 时使用默认配置
+	@return *DB
+	@return error
+*/
+func OpenDB(dialector gorm.Dialector, opts *DBOptions) (*DB, error) {
+	o := opts.withDefaults()
+	gdb, err := gorm.Open(dialector, o.gormConfig())
 	if err != nil {
-		panic("init db connect fail, error: " + err.Error())
+		return nil, fmt.Errorf("init db connect fail, error: %w", err)
+	}
+
+	sqlDB, err := gdb.DB()
+	if err != nil {
+		// 连接池已打开, 非 *sql.DB 的连接池尽量关闭避免泄漏
+		if closer, ok := gdb.ConnPool.(interface{ Close() error }); ok {
+			_ = closer.Close()
+		}
+		return nil, fmt.Errorf("init db connect pool error, get sql.DB object found error: %w", err)
+	}
+	if *o.Timeouts != (TimeoutOptions{}) {
+		if err = gdb.Use(NewTimeoutPlugin(*o.Timeouts)); err != nil {
+			_ = sqlDB.Close()
+			return nil, fmt.Errorf("init db timeout plugin error: %w", err)
+		}
+	}
+	sqlDB.SetMaxIdleConns(o.MaxIdleConns)
+	sqlDB.SetMaxOpenConns(o.MaxOpenConns)
+	sqlDB.SetConnMaxLifetime(o.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(o.ConnMaxIdleTime)
+
+	return &DB{gdb: gdb, sqlDB: sqlDB, opts: o}, nil
+}
+
+// Conn 获取 gorm 对象
+func (d *DB) Conn() *gorm.DB {
+	return d.gdb
+}
+
+// SQLDB 获取底层连接池
+func (d *DB) SQLDB() *sql.DB {
+	return d.sqlDB
+}
+
+// Options 获取生效的连接配置
+func (d *DB) Options() DBOptions {
+	return d.opts
+}
+
+// Close 关闭连接池
+func (d *DB) Close() error {
+	if d == nil || d.sqlDB == nil {
+		return errors.New("db 未初始化")
 	}
+	return d.sqlDB.Close()
+}
+
+/*
+SetDefaultDB
 
-	if SQLDB, err := conn.DB(); err != nil {
-		panic("init db connect pool error, get sql.DB object found error: " + err.Error())
-	} else {
-		SQLDB.SetMaxIdleConns(10)
-		SQLDB.SetMaxOpenConns(100)
-		SQLDB.SetConnMaxLifetime(60 * time.Minute)
+	@Description: 设置 GetDBConn 返回的全局连接
+	@param d: 数据库连接
+*/
+func SetDefaultDB(d *DB) {
+	conn.Store(d.Conn())
+}
+
+/*
+SetDBConn
+
+	@Description: 直接替换 GetDBConn 返回的 gorm 对象, 如测试中替换为事务
+	@param db: gorm 对象
+	@return *gorm.DB: 替换前的对象, 用于恢复
+*/
+func SetDBConn(db *gorm.DB) *gorm.DB {
+	return conn.Swap(db)
+}
+
+func GetDBConn() *gorm.DB {
+	return conn.Load()
+}
+
+/*
+InitConn
+
+	@Description: 使用默认配置初始化全局连接, 失败时 panic. 需要处理错误时使用 NewDB 和 SetDefaultDB.
+	通过 Ctx 执行的语句使用默认超时并在取消时 KILL QUERY, GetDBConn 的语句不受影响, 见 NewDB
+	@param dsn: 数据库连接串
+*/
+func InitConn(dsn string) {
+	d, err := NewDB(dsn, nil)
+	if err != nil {
+		panic("init db connect fail, error: " + err.Error())
 	}
+	SetDefaultDB(d)
 }
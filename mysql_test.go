@@ -0,0 +1,134 @@
+package Base_PKG
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func TestInitConn_Panic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("InitConn() with invalid dsn did not panic")
+		}
+	}()
+	InitConn("invalid dsn")
+}
+
+func TestNewDB_Error(t *testing.T) {
+	d, err := NewDB("invalid dsn", nil)
+	if err == nil || d != nil {
+		t.Errorf("NewDB() = %v, %v, want error", d, err)
+	}
+}
+
+// failDialector 初始化时直接失败
+type failDialector struct {
+	gorm.Dialector
+}
+
+func (failDialector) Initialize(*gorm.DB) error {
+	return errors.New("dial fail")
+}
+
+// wrappedPool 非 *sql.DB 的连接池, gorm.DB() 无法取得 sql.DB
+type wrappedPool struct {
+	*sql.DB
+}
+
+// leakDialector 在 sqlite 基础上模拟 OpenDB 后续步骤失败, 记录打开的连接池
+type leakDialector struct {
+	gorm.Dialector
+	sqlDB *sql.DB
+
+	// 使用不可识别的连接池, 使 gdb.DB() 失败
+	wrapPool bool
+
+	// 预先注册超时插件, 使 gdb.Use 失败
+	usePlugin bool
+}
+
+func (d *leakDialector) Initialize(db *gorm.DB) error {
+	if err := d.Dialector.Initialize(db); err != nil {
+		return err
+	}
+	d.sqlDB = db.ConnPool.(*sql.DB)
+	if d.wrapPool {
+		db.ConnPool = wrappedPool{DB: d.sqlDB}
+	}
+	if d.usePlugin {
+		return db.Use(NewTimeoutPlugin(DefaultTimeoutOptions()))
+	}
+	return nil
+}
+
+func TestOpenDB_Error(t *testing.T) {
+	if _, err := OpenDB(failDialector{sqlite.Open(":memory:")}, nil); err == nil {
+		t.Errorf("OpenDB() with failing dialector error = nil")
+	}
+
+	tests := []struct {
+		name      string
+		wrapPool  bool
+		usePlugin bool
+	}{
+		{name: "获取连接池失败", wrapPool: true},
+		{name: "注册超时插件失败", usePlugin: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialector := &leakDialector{Dialector: sqlite.Open(":memory:"), wrapPool: tt.wrapPool, usePlugin: tt.usePlugin}
+			d, err := OpenDB(dialector, nil)
+			if err == nil || d != nil {
+				t.Fatalf("OpenDB() = %v, %v, want error", d, err)
+			}
+			if err = dialector.sqlDB.Ping(); err == nil || err.Error() != "sql: database is closed" {
+				t.Errorf("sql.DB after OpenDB() error not closed, ping error = %v", err)
+			}
+		})
+	}
+}
+
+func TestDBOptions_withDefaults(t *testing.T) {
+	def := DefaultDBOptions()
+
+	t.Run("nil 使用默认配置", func(t *testing.T) {
+		var o *DBOptions
+		got := o.withDefaults()
+		if got.MaxIdleConns != def.MaxIdleConns || got.MaxOpenConns != def.MaxOpenConns || got.ConnMaxLifetime != def.ConnMaxLifetime {
+			t.Errorf("withDefaults() pool = %d/%d/%v, want %d/%d/%v", got.MaxIdleConns, got.MaxOpenConns, got.ConnMaxLifetime,
+				def.MaxIdleConns, def.MaxOpenConns, def.ConnMaxLifetime)
+		}
+		if got.Timeouts == nil || *got.Timeouts != *def.Timeouts {
+			t.Errorf("withDefaults() Timeouts = %v, want %v", got.Timeouts, *def.Timeouts)
+		}
+	})
+
+	t.Run("零值字段填充默认值", func(t *testing.T) {
+		got := (&DBOptions{MaxOpenConns: -1}).withDefaults()
+		if got.MaxOpenConns != def.MaxOpenConns || got.MaxIdleConns != def.MaxIdleConns {
+			t.Errorf("withDefaults() MaxOpenConns/MaxIdleConns = %d/%d, want %d/%d", got.MaxOpenConns, got.MaxIdleConns, def.MaxOpenConns, def.MaxIdleConns)
+		}
+		if got.Location != time.Local || got.NamingStrategy == nil || got.NowFunc == nil {
+			t.Errorf("withDefaults() Location/NamingStrategy/NowFunc not defaulted: %+v", got)
+		}
+	})
+
+	t.Run("保留已设置字段", func(t *testing.T) {
+		loc := time.FixedZone("UTC+8", 8*3600)
+		timeouts := TimeoutOptions{}
+		got := (&DBOptions{MaxIdleConns: 2, MaxOpenConns: 5, ConnMaxLifetime: time.Minute, Location: loc, Timeouts: &timeouts}).withDefaults()
+		if got.MaxIdleConns != 2 || got.MaxOpenConns != 5 || got.ConnMaxLifetime != time.Minute {
+			t.Errorf("withDefaults() pool = %d/%d/%v, want 2/5/1m0s", got.MaxIdleConns, got.MaxOpenConns, got.ConnMaxLifetime)
+		}
+		if got.Timeouts != &timeouts {
+			t.Errorf("withDefaults() replaced explicit zero Timeouts")
+		}
+		if got.NowFunc().Location() != loc {
+			t.Errorf("withDefaults() NowFunc location = %v, want %v", got.NowFunc().Location(), loc)
+		}
+	})
+}
@@ -103,8 +103,21 @@ type (
 		*/
 		DeleteConfig(dataId string, group string) error
 	}
+
+	// NacosListener 回调方式监听配置, NewNacosClient 返回的客户端实现该接口, 使用时对 NacosDO 做类型断言
+	NacosListener interface {
+
+		/*ListenConfigWithFunc
+		@Description: 监听配置修改事件, 配置修改后执行 onChange
+		@param onChange 回调方法, data 为修改后的配置
+		@return error
+		*/
+		ListenConfigWithFunc(dataId string, group string, onChange func(namespace, group, dataId, data string)) error
+	}
 )
 
+var _ NacosListener = (*nacosClient)(nil)
+
 /*
 NewNacosClient
 
@@ -305,6 +318,26 @@ func (n *nacosClient) ListenConfig(dataId string, group string) {
 	return
 }
 
+// ListenConfigWithFunc
+// @Description: 监听配置修改事件, 配置修改后执行 onChange
+// @param dataId
+// @param group
+// @param onChange 回调方法, data 为修改后的配置
+// @return error
+func (n *nacosClient) ListenConfigWithFunc(dataId string, group string, onChange func(namespace, group, dataId, data string)) error {
+	if n.configClient == nil {
+		if err := n.CreateConfigClient(); err != nil {
+			return err
+		}
+	}
+	client := *n.configClient
+	return client.ListenConfig(vo.ConfigParam{
+		DataId:   dataId,
+		Group:    group,
+		OnChange: onChange,
+	})
+}
+
 // CancelListenConfig
 // @Description: 取消监听事件
 // @param dataId
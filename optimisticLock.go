@@ -0,0 +1,179 @@
+package Base_PKG
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+/*
+	基于 Version 字段的乐观锁
+*/
+
+const (
+	// optimisticVersionKey 本次更新前的版本号
+	optimisticVersionKey = "base_pkg:optimistic_version"
+
+	// optimisticSetKey 本次更新的 SET 子句由插件生成
+	optimisticSetKey = "base_pkg:optimistic_set"
+)
+
+/*
+OptimisticLockPlugin
+
+	@Description: 乐观锁插件, 通过 db.Use 注册. 模型包含整数类型的 Version 字段时,
+	更新语句自动追加 version = version + 1; 按主键更新单条记录时追加 WHERE version = 当前版本,
+	没有更新到记录时返回 ErrStaleObject
+*/
+type OptimisticLockPlugin struct{}
+
+func NewOptimisticLockPlugin() *OptimisticLockPlugin {
+	return &OptimisticLockPlugin{}
+}
+
+// Name 实现 gorm.Plugin
+func (p *OptimisticLockPlugin) Name() string {
+	return "base_pkg:optimistic_lock"
+}
+
+// Initialize 实现 gorm.Plugin
+func (p *OptimisticLockPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback().Update()
+	if err := cb.Before("gorm:update").Register("base_pkg:optimistic_lock_before", p.before); err != nil {
+		return fmt.Errorf("register optimistic lock callback error: %w", err)
+	}
+	if err := cb.After("gorm:update").Register("base_pkg:optimistic_lock_after", p.after); err != nil {
+		return fmt.Errorf("register optimistic lock callback error: %w", err)
+	}
+	return nil
+}
+
+// versionField 模型的版本号字段
+func versionField(sch *schema.Schema) *schema.Field {
+	if sch == nil {
+		return nil
+	}
+	f := sch.LookUpField("Version")
+	if f == nil || f.DBName == "" {
+		return nil
+	}
+	switch f.FieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return f
+	}
+	return nil
+}
+
+func (p *OptimisticLockPlugin) before(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.SQL.Len() > 0 {
+		return
+	}
+	field := versionField(stmt.Schema)
+	if field == nil {
+		return
+	}
+	if _, ok := stmt.Clauses["SET"]; ok {
+		return
+	}
+
+	// 单条记录按主键更新时, 以更新前的版本号作为条件
+	var (
+		current interface{}
+		locked  = stmt.ReflectValue.Kind() == reflect.Struct
+	)
+	if locked {
+		for _, pf := range stmt.Schema.PrimaryFields {
+			if _, zero := pf.ValueOf(stmt.Context, stmt.ReflectValue); zero {
+				locked = false
+			}
+		}
+	}
+	if locked {
+		current, _ = field.ValueOf(stmt.Context, stmt.ReflectValue)
+	}
+
+	set := callbacks.ConvertToAssignments(stmt)
+	if len(set) == 0 {
+		return
+	}
+	assignments := make(clause.Set, 0, len(set)+1)
+	for _, a := range set {
+		if a.Column.Name != field.DBName {
+			assignments = append(assignments, a)
+		}
+	}
+	assignments = append(assignments, clause.Assignment{
+		Column: clause.Column{Name: field.DBName},
+		Value:  gorm.Expr("? + 1", clause.Column{Table: clause.CurrentTable, Name: field.DBName}),
+	})
+	stmt.AddClause(assignments)
+	db.InstanceSet(optimisticSetKey, true)
+
+	if locked {
+		stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+			clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: current},
+		}})
+		db.InstanceSet(optimisticVersionKey, current)
+	}
+}
+
+func (p *OptimisticLockPlugin) after(db *gorm.DB) {
+	if _, ok := db.InstanceGet(optimisticSetKey); !ok {
+		return
+	}
+	delete(db.Statement.Clauses, "SET")
+
+	current, ok := db.InstanceGet(optimisticVersionKey)
+	if !ok || db.Error != nil || db.DryRun {
+		return
+	}
+	if db.RowsAffected == 0 {
+		_ = db.AddError(ErrStaleObject)
+		return
+	}
+	// 同步内存中的版本号, 便于继续更新同一对象
+	field := versionField(db.Statement.Schema)
+	next := reflect.ValueOf(current)
+	if next.CanInt() {
+		_ = field.Set(db.Statement.Context, db.Statement.ReflectValue, next.Int()+1)
+	} else {
+		_ = field.Set(db.Statement.Context, db.Statement.ReflectValue, next.Uint()+1)
+	}
+}
+
+/*
+UpdateWithRetry
+
+	@Description: 按主键读取最新记录后执行修改, 遇到 ErrStaleObject 时重新读取并重试
+	@param db: gorm 对象, 为 nil 时使用 GetDBConn
+	@param id: 主键
+	@param maxRetries: 最大重试次数
+	@param mutate: 修改方法, 在 obj 上修改并通过 db 保存
+	@return error
+*/
+func UpdateWithRetry[T any](ctx context.Context, db *gorm.DB, id any, maxRetries int, mutate func(db *gorm.DB, obj *T) error) error {
+	if db == nil {
+		db = GetDBConn()
+	}
+	for attempt := 0; ; attempt++ {
+		var obj T
+		tx := db.WithContext(ctx)
+		if err := tx.Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).First(&obj).Error; err != nil {
+			return TranslateError(err)
+		}
+		err := mutate(tx, &obj)
+		if !errors.Is(err, ErrStaleObject) || attempt >= maxRetries {
+			return err
+		}
+		if ctx.Err() != nil {
+			return errors.Join(err, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package Base_PKG
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+)
+
+type lockedItem struct {
+	ID      uint
+	Name    string
+	Version int
+}
+
+func optimisticDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := openSQLiteDB(t, nil, &lockedItem{}).Conn()
+	if err := db.Use(NewOptimisticLockPlugin()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&lockedItem{Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestOptimisticLock(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(db *gorm.DB, obj *lockedItem) error
+	}{
+		{name: "Save", update: func(db *gorm.DB, obj *lockedItem) error {
+			obj.Name = "b"
+			return db.Save(obj).Error
+		}},
+		{name: "Updates", update: func(db *gorm.DB, obj *lockedItem) error {
+			return db.Model(obj).Updates(lockedItem{Name: "b"}).Error
+		}},
+		{name: "Update", update: func(db *gorm.DB, obj *lockedItem) error {
+			return db.Model(obj).Update("name", "b").Error
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := optimisticDB(t)
+			var first, stale lockedItem
+			db.First(&first, 1)
+			db.First(&stale, 1)
+
+			if err := tt.update(db, &first); err != nil {
+				t.Fatal(err)
+			}
+			if first.Version != 1 {
+				t.Errorf("version in memory = %d, want 1", first.Version)
+			}
+			// 同一对象可以继续更新
+			if err := tt.update(db, &first); err != nil {
+				t.Errorf("second update error = %v", err)
+			}
+			if err := tt.update(db, &stale); !errors.Is(err, ErrStaleObject) {
+				t.Errorf("stale update error = %v, want %v", err, ErrStaleObject)
+			}
+			var got lockedItem
+			db.First(&got, 1)
+			var n int64
+			db.Model(&lockedItem{}).Count(&n)
+			if got.Version != 2 || n != 1 {
+				t.Errorf("stored = %+v, rows = %d", got, n)
+			}
+		})
+	}
+
+	t.Run("批量更新只递增版本号", func(t *testing.T) {
+		db := optimisticDB(t)
+		if err := db.Model(&lockedItem{}).Where("name = ?", "a").Update("name", "b").Error; err != nil {
+			t.Fatal(err)
+		}
+		var got lockedItem
+		db.First(&got, 1)
+		if got.Name != "b" || got.Version != 1 {
+			t.Errorf("stored = %+v", got)
+		}
+	})
+}
+
+func TestUpdateWithRetry(t *testing.T) {
+	db := optimisticDB(t)
+	var attempts int
+	err := UpdateWithRetry[lockedItem](context.Background(), db, 1, 3, func(tx *gorm.DB, obj *lockedItem) error {
+		attempts++
+		if attempts == 1 {
+			// 其他请求在读取之后修改了记录
+			if err := tx.Exec("UPDATE locked_item SET version = version + 1").Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(obj).Update("name", "retried").Error
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("UpdateWithRetry() = %v, attempts = %d", err, attempts)
+	}
+
+	err = UpdateWithRetry[lockedItem](context.Background(), db, 9, 3, func(*gorm.DB, *lockedItem) error { return nil })
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateWithRetry() missing error = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,394 @@
+package Base_PKG
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+	事务性发件箱: 业务写入与 outbox 记录在同一事务提交, 中继任务将记录投递到 etcd 队列
+*/
+
+const (
+	OutboxPending   int8 = 0
+	OutboxDelivered int8 = 1
+
+	// OutboxFailed 超过最大投递次数, 不再投递
+	OutboxFailed int8 = 2
+)
+
+// OutboxMessage
+// @Description: 发件箱记录
+type OutboxMessage struct {
+	ID            uint64     `gorm:"primaryKey"`
+	Queue         string     `gorm:"size:128;not null"`
+	Payload       string     `gorm:"type:mediumtext;not null"`
+	Priority      uint16     `gorm:"not null;default:0"`
+	Status        int8       `gorm:"not null;default:0;index:idx_outbox_status,priority:1"`
+	Attempts      int        `gorm:"not null;default:0"`
+	LastError     string     `gorm:"size:512"`
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_outbox_status,priority:2"`
+	CreatedAt     time.Time  `gorm:"not null"`
+	DeliveredAt   *time.Time `gorm:"index"`
+}
+
+// OutboxSink 投递目标
+type OutboxSink interface {
+	Deliver(msg *OutboxMessage) error
+}
+
+// NormalQueueSink 投递到普通队列, etcd.NormalQDO 满足 Q 的接口
+type NormalQueueSink struct {
+	Q interface {
+		Push(v []byte) error
+	}
+}
+
+func (s NormalQueueSink) Deliver(msg *OutboxMessage) error {
+	return s.Q.Push([]byte(msg.Payload))
+}
+
+// PriorityQueueSink 以记录的优先指数投递到优先队列, etcd.PriorityQDO 满足 Q 的接口
+type PriorityQueueSink struct {
+	Q interface {
+		Push(v string, pr uint16) error
+	}
+}
+
+func (s PriorityQueueSink) Deliver(msg *OutboxMessage) error {
+	return s.Q.Push(msg.Payload, msg.Priority)
+}
+
+// Locker 中继任务的竞争锁, etcd.EMutex 满足该接口
+type Locker interface {
+	Lock(v string) error
+
+	UnLock() error
+}
+
+// LockChecker 可以检查锁是否仍被持有的 Locker, 如租约过期或连接断开后返回 false.
+// etcd.EtcdMutex 和 etcd.MysqlMutex 实现该接口
+type LockChecker interface {
+	Locker
+
+	IsHeld() bool
+}
+
+/*
+EnqueueOutbox
+
+	@Description: 在业务事务中写入发件箱记录
+	@param tx: 业务事务
+	@param queue: 目标队列名称, 对应中继任务配置的 sink
+	@param payload: 消息内容
+	@param priority: 优先指数, 仅优先队列使用
+	@return error
+*/
+func EnqueueOutbox(tx *gorm.DB, queue string, payload []byte, priority uint16) error {
+	now := time.Now()
+	return tx.Create(&OutboxMessage{
+		Queue:         queue,
+		Payload:       string(payload),
+		Priority:      priority,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}).Error
+}
+
+// EnqueueOutboxJSON 以 json 格式写入发件箱记录
+func EnqueueOutboxJSON(tx *gorm.DB, queue string, v interface{}, priority uint16) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return EnqueueOutbox(tx, queue, b, priority)
+}
+
+// OutboxOptions
+// @Description: 中继任务配置
+type OutboxOptions struct {
+	// 轮询间隔, 默认 1s
+	Interval time.Duration
+
+	// 每轮投递的最大记录数, 默认 100
+	BatchSize int
+
+	// 最大投递次数, 超过后标记为 OutboxFailed, 默认 10
+	MaxAttempts int
+
+	// 投递失败后的重试间隔, 按次数翻倍, 默认 5s, 最长 5min
+	RetryBackoff time.Duration
+
+	// 已投递记录的保留时间, 默认 24h
+	Retention time.Duration
+
+	// 清理已投递记录的间隔, 默认 10min
+	CleanupInterval time.Duration
+
+	// 竞争锁, 多实例部署时抢到锁的实例成为 leader 并持有锁直到 Stop, 其他实例每个轮询间隔重新抢锁.
+	// 实现 LockChecker 时 leader 每轮投递前检查锁, 锁丢失后放弃 leader 重新抢锁;
+	// 未实现时无法发现租约过期, 可能出现多个实例同时投递. 为空时不竞争
+	Locker Locker
+}
+
+/*
+OutboxRelay
+
+	@Description: 发件箱中继任务
+*/
+type OutboxRelay struct {
+	db    *gorm.DB
+	sinks map[string]OutboxSink
+	opts  OutboxOptions
+	owner string
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+/*
+NewOutboxRelay
+
+	@Description: 创建中继任务
+	@param db: gorm 对象, 为 nil 时使用 GetDBConn
+	@param sinks: 队列名称到投递目标的映射
+	@param opts: 中继配置, 为 nil 时使用默认配置
+	@return *OutboxRelay
+*/
+func NewOutboxRelay(db *gorm.DB, sinks map[string]OutboxSink, opts *OutboxOptions) *OutboxRelay {
+	if db == nil {
+		db = GetDBConn()
+	}
+	var o OutboxOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Interval <= 0 {
+		o.Interval = time.Second
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 10
+	}
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = 5 * time.Second
+	}
+	if o.Retention <= 0 {
+		o.Retention = 24 * time.Hour
+	}
+	if o.CleanupInterval <= 0 {
+		o.CleanupInterval = 10 * time.Minute
+	}
+	owner, _ := os.Hostname()
+	return &OutboxRelay{db: db, sinks: sinks, opts: o, owner: owner}
+}
+
+// AutoMigrate 创建发件箱表
+func (r *OutboxRelay) AutoMigrate() error {
+	return r.db.AutoMigrate(&OutboxMessage{})
+}
+
+/*
+Start
+
+	@Description: 启动后台投递和清理, 重复调用无效. 配置了 Locker 时只有持有锁的实例执行
+	@param ctx: 上下文, 取消后任务停止并释放锁
+*/
+func (r *OutboxRelay) Start(ctx context.Context) {
+	r.mu.Lock()
+	if r.cancel != nil {
+		r.mu.Unlock()
+		return
+	}
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.done = make(chan struct{})
+	done := r.done
+	r.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		leader := r.opts.Locker == nil
+		defer func() {
+			if leader && r.opts.Locker != nil {
+				if err := r.opts.Locker.UnLock(); err != nil {
+					zap.L().Error("outbox relay unlock found error", zap.Error(err))
+				}
+			}
+		}()
+		ticker := time.NewTicker(r.opts.Interval)
+		defer ticker.Stop()
+		lastCleanup := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if leader && !r.held() {
+					zap.L().Warn("outbox relay lost leadership", zap.String("owner", r.owner))
+					_ = r.opts.Locker.UnLock()
+					leader = false
+				}
+				if !leader {
+					if leader = r.acquire(); !leader {
+						continue
+					}
+				}
+				if _, err := r.RunOnce(ctx); err != nil {
+					zap.L().Error("outbox relay found error", zap.Error(err))
+				}
+				if time.Since(lastCleanup) >= r.opts.CleanupInterval {
+					if _, err := r.Cleanup(ctx); err != nil {
+						zap.L().Error("outbox cleanup found error", zap.Error(err))
+					}
+					lastCleanup = time.Now()
+				}
+			}
+		}
+	}()
+}
+
+// acquire 抢锁成为 leader
+func (r *OutboxRelay) acquire() bool {
+	if err := r.opts.Locker.Lock(r.owner + "@" + time.Now().String()); err != nil {
+		// 抢锁失败时同样释放, 回收 etcd 锁创建的租约
+		_ = r.opts.Locker.UnLock()
+		zap.L().Debug("outbox relay lock not acquired", zap.Error(err))
+		return false
+	}
+	zap.L().Info("outbox relay became leader", zap.String("owner", r.owner))
+	return true
+}
+
+// held 检查 leader 是否仍持有锁, Locker 未实现 LockChecker 时视为持有
+func (r *OutboxRelay) held() bool {
+	checker, ok := r.opts.Locker.(LockChecker)
+	return !ok || checker.IsHeld()
+}
+
+// Stop 停止后台任务并等待退出
+func (r *OutboxRelay) Stop() {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel, r.done = nil, nil
+	r.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+/*
+RunOnce
+
+	@Description: 执行一轮投递, 不使用 Locker, 多实例时由调用方保证只有一个实例执行.
+	投递成功但标记失败时会重复投递, 消费方需要幂等
+	@return int: 投递成功的记录数
+	@return error
+*/
+func (r *OutboxRelay) RunOnce(ctx context.Context) (int, error) {
+	db := r.db.WithContext(ctx)
+	var msgs []OutboxMessage
+	err := db.Where("status = ? AND next_attempt_at <= ?", OutboxPending, time.Now()).
+		Order("id").Limit(r.opts.BatchSize).Find(&msgs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	var errs []error
+	for i := range msgs {
+		if ctx.Err() != nil {
+			break
+		}
+		msg := &msgs[i]
+		if err = r.deliver(msg); err == nil {
+			now := time.Now()
+			err = db.Model(msg).Updates(map[string]interface{}{
+				"status":       OutboxDelivered,
+				"attempts":     msg.Attempts + 1,
+				"delivered_at": &now,
+				"last_error":   "",
+			}).Error
+			if err == nil {
+				delivered++
+				continue
+			}
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, fmt.Errorf("outbox %d: %w", msg.ID, err))
+		if err = r.fail(db, msg, err); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return delivered, errors.Join(errs...)
+}
+
+func (r *OutboxRelay) deliver(msg *OutboxMessage) error {
+	sink, ok := r.sinks[msg.Queue]
+	if !ok {
+		return fmt.Errorf("队列 %s 没有配置投递目标", msg.Queue)
+	}
+	return sink.Deliver(msg)
+}
+
+// fail 记录投递失败, 超过最大次数后不再投递
+func (r *OutboxRelay) fail(db *gorm.DB, msg *OutboxMessage, cause error) error {
+	attempts := msg.Attempts + 1
+	status := OutboxPending
+	if attempts >= r.opts.MaxAttempts {
+		status = OutboxFailed
+	}
+	// last_error 按字符限制长度, 按字节截断会拆开多字节字符, 严格模式下写入失败
+	lastError := cause.Error()
+	if runes := []rune(lastError); len(runes) > 512 {
+		lastError = string(runes[:512])
+	}
+	return db.Model(msg).Updates(map[string]interface{}{
+		"status":          status,
+		"attempts":        attempts,
+		"last_error":      lastError,
+		"next_attempt_at": time.Now().Add(backoff(attempts-1, r.opts.RetryBackoff, 5*time.Minute)),
+	}).Error
+}
+
+/*
+Cleanup
+
+	@Description: 分批删除超过保留时间的已投递记录
+	@return int64: 删除的记录数
+	@return error
+*/
+func (r *OutboxRelay) Cleanup(ctx context.Context) (int64, error) {
+	db := r.db.WithContext(ctx)
+	var total int64
+	before := time.Now().Add(-r.opts.Retention)
+	for ctx.Err() == nil {
+		// 先查询主键再删除, DELETE ... LIMIT 不是所有数据库都支持
+		var ids []uint64
+		err := db.Model(&OutboxMessage{}).Where("status = ? AND delivered_at < ?", OutboxDelivered, before).
+			Order("id").Limit(1000).Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return total, err
+		}
+		res := db.Where("id IN ?", ids).Delete(&OutboxMessage{})
+		if res.Error != nil {
+			return total, res.Error
+		}
+		total += res.RowsAffected
+		if len(ids) < 1000 {
+			break
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,272 @@
+package Base_PKG
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// fakeSink 记录投递的内容, err 不为空时投递失败
+type fakeSink struct {
+	mu       sync.Mutex
+	payloads []string
+	err      error
+}
+
+func (s *fakeSink) Deliver(msg *OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.payloads = append(s.payloads, msg.Payload)
+	return nil
+}
+
+func (s *fakeSink) delivered() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.payloads...)
+}
+
+// fakeLocker 多个中继共享的锁, 记录抢锁次数
+type fakeLocker struct {
+	mu     sync.Mutex
+	holder *fakeLockerHandle
+}
+
+type fakeLockerHandle struct {
+	l     *fakeLocker
+	locks int
+}
+
+func (h *fakeLockerHandle) Lock(string) error {
+	h.l.mu.Lock()
+	defer h.l.mu.Unlock()
+	h.locks++
+	if h.l.holder != nil {
+		return errors.New("locked")
+	}
+	h.l.holder = h
+	return nil
+}
+
+func (h *fakeLockerHandle) UnLock() error {
+	h.l.mu.Lock()
+	defer h.l.mu.Unlock()
+	if h.l.holder == h {
+		h.l.holder = nil
+	}
+	return nil
+}
+
+func (h *fakeLockerHandle) IsHeld() bool {
+	h.l.mu.Lock()
+	defer h.l.mu.Unlock()
+	return h.l.holder == h
+}
+
+// steal 模拟租约过期后锁被 h 抢到
+func (l *fakeLocker) steal(h *fakeLockerHandle) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.holder = h
+}
+
+func (h *fakeLockerHandle) count() int {
+	h.l.mu.Lock()
+	defer h.l.mu.Unlock()
+	return h.locks
+}
+
+func outboxDB(t *testing.T) *DB {
+	t.Helper()
+	d := openSQLiteDB(t, nil)
+	if err := NewOutboxRelay(d.Conn(), nil, nil).AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestOutboxRelay_RunOnce(t *testing.T) {
+	d := outboxDB(t)
+	ctx := context.Background()
+	ok, broken := &fakeSink{}, &fakeSink{err: errors.New("down")}
+	relay := NewOutboxRelay(d.Conn(), map[string]OutboxSink{"ok": ok, "broken": broken},
+		&OutboxOptions{MaxAttempts: 2, RetryBackoff: time.Nanosecond})
+
+	// 回滚的事务不写入发件箱
+	_ = d.Conn().Transaction(func(tx *gorm.DB) error {
+		_ = EnqueueOutbox(tx, "ok", []byte("rollback"), 0)
+		return errors.New("rollback")
+	})
+	err := d.Conn().Transaction(func(tx *gorm.DB) error {
+		if err := EnqueueOutbox(tx, "ok", []byte("a"), 0); err != nil {
+			return err
+		}
+		if err := EnqueueOutboxJSON(tx, "ok", map[string]int{"b": 1}, 0); err != nil {
+			return err
+		}
+		if err := EnqueueOutbox(tx, "broken", []byte("c"), 0); err != nil {
+			return err
+		}
+		return EnqueueOutbox(tx, "missing", []byte("d"), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := relay.RunOnce(ctx)
+	if n != 2 || err == nil {
+		t.Errorf("RunOnce() = %d, %v, want 2 and error", n, err)
+	}
+	if got := ok.delivered(); len(got) != 2 || got[0] != "a" || got[1] != `{"b":1}` {
+		t.Errorf("delivered = %v", got)
+	}
+
+	// 第二次失败后达到最大投递次数
+	time.Sleep(time.Millisecond)
+	if n, err = relay.RunOnce(ctx); n != 0 || err == nil {
+		t.Errorf("RunOnce() again = %d, %v", n, err)
+	}
+	var msgs []OutboxMessage
+	if err = d.Conn().Order("id").Find(&msgs).Error; err != nil {
+		t.Fatal(err)
+	}
+	wantStatus := []int8{OutboxDelivered, OutboxDelivered, OutboxFailed, OutboxFailed}
+	for i, msg := range msgs {
+		if msg.Status != wantStatus[i] {
+			t.Errorf("message %d status = %d, want %d", msg.ID, msg.Status, wantStatus[i])
+		}
+	}
+	if msgs[2].Attempts != 2 || msgs[2].LastError != "down" {
+		t.Errorf("failed message = %+v", msgs[2])
+	}
+
+	// 失败的记录不再投递
+	if n, err = relay.RunOnce(ctx); n != 0 || err != nil {
+		t.Errorf("RunOnce() after failed = %d, %v", n, err)
+	}
+}
+
+func TestOutboxRelay_LongError(t *testing.T) {
+	d := outboxDB(t)
+	cause := strings.Repeat("投递失败", 200)
+	relay := NewOutboxRelay(d.Conn(), map[string]OutboxSink{"q": &fakeSink{err: errors.New(cause)}}, nil)
+	if err := EnqueueOutbox(d.Conn(), "q", []byte("a"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := relay.RunOnce(context.Background()); err == nil {
+		t.Fatal("RunOnce() error = nil")
+	}
+	var msg OutboxMessage
+	if err := d.Conn().First(&msg).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 按字符截断, 不拆开多字节字符
+	if !utf8.ValidString(msg.LastError) || utf8.RuneCountInString(msg.LastError) != 512 || !strings.HasPrefix(cause, msg.LastError) {
+		t.Errorf("last_error = %q (%d runes)", msg.LastError, utf8.RuneCountInString(msg.LastError))
+	}
+	if msg.Attempts != 1 {
+		t.Errorf("attempts = %d, want 1", msg.Attempts)
+	}
+}
+
+func TestOutboxRelay_Cleanup(t *testing.T) {
+	d := outboxDB(t)
+	old, recent := time.Now().Add(-48*time.Hour), time.Now()
+	msgs := []OutboxMessage{
+		{Queue: "q", Payload: "old", Status: OutboxDelivered, DeliveredAt: &old},
+		{Queue: "q", Payload: "recent", Status: OutboxDelivered, DeliveredAt: &recent},
+		{Queue: "q", Payload: "pending", Status: OutboxPending},
+	}
+	if err := d.Conn().Create(&msgs).Error; err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewOutboxRelay(d.Conn(), nil, nil).Cleanup(context.Background())
+	if err != nil || n != 1 {
+		t.Errorf("Cleanup() = %d, %v, want 1", n, err)
+	}
+	var left int64
+	d.Conn().Model(&OutboxMessage{}).Count(&left)
+	if left != 2 {
+		t.Errorf("left = %d, want 2", left)
+	}
+}
+
+func TestOutboxRelay_Leadership(t *testing.T) {
+	d := outboxDB(t)
+	sink := &fakeSink{}
+	sinks := map[string]OutboxSink{"q": sink}
+	locker := &fakeLocker{}
+	first, second := &fakeLockerHandle{l: locker}, &fakeLockerHandle{l: locker}
+	r1 := NewOutboxRelay(d.Conn(), sinks, &OutboxOptions{Interval: 5 * time.Millisecond, Locker: first})
+	r2 := NewOutboxRelay(d.Conn(), sinks, &OutboxOptions{Interval: 5 * time.Millisecond, Locker: second})
+
+	r1.Start(context.Background())
+	waitFor(t, func() bool { return first.count() > 0 })
+	r2.Start(context.Background())
+	defer r2.Stop()
+	if err := EnqueueOutbox(d.Conn(), "q", []byte("a"), 0); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(sink.delivered()) == 1 })
+
+	// leader 持有锁, 不会每轮重新抢锁
+	time.Sleep(30 * time.Millisecond)
+	if n := first.count(); n != 1 {
+		t.Errorf("leader Lock() calls = %d, want 1", n)
+	}
+	if second.count() < 2 {
+		t.Errorf("follower Lock() calls = %d, want retries", second.count())
+	}
+
+	// leader 停止后释放锁, 其他实例接替
+	r1.Stop()
+	if err := EnqueueOutbox(d.Conn(), "q", []byte("b"), 0); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(sink.delivered()) == 2 })
+}
+
+func TestOutboxRelay_LostLock(t *testing.T) {
+	d := outboxDB(t)
+	locker := &fakeLocker{}
+	first, other := &fakeLockerHandle{l: locker}, &fakeLockerHandle{l: locker}
+	sink := &fakeSink{}
+	relay := NewOutboxRelay(d.Conn(), map[string]OutboxSink{"q": sink}, &OutboxOptions{Interval: 5 * time.Millisecond, Locker: first})
+	relay.Start(context.Background())
+	defer relay.Stop()
+	waitFor(t, first.IsHeld)
+
+	// leader 的租约过期后锁被其他实例抢到, 原 leader 不再投递
+	locker.steal(other)
+	time.Sleep(30 * time.Millisecond)
+	if err := EnqueueOutbox(d.Conn(), "q", []byte("a"), 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if got := sink.delivered(); len(got) != 0 {
+		t.Errorf("relay without lock delivered %v", got)
+	}
+
+	// 其他实例释放后重新抢到锁继续投递
+	_ = other.UnLock()
+	waitFor(t, func() bool { return len(sink.delivered()) == 1 })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
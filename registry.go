@@ -0,0 +1,319 @@
+package Base_PKG
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+	多数据库注册中心, 一主多从读写分离
+*/
+
+// ReadPolicy 从库选择策略
+type ReadPolicy int
+
+const (
+	// PolicyRandom 随机选择从库
+	PolicyRandom ReadPolicy = iota
+
+	// PolicyRoundRobin 轮询选择从库
+	PolicyRoundRobin
+
+	// PolicyLeastConn 选择使用中连接数最少的从库
+	PolicyLeastConn
+)
+
+// forcePrimaryKey 强制走主库的 gorm 设置项
+const forcePrimaryKey = "base_pkg:force_primary"
+
+var (
+	ErrClusterNotFound = errors.New("数据库集群不存在")
+	ErrClusterExists   = errors.New("数据库集群已注册")
+)
+
+// ClusterConfig
+// @Description: 数据库集群配置
+type ClusterConfig struct {
+	// 主库连接串
+	Primary string
+
+	// 从库连接串
+	Replicas []string
+
+	// 从库选择策略
+	Policy ReadPolicy
+
+	// 连接配置, 主从库共用
+	Options *DBOptions
+}
+
+/*
+Cluster
+
+	@Description: 一主多从的数据库集群, 读请求路由到从库, 写请求和事务路由到主库
+*/
+type Cluster struct {
+	name     string
+	primary  *DB
+	replicas []*DB
+	policy   ReadPolicy
+
+	// 主库连接池, 写请求切回主库时使用
+	primaryPool gorm.ConnPool
+
+	// active 当前参与读轮询的从库
+	mu     sync.RWMutex
+	active []*DB
+
+	counter uint64
+}
+
+/*
+NewCluster
+
+	@Description: 基于已建立的连接创建集群, 在主库的 gorm 对象上注册读写分离回调
+	@param name: 集群名称
+	@param primary: 主库
+	@param replicas: 从库
+	@param policy: 从库选择策略
+	@return *Cluster
+	@return error
+*/
+func NewCluster(name string, primary *DB, replicas []*DB, policy ReadPolicy) (*Cluster, error) {
+	if primary == nil {
+		return nil, errors.New("主库不能为空")
+	}
+	c := &Cluster{
+		name:        name,
+		primary:     primary,
+		replicas:    replicas,
+		policy:      policy,
+		primaryPool: primary.Conn().ConnPool,
+		active:      append([]*DB(nil), replicas...),
+	}
+
+	// 在所有回调之前选择连接池, 语句超时等插件使用选中的连接池
+	cb := primary.Conn().Callback()
+	for _, err := range []error{
+		cb.Query().Before("*").Register("base_pkg:resolver_read", c.useReplica),
+		cb.Row().Before("*").Register("base_pkg:resolver_read", c.useReplica),
+		cb.Create().Before("*").Register("base_pkg:resolver_write", c.usePrimary),
+		cb.Update().Before("*").Register("base_pkg:resolver_write", c.usePrimary),
+		cb.Delete().Before("*").Register("base_pkg:resolver_write", c.usePrimary),
+		cb.Raw().Before("*").Register("base_pkg:resolver_write", c.usePrimary),
+	} {
+		if err != nil {
+			return nil, fmt.Errorf("register resolver callback error: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// Name 集群名称
+func (c *Cluster) Name() string {
+	return c.name
+}
+
+// DB 读写分离的 gorm 对象, 查询走从库, 写入和事务走主库
+func (c *Cluster) DB() *gorm.DB {
+	return c.primary.Conn()
+}
+
+// Primary 强制所有请求走主库, 用于写后立即读的场景
+func (c *Cluster) Primary() *gorm.DB {
+	return c.primary.Conn().Set(forcePrimaryKey, true).Session(&gorm.Session{})
+}
+
+// PrimaryDB 主库连接
+func (c *Cluster) PrimaryDB() *DB {
+	return c.primary
+}
+
+// Replicas 全部从库连接, 包括被摘除的从库
+func (c *Cluster) Replicas() []*DB {
+	return c.replicas
+}
+
+/*
+Transaction
+
+	@Description: 在主库上执行事务
+	@param fn: 事务内执行的方法
+	@param opts: 事务参数
+	@return error
+*/
+func (c *Cluster) Transaction(fn func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	return c.primary.Conn().Transaction(fn, opts...)
+}
+
+// Close 关闭主从库连接池
+func (c *Cluster) Close() error {
+	var errs []error
+	errs = append(errs, c.primary.Close())
+	for _, r := range c.replicas {
+		errs = append(errs, r.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// readable 当前参与读轮询的从库
+func (c *Cluster) readable() []*DB {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.active
+}
+
+// pick 按策略选择从库, 没有可用从库时返回 nil
+func (c *Cluster) pick() *DB {
+	replicas := c.readable()
+	switch len(replicas) {
+	case 0:
+		return nil
+	case 1:
+		return replicas[0]
+	}
+
+	switch c.policy {
+	case PolicyRoundRobin:
+		n := atomic.AddUint64(&c.counter, 1)
+		return replicas[(n-1)%uint64(len(replicas))]
+	case PolicyLeastConn:
+		best := replicas[0]
+		bestInUse := best.SQLDB().Stats().InUse
+		for _, r := range replicas[1:] {
+			if inUse := r.SQLDB().Stats().InUse; inUse < bestInUse {
+				best, bestInUse = r, inUse
+			}
+		}
+		return best
+	default:
+		return replicas[rand.Intn(len(replicas))]
+	}
+}
+
+// inTransaction 当前语句是否处于事务中
+func inTransaction(db *gorm.DB) bool {
+	_, ok := db.Statement.ConnPool.(gorm.TxCommitter)
+	return ok
+}
+
+// useReplica 查询语句切换到从库
+func (c *Cluster) useReplica(db *gorm.DB) {
+	if db.Error != nil || inTransaction(db) {
+		return
+	}
+	if force, ok := db.Get(forcePrimaryKey); ok && force == true {
+		db.Statement.ConnPool = c.primaryPool
+		return
+	}
+	if r := c.pick(); r != nil {
+		db.Statement.ConnPool = r.SQLDB()
+		return
+	}
+	db.Statement.ConnPool = c.primaryPool
+}
+
+// usePrimary 写语句切换回主库
+func (c *Cluster) usePrimary(db *gorm.DB) {
+	if db.Error != nil || inTransaction(db) {
+		return
+	}
+	db.Statement.ConnPool = c.primaryPool
+}
+
+/*
+Registry
+
+	@Description: 按名称管理多个数据库集群
+*/
+type Registry struct {
+	mu       sync.RWMutex
+	clusters map[string]*Cluster
+}
+
+func NewRegistry() *Registry {
+	return &Registry{clusters: make(map[string]*Cluster)}
+}
+
+/*
+Register
+
+	@Description: 按配置建立主从连接并注册集群
+	@param name: 集群名称
+	@param cfg: 集群配置
+	@return *Cluster
+	@return error
+*/
+func (r *Registry) Register(name string, cfg ClusterConfig) (*Cluster, error) {
+	if _, err := r.Get(name); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrClusterExists, name)
+	}
+	primary, err := NewDB(cfg.Primary, cfg.Options)
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s primary: %w", name, err)
+	}
+	replicas := make([]*DB, 0, len(cfg.Replicas))
+	for i, dsn := range cfg.Replicas {
+		replica, err := NewDB(dsn, cfg.Options)
+		if err != nil {
+			_ = primary.Close()
+			for _, opened := range replicas {
+				_ = opened.Close()
+			}
+			return nil, fmt.Errorf("cluster %s replica %d: %w", name, i, err)
+		}
+		replicas = append(replicas, replica)
+	}
+
+	c, err := NewCluster(name, primary, replicas, cfg.Policy)
+	if err == nil {
+		err = r.Add(c)
+	}
+	if err != nil {
+		_ = primary.Close()
+		for _, opened := range replicas {
+			_ = opened.Close()
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// Add 注册已创建的集群
+func (r *Registry) Add(c *Cluster) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.clusters[c.name]; ok {
+		return fmt.Errorf("%w: %s", ErrClusterExists, c.name)
+	}
+	r.clusters[c.name] = c
+	return nil
+}
+
+// Get 按名称获取集群
+func (r *Registry) Get(name string) (*Cluster, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.clusters[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrClusterNotFound, name)
+	}
+	return c, nil
+}
+
+// Close 关闭并移除所有集群
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var errs []error
+	for name, c := range r.clusters {
+		errs = append(errs, c.Close())
+		delete(r.clusters, name)
+	}
+	return errors.Join(errs...)
+}
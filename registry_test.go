@@ -0,0 +1,162 @@
+package Base_PKG
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+)
+
+type registryItem struct {
+	ID     uint
+	Source string
+}
+
+// clusterDB 主库和从库各写入一条标记来源的记录
+func clusterDB(t *testing.T, policy ReadPolicy, replicas ...string) *Cluster {
+	t.Helper()
+	open := func(source string) *DB {
+		d := openSQLiteDB(t, nil, &registryItem{})
+		if err := d.Conn().Create(&registryItem{Source: source}).Error; err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	dbs := make([]*DB, 0, len(replicas))
+	for _, name := range replicas {
+		dbs = append(dbs, open(name))
+	}
+	c, err := NewCluster("test", open("primary"), dbs, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func readSource(t *testing.T, db *gorm.DB) string {
+	t.Helper()
+	var item registryItem
+	if err := db.First(&item).Error; err != nil {
+		t.Fatal(err)
+	}
+	return item.Source
+}
+
+func TestCluster_Routing(t *testing.T) {
+	c := clusterDB(t, PolicyRoundRobin, "replica")
+	tests := []struct {
+		name string
+		db   func() *gorm.DB
+		want string
+	}{
+		{name: "查询走从库", db: c.DB, want: "replica"},
+		{name: "强制主库", db: c.Primary, want: "primary"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readSource(t, tt.db()); got != tt.want {
+				t.Errorf("source = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("写入和事务走主库", func(t *testing.T) {
+		if err := c.DB().Create(&registryItem{Source: "written"}).Error; err != nil {
+			t.Fatal(err)
+		}
+		var n int64
+		err := c.Transaction(func(tx *gorm.DB) error {
+			return tx.Model(&registryItem{}).Where("source = ?", "written").Count(&n).Error
+		})
+		if err != nil || n != 1 {
+			t.Errorf("primary count = %d, %v, want 1", n, err)
+		}
+		if c.DB().Model(&registryItem{}).Where("source = ?", "written").Count(&n); n != 0 {
+			t.Errorf("replica count = %d, want 0", n)
+		}
+	})
+
+	t.Run("从库全部摘除后回退到主库", func(t *testing.T) {
+		c.eject(c.Replicas()[0])
+		if got := readSource(t, c.DB()); got != "primary" {
+			t.Errorf("source = %s, want primary", got)
+		}
+		c.restore(c.Replicas()[0])
+		if got := readSource(t, c.DB()); got != "replica" {
+			t.Errorf("source after restore = %s, want replica", got)
+		}
+	})
+}
+
+func TestCluster_Pick(t *testing.T) {
+	t.Run("轮询", func(t *testing.T) {
+		c := clusterDB(t, PolicyRoundRobin, "a", "b", "c")
+		want := []int{0, 1, 2, 0, 1}
+		for i, w := range want {
+			if got := c.pick(); got != c.Replicas()[w] {
+				t.Errorf("pick() #%d != replica %d", i, w)
+			}
+		}
+		// 摘除的从库不参与轮询
+		c.eject(c.Replicas()[1])
+		for i := 0; i < 4; i++ {
+			if c.pick() == c.Replicas()[1] {
+				t.Fatal("pick() returned ejected replica")
+			}
+		}
+	})
+
+	t.Run("最少连接", func(t *testing.T) {
+		c := clusterDB(t, PolicyLeastConn, "a", "b")
+		conn, err := c.Replicas()[0].SQLDB().Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		for i := 0; i < 3; i++ {
+			if got := c.pick(); got != c.Replicas()[1] {
+				t.Errorf("pick() #%d != idle replica", i)
+			}
+		}
+	})
+
+	t.Run("随机", func(t *testing.T) {
+		c := clusterDB(t, PolicyRandom, "a", "b")
+		seen := make(map[*DB]bool)
+		for i := 0; i < 100; i++ {
+			seen[c.pick()] = true
+		}
+		if len(seen) != 2 {
+			t.Errorf("picked %d replicas, want 2", len(seen))
+		}
+	})
+
+	t.Run("没有从库", func(t *testing.T) {
+		if c := clusterDB(t, PolicyRandom); c.pick() != nil {
+			t.Errorf("pick() without replicas != nil")
+		}
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := clusterDB(t, PolicyRandom, "replica")
+	if err := r.Add(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(c); !errors.Is(err, ErrClusterExists) {
+		t.Errorf("Add() again error = %v, want %v", err, ErrClusterExists)
+	}
+	if _, err := r.Register("test", ClusterConfig{}); !errors.Is(err, ErrClusterExists) {
+		t.Errorf("Register() existing error = %v, want %v", err, ErrClusterExists)
+	}
+	if got, err := r.Get("test"); err != nil || got != c {
+		t.Errorf("Get() = %v, %v", got, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Get("test"); !errors.Is(err, ErrClusterNotFound) {
+		t.Errorf("Get() after Close error = %v, want %v", err, ErrClusterNotFound)
+	}
+}
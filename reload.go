@@ -0,0 +1,198 @@
+package Base_PKG
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+/*
+	从 nacos 配置热更新数据库连接: 新建连接池并验证后原子替换 GetDBConn, 旧连接池在宽限期后关闭
+*/
+
+// ConfigSource 配置中心, nacos.NacosDO 满足该接口
+type ConfigSource interface {
+	GetConfig(dataId string, group string) (string, error)
+
+	CancelListenConfig(dataId string, group string) error
+}
+
+// ConfigListener 支持回调监听的配置中心, NewNacosClient 返回的客户端满足该接口(nacos.NacosListener)
+type ConfigListener interface {
+	ListenConfigWithFunc(dataId string, group string, onChange func(namespace, group, dataId, data string)) error
+}
+
+// ReloadOptions
+// @Description: 热更新配置
+type ReloadOptions struct {
+	// 配置的 data id 和 group, 内容为 yaml 或 json 格式的 MySQLConfig
+	DataId string
+	Group  string
+
+	// 旧连接池的宽限期, 期间正在执行的查询可以完成, 默认 30s
+	GracePeriod time.Duration
+
+	// 新连接池 ping 的超时时间, 默认 5s
+	PingTimeout time.Duration
+
+	// 根据配置创建连接, 默认 NewDBWithConfig
+	Open func(cfg *MySQLConfig) (*DB, error)
+
+	// 每个新连接池注册的插件, 如 NewAuditPlugin、NewEncryptionPlugin, 每次调用需要返回新的实例.
+	// 新建的 gorm 对象不会继承旧连接池上注册的插件, 需要通过该项注册, Open 中已注册的同名插件跳过
+	Plugins func() []gorm.Plugin
+
+	// 每次更新后的回调, err 不为空时表示更新失败, 继续使用旧连接池
+	OnReload func(cfg *MySQLConfig, err error)
+}
+
+/*
+Reloader
+
+	@Description: 数据库连接热更新. 只替换 GetDBConn 的返回值,
+	创建时传入了固定 gorm 对象的组件(如 NewOutboxRelay(db, ...))需要自行重建
+*/
+type Reloader struct {
+	src  ConfigSource
+	opts ReloadOptions
+
+	mu      sync.Mutex
+	current *DB
+	content string
+	drains  sync.WaitGroup
+}
+
+/*
+NewReloader
+
+	@Description: 创建热更新
+	@param src: 配置中心
+	@param opts: 热更新配置
+	@return *Reloader
+*/
+func NewReloader(src ConfigSource, opts ReloadOptions) *Reloader {
+	if opts.GracePeriod <= 0 {
+		opts.GracePeriod = 30 * time.Second
+	}
+	if opts.PingTimeout <= 0 {
+		opts.PingTimeout = 5 * time.Second
+	}
+	if opts.Open == nil {
+		opts.Open = NewDBWithConfig
+	}
+	return &Reloader{src: src, opts: opts}
+}
+
+/*
+Start
+
+	@Description: 读取当前配置初始化全局连接, 并监听后续修改. 配置中心需要实现 ConfigListener
+	@return error
+*/
+func (r *Reloader) Start() error {
+	listener, ok := r.src.(ConfigListener)
+	if !ok {
+		return errors.New("配置中心不支持监听配置修改, 需要实现 ConfigListener")
+	}
+	content, err := r.src.GetConfig(r.opts.DataId, r.opts.Group)
+	if err != nil {
+		return fmt.Errorf("get db config from %s/%s: %w", r.opts.Group, r.opts.DataId, err)
+	}
+	if err = r.Apply(content); err != nil {
+		return err
+	}
+	return listener.ListenConfigWithFunc(r.opts.DataId, r.opts.Group, func(namespace, group, dataId, data string) {
+		if err := r.Apply(data); err != nil {
+			zap.L().Error("reload db config found error", zap.String("dataId", dataId), zap.Error(err))
+		}
+	})
+}
+
+/*
+Apply
+
+	@Description: 使用配置内容重建连接池, 内容未变化时不处理. 新连接池 ping 失败时保留旧连接池
+	@param content: yaml 或 json 格式的 MySQLConfig
+	@return error
+*/
+func (r *Reloader) Apply(content string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current != nil && content == r.content {
+		return nil
+	}
+
+	cfg, err := ParseMySQLConfig([]byte(content))
+	if err == nil {
+		err = r.swap(cfg)
+	}
+	if err == nil {
+		r.content = content
+		zap.L().Info("db config reloaded", zap.Stringer("config", cfg))
+	}
+	if r.opts.OnReload != nil {
+		r.opts.OnReload(cfg, err)
+	}
+	return err
+}
+
+// swap 新建连接池并验证, 成功后替换全局连接并关闭旧连接池
+func (r *Reloader) swap(cfg *MySQLConfig) error {
+	d, err := r.opts.Open(cfg)
+	if err != nil {
+		return err
+	}
+	if r.opts.Plugins != nil {
+		for _, p := range r.opts.Plugins() {
+			if err = d.Conn().Use(p); err != nil && !errors.Is(err, gorm.ErrRegistered) {
+				return errors.Join(fmt.Errorf("use plugin %s: %w", p.Name(), err), d.Close())
+			}
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.opts.PingTimeout)
+	defer cancel()
+	if err = d.SQLDB().PingContext(ctx); err != nil {
+		return errors.Join(fmt.Errorf("ping new db: %w", err), d.Close())
+	}
+
+	SetDefaultDB(d)
+	old := r.current
+	r.current = d
+	if old != nil {
+		r.drains.Add(1)
+		go func() {
+			defer r.drains.Done()
+			r.drain(old)
+		}()
+	}
+	return nil
+}
+
+// drain 等待旧连接池的查询完成或宽限期结束后关闭
+func (r *Reloader) drain(old *DB) {
+	deadline := time.Now().Add(r.opts.GracePeriod)
+	for old.SQLDB().Stats().InUse > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err := old.Close(); err != nil {
+		zap.L().Warn("close old db pool found error", zap.Error(err))
+	}
+}
+
+// DB 当前使用的连接
+func (r *Reloader) DB() *DB {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Stop 取消监听并等待旧连接池关闭, 当前连接池不关闭
+func (r *Reloader) Stop() error {
+	err := r.src.CancelListenConfig(r.opts.DataId, r.opts.Group)
+	r.drains.Wait()
+	return err
+}
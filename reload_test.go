@@ -0,0 +1,150 @@
+package Base_PKG
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+// fakeConfigSource 内存配置中心, Publish 触发监听回调
+type fakeConfigSource struct {
+	content  string
+	onChange func(namespace, group, dataId, data string)
+}
+
+func (s *fakeConfigSource) GetConfig(dataId string, group string) (string, error) {
+	return s.content, nil
+}
+
+func (s *fakeConfigSource) ListenConfigWithFunc(dataId string, group string, onChange func(namespace, group, dataId, data string)) error {
+	s.onChange = onChange
+	return nil
+}
+
+func (s *fakeConfigSource) CancelListenConfig(dataId string, group string) error {
+	s.onChange = nil
+	return nil
+}
+
+func (s *fakeConfigSource) Publish(content string) {
+	s.content = content
+	if s.onChange != nil {
+		s.onChange("", "DEFAULT_GROUP", "db", content)
+	}
+}
+
+// countPlugin 记录 Initialize 的 gorm 对象
+type countPlugin struct {
+	dbs []*gorm.DB
+}
+
+func (p *countPlugin) Name() string {
+	return "test:count"
+}
+
+func (p *countPlugin) Initialize(db *gorm.DB) error {
+	p.dbs = append(p.dbs, db)
+	return nil
+}
+
+// pollConfigSource 不支持回调监听的配置中心
+type pollConfigSource struct {
+	content string
+}
+
+func (s *pollConfigSource) GetConfig(dataId string, group string) (string, error) {
+	return s.content, nil
+}
+
+func (s *pollConfigSource) CancelListenConfig(dataId string, group string) error {
+	return nil
+}
+
+func TestReloader_NotListener(t *testing.T) {
+	r := NewReloader(&pollConfigSource{content: "host: db1\nuser: app\n"}, ReloadOptions{DataId: "db", Group: "DEFAULT_GROUP"})
+	if err := r.Start(); err == nil {
+		t.Errorf("Start() with source not implementing ConfigListener error = nil")
+	}
+}
+
+func TestReloader(t *testing.T) {
+	prev := SetDBConn(nil)
+	t.Cleanup(func() { SetDBConn(prev) })
+
+	src := &fakeConfigSource{content: "host: db1\nuser: app\n"}
+	plugin := &countPlugin{}
+	var (
+		hosts   []string
+		reloads []error
+	)
+	r := NewReloader(src, ReloadOptions{
+		DataId:      "db",
+		Group:       "DEFAULT_GROUP",
+		GracePeriod: 10 * time.Millisecond,
+		Open: func(cfg *MySQLConfig) (*DB, error) {
+			hosts = append(hosts, cfg.Host)
+			d := openSQLiteDB(t, nil)
+			// Open 中已注册的插件不重复注册
+			if cfg.Host == "db3" {
+				if err := d.Conn().Use(plugin); err != nil {
+					return nil, err
+				}
+			}
+			return d, nil
+		},
+		Plugins:  func() []gorm.Plugin { return []gorm.Plugin{plugin} },
+		OnReload: func(cfg *MySQLConfig, err error) { reloads = append(reloads, err) },
+	})
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	first := r.DB()
+
+	tests := []struct {
+		name      string
+		content   string
+		wantHosts []string
+		wantErr   bool
+	}{
+		{name: "配置变化重建连接池", content: "host: db2\nuser: app\n", wantHosts: []string{"db1", "db2"}},
+		{name: "配置未变化", content: "host: db2\nuser: app\n", wantHosts: []string{"db1", "db2"}},
+		{name: "配置无效保留旧连接池", content: "host: db2\n", wantHosts: []string{"db1", "db2"}, wantErr: true},
+		{name: "Open 中已注册插件", content: "host: db3\nuser: app\n", wantHosts: []string{"db1", "db2", "db3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, n := r.DB(), len(reloads)
+			src.Publish(tt.content)
+			if len(hosts) != len(tt.wantHosts) || hosts[len(hosts)-1] != tt.wantHosts[len(tt.wantHosts)-1] {
+				t.Errorf("opened hosts = %v, want %v", hosts, tt.wantHosts)
+			}
+			if tt.wantErr {
+				if len(reloads) != n+1 || reloads[n] == nil || r.DB() != before {
+					t.Errorf("reloads = %v, db replaced = %v", reloads, r.DB() != before)
+				}
+			}
+			if GetDBConn() != r.DB().Conn() {
+				t.Errorf("GetDBConn() not current db")
+			}
+		})
+	}
+
+	// 每个连接池都注册了一次插件
+	if len(plugin.dbs) != 3 {
+		t.Fatalf("plugin initialized %d times, want 3", len(plugin.dbs))
+	}
+	if _, ok := GetDBConn().Config.Plugins["test:count"]; !ok {
+		t.Errorf("plugin not registered on current db")
+	}
+	if errs := errors.Join(reloads...); errs == nil {
+		t.Errorf("invalid config not reported")
+	}
+
+	if err := r.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.SQLDB().Ping(); err == nil {
+		t.Errorf("old db pool not closed")
+	}
+}
@@ -0,0 +1,189 @@
+package Base_PKG
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/*
+	通用 CRUD 仓储
+*/
+
+// Scope gorm 查询条件
+type Scope = func(db *gorm.DB) *gorm.DB
+
+// Page
+// @Description: 分页查询结果
+type Page[T any] struct {
+	Items []T `json:"items"`
+
+	// 符合条件的总数
+	Total int64 `json:"total"`
+
+	// 页码, 从 1 开始
+	Page int `json:"page"`
+
+	// 每页数量
+	Size int `json:"size"`
+}
+
+/*
+Repository
+
+	@Description: 模型 T 的通用仓储, 上下文中存在 WithTx 开启的事务时在事务内执行
+*/
+type Repository[T any] struct {
+	db *gorm.DB
+}
+
+/*
+NewRepository
+
+	@Description: 创建仓储
+	@param db: gorm 对象, 为 nil 时使用 GetDBConn
+	@return *Repository[T]
+*/
+func NewRepository[T any](db *gorm.DB) *Repository[T] {
+	return &Repository[T]{db: db}
+}
+
+// conn 获取本次操作使用的 gorm 对象
+func (r *Repository[T]) conn(ctx context.Context) *gorm.DB {
+	if tx := TxFromContext(ctx); tx != nil {
+		return tx.WithContext(ctx)
+	}
+	db := r.db
+	if db == nil {
+		db = GetDBConn()
+	}
+	return db.WithContext(ctx)
+}
+
+// Create 创建记录
+func (r *Repository[T]) Create(ctx context.Context, entity *T) error {
+	return TranslateError(r.conn(ctx).Create(entity).Error)
+}
+
+/*
+BatchCreate
+
+	@Description: 分批创建记录
+	@param entities: 需要创建的记录
+	@param batchSize: 每批数量, 小于等于 0 时一次写入
+	@return error
+*/
+func (r *Repository[T]) BatchCreate(ctx context.Context, entities []*T, batchSize int) error {
+	if len(entities) == 0 {
+		return nil
+	}
+	if batchSize <= 0 {
+		batchSize = len(entities)
+	}
+	return TranslateError(r.conn(ctx).CreateInBatches(entities, batchSize).Error)
+}
+
+// GetByID 按主键查询, 不存在时返回 ErrNotFound
+func (r *Repository[T]) GetByID(ctx context.Context, id any) (*T, error) {
+	var entity T
+	err := r.conn(ctx).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).First(&entity).Error
+	if err != nil {
+		return nil, TranslateError(err)
+	}
+	return &entity, nil
+}
+
+/*
+Update
+
+	@Description: 按主键更新记录
+	@param entity: 需要更新的记录, 主键不能为空
+	@param fields: 需要更新的字段, 为空时只更新非零值字段; 指定字段时零值也会被更新
+	@return error: 记录不存在时为 ErrNotFound
+*/
+func (r *Repository[T]) Update(ctx context.Context, entity *T, fields ...string) error {
+	db := r.conn(ctx).Model(entity)
+	if len(fields) > 0 {
+		db = db.Select(fields)
+	}
+	res := db.Updates(entity)
+	if res.Error != nil {
+		return TranslateError(res.Error)
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+	// mysql 默认返回实际修改的行数, 值没有变化时同样为 0, 按主键确认记录是否存在
+	probe := *entity
+	return TranslateError(r.conn(ctx).Take(&probe).Error)
+}
+
+// Delete 按主键删除, 模型包含 gorm.DeletedAt 字段时为软删除; 记录不存在时返回 ErrNotFound
+func (r *Repository[T]) Delete(ctx context.Context, id any) error {
+	return r.delete(r.conn(ctx), id)
+}
+
+// ForceDelete 按主键物理删除, 忽略软删除
+func (r *Repository[T]) ForceDelete(ctx context.Context, id any) error {
+	return r.delete(r.conn(ctx).Unscoped(), id)
+}
+
+func (r *Repository[T]) delete(db *gorm.DB, id any) error {
+	res := db.Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Delete(new(T))
+	if res.Error != nil {
+		return TranslateError(res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Exists 是否存在符合条件的记录
+func (r *Repository[T]) Exists(ctx context.Context, scopes ...Scope) (bool, error) {
+	var found int
+	err := r.conn(ctx).Model(new(T)).Scopes(scopes...).Select("1").Limit(1).Scan(&found).Error
+	if err != nil {
+		return false, TranslateError(err)
+	}
+	return found == 1, nil
+}
+
+// Count 符合条件的记录数
+func (r *Repository[T]) Count(ctx context.Context, scopes ...Scope) (int64, error) {
+	var total int64
+	err := r.conn(ctx).Model(new(T)).Scopes(scopes...).Count(&total).Error
+	return total, TranslateError(err)
+}
+
+/*
+Paginate
+
+	@Description: 偏移量分页查询
+	@param page: 页码, 从 1 开始
+	@param size: 每页数量, 小于等于 0 时为 20
+	@param scopes: 查询条件, 排序也通过 scope 指定
+	@return *Page[T]
+	@return error
+*/
+func (r *Repository[T]) Paginate(ctx context.Context, page int, size int, scopes ...Scope) (*Page[T], error) {
+	if page < 1 {
+		page = 1
+	}
+	if size <= 0 {
+		size = 20
+	}
+	res := &Page[T]{Items: make([]T, 0), Page: page, Size: size}
+
+	db := r.conn(ctx)
+	if err := db.Model(new(T)).Scopes(scopes...).Count(&res.Total).Error; err != nil {
+		return nil, TranslateError(err)
+	}
+	if res.Total == 0 || int64((page-1)*size) >= res.Total {
+		return res, nil
+	}
+	if err := db.Scopes(scopes...).Offset((page - 1) * size).Limit(size).Find(&res.Items).Error; err != nil {
+		return nil, TranslateError(err)
+	}
+	return res, nil
+}
@@ -0,0 +1,81 @@
+package Base_PKG
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+)
+
+type repoUser struct {
+	ID        uint
+	Name      string
+	Age       int
+	DeletedAt gorm.DeletedAt
+}
+
+func repoDB(t *testing.T) *Repository[repoUser] {
+	t.Helper()
+	d := openSQLiteDB(t, nil, &repoUser{})
+	r := NewRepository[repoUser](d.Conn())
+	users := []*repoUser{{Name: "a", Age: 1}, {Name: "b", Age: 2}}
+	if err := r.BatchCreate(context.Background(), users, 1); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRepository_Update(t *testing.T) {
+	tests := []struct {
+		name    string
+		entity  repoUser
+		fields  []string
+		wantErr error
+		want    repoUser
+	}{
+		{name: "更新非零值字段", entity: repoUser{ID: 1, Name: "c"}, want: repoUser{ID: 1, Name: "c", Age: 1}},
+		{name: "指定字段时更新零值", entity: repoUser{ID: 1}, fields: []string{"age"}, want: repoUser{ID: 1, Name: "a"}},
+		{name: "值没有变化", entity: repoUser{ID: 1, Name: "a"}, want: repoUser{ID: 1, Name: "a", Age: 1}},
+		{name: "记录不存在", entity: repoUser{ID: 9, Name: "c"}, wantErr: ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := repoDB(t)
+			ctx := context.Background()
+			if err := r.Update(ctx, &tt.entity, tt.fields...); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Update() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			got, err := r.GetByID(ctx, tt.want.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != tt.want.Name || got.Age != tt.want.Age {
+				t.Errorf("GetByID() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepository_Delete(t *testing.T) {
+	r := repoDB(t)
+	ctx := context.Background()
+	if err := r.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetByID(ctx, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByID() deleted error = %v, want %v", err, ErrNotFound)
+	}
+	if err := r.Delete(ctx, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() again error = %v, want %v", err, ErrNotFound)
+	}
+	// 软删除的记录可以强制删除
+	if err := r.ForceDelete(ctx, 1); err != nil {
+		t.Errorf("ForceDelete() error = %v", err)
+	}
+	if n, err := r.Count(ctx); err != nil || n != 1 {
+		t.Errorf("Count() = %d, %v, want 1", n, err)
+	}
+}
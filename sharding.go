@@ -0,0 +1,443 @@
+package Base_PKG
+
+import (
+	"cmp"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"hash/crc32"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+	分表分库路由, 按分片键定位物理表, 无分片键的查询并发查询所有分片后合并排序
+*/
+
+var ErrShardNotFound = errors.New("分片键没有对应的分片")
+
+// ShardStrategy 分片策略, 计算分片键所在的分片序号
+type ShardStrategy interface {
+	Locate(key interface{}) (int, error)
+}
+
+// Shard 单个物理分片
+type Shard struct {
+	// 分片所在的数据库
+	DB *gorm.DB
+
+	// 物理表名
+	Table string
+}
+
+// ShardTopology
+// @Description: 逻辑表的分片拓扑, Shards 的下标即分片序号
+type ShardTopology struct {
+	LogicalTable string
+	Shards       []Shard
+}
+
+/*
+NewModTopology
+
+	@Description: 按序号创建 <逻辑表>_00 ~ <逻辑表>_NN 的分片, 连续的分片分布在同一个库中
+	@param logical: 逻辑表名
+	@param tables: 分表数量, 小于等于 0 时返回空拓扑, NewShardRouter 返回错误
+	@param dbs: 分库, 数量不能超过分表数量, 为空时使用 GetDBConn
+	@return ShardTopology
+*/
+func NewModTopology(logical string, tables int, dbs ...*gorm.DB) ShardTopology {
+	if tables <= 0 {
+		return ShardTopology{LogicalTable: logical}
+	}
+	dbs = shardDBs(dbs)
+	width := len(strconv.Itoa(tables - 1))
+	if width < 2 {
+		width = 2
+	}
+	top := ShardTopology{LogicalTable: logical, Shards: make([]Shard, tables)}
+	for i := 0; i < tables; i++ {
+		top.Shards[i] = Shard{
+			DB:    dbs[i*len(dbs)/tables],
+			Table: fmt.Sprintf("%s_%0*d", logical, width, i),
+		}
+	}
+	return top
+}
+
+/*
+NewMonthlyTopology
+
+	@Description: 按时间范围创建 <逻辑表>_YYYYMM 的分片, 与 DateRangeStrategy 配合使用
+	@param logical: 逻辑表名
+	@param ranges: 时间范围, 以范围起始月份命名物理表, 为空时返回空拓扑, NewShardRouter 返回错误
+	@param dbs: 分库, 数量不能超过范围数量, 为空时使用 GetDBConn
+	@return ShardTopology
+*/
+func NewMonthlyTopology(logical string, ranges []DateRange, dbs ...*gorm.DB) ShardTopology {
+	dbs = shardDBs(dbs)
+	top := ShardTopology{LogicalTable: logical, Shards: make([]Shard, len(ranges))}
+	for i, r := range ranges {
+		top.Shards[i] = Shard{
+			DB:    dbs[i*len(dbs)/len(ranges)],
+			Table: logical + "_" + r.Start.Format("200601"),
+		}
+	}
+	return top
+}
+
+// shardDBs 未传入分库时使用全局连接, 全局连接未初始化时分片的 DB 为 nil, 由 NewShardRouter 校验
+func shardDBs(dbs []*gorm.DB) []*gorm.DB {
+	if len(dbs) == 0 {
+		return []*gorm.DB{GetDBConn()}
+	}
+	return dbs
+}
+
+// ModHashStrategy 取模分片, 整数直接取模, 其他类型取 fnv 哈希后取模
+type ModHashStrategy struct {
+	Shards int
+}
+
+func (s ModHashStrategy) Locate(key interface{}) (int, error) {
+	if s.Shards <= 0 {
+		return 0, errors.New("分片数量必须大于 0")
+	}
+	rv := reflect.Indirect(reflect.ValueOf(key))
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := rv.Int()
+		if n < 0 {
+			n = -n
+		}
+		return int(uint64(n) % uint64(s.Shards)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(rv.Uint() % uint64(s.Shards)), nil
+	case reflect.Invalid:
+		return 0, ErrShardNotFound
+	}
+	return int(hashKey(key) % uint64(s.Shards)), nil
+}
+
+/*
+ConsistentHashStrategy
+
+	@Description: 一致性哈希分片, 调整分片数量时只有少量分片键需要迁移
+*/
+type ConsistentHashStrategy struct {
+	ring   []uint32
+	shards map[uint32]int
+}
+
+/*
+NewConsistentHashStrategy
+
+	@Description: 创建一致性哈希策略
+	@param shards: 分片数量
+	@param replicas: 每个分片的虚拟节点数, 小于等于 0 时为 100
+	@return *ConsistentHashStrategy
+*/
+func NewConsistentHashStrategy(shards int, replicas int) *ConsistentHashStrategy {
+	if replicas <= 0 {
+		replicas = 100
+	}
+	s := &ConsistentHashStrategy{shards: make(map[uint32]int, shards*replicas)}
+	for i := 0; i < shards; i++ {
+		for j := 0; j < replicas; j++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "#" + strconv.Itoa(j)))
+			if _, ok := s.shards[h]; ok {
+				continue
+			}
+			s.shards[h] = i
+			s.ring = append(s.ring, h)
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool { return s.ring[i] < s.ring[j] })
+	return s
+}
+
+func (s *ConsistentHashStrategy) Locate(key interface{}) (int, error) {
+	if len(s.ring) == 0 {
+		return 0, ErrShardNotFound
+	}
+	h := crc32.ChecksumIEEE([]byte(fmt.Sprint(reflect.Indirect(reflect.ValueOf(key)))))
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i] >= h })
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.shards[s.ring[i]], nil
+}
+
+// DateRange 时间范围 [Start, End)
+type DateRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+/*
+MonthlyRanges
+
+	@Description: 从 from 所在月份开始按自然月生成时间范围
+	@param from: 起始时间
+	@param months: 月份数量
+	@return []DateRange
+*/
+func MonthlyRanges(from time.Time, months int) []DateRange {
+	start := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, from.Location())
+	res := make([]DateRange, months)
+	for i := range res {
+		res[i] = DateRange{Start: start.AddDate(0, i, 0), End: start.AddDate(0, i+1, 0)}
+	}
+	return res
+}
+
+// DateRangeStrategy 按时间范围分片, 分片键为 time.Time
+type DateRangeStrategy struct {
+	Ranges []DateRange
+}
+
+func (s DateRangeStrategy) Locate(key interface{}) (int, error) {
+	var t time.Time
+	switch v := key.(type) {
+	case time.Time:
+		t = v
+	case *time.Time:
+		if v == nil {
+			return 0, ErrShardNotFound
+		}
+		t = *v
+	default:
+		return 0, fmt.Errorf("时间分片键类型错误: %T", key)
+	}
+	for i, r := range s.Ranges {
+		if !t.Before(r.Start) && t.Before(r.End) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrShardNotFound, t)
+}
+
+func hashKey(key interface{}) uint64 {
+	h := fnv.New64a()
+	_, _ = fmt.Fprint(h, reflect.Indirect(reflect.ValueOf(key)))
+	return h.Sum64()
+}
+
+/*
+ShardRouter
+
+	@Description: 逻辑表的分片路由
+*/
+type ShardRouter struct {
+	topology ShardTopology
+	strategy ShardStrategy
+}
+
+/*
+NewShardRouter
+
+	@Description: 创建分片路由
+	@param topology: 分片拓扑
+	@param strategy: 分片策略
+	@return *ShardRouter
+	@return error
+*/
+func NewShardRouter(topology ShardTopology, strategy ShardStrategy) (*ShardRouter, error) {
+	if len(topology.Shards) == 0 {
+		return nil, errors.New("分片拓扑不能为空")
+	}
+	for i, s := range topology.Shards {
+		if s.DB == nil || s.Table == "" {
+			return nil, fmt.Errorf("分片 %d 配置不完整", i)
+		}
+	}
+	return &ShardRouter{topology: topology, strategy: strategy}, nil
+}
+
+// Shards 全部分片
+func (r *ShardRouter) Shards() []Shard {
+	return r.topology.Shards
+}
+
+// Locate 分片键所在的分片
+func (r *ShardRouter) Locate(key interface{}) (Shard, error) {
+	i, err := r.strategy.Locate(key)
+	if err != nil {
+		return Shard{}, err
+	}
+	if i < 0 || i >= len(r.topology.Shards) {
+		return Shard{}, fmt.Errorf("%w: 分片序号 %d 超出范围", ErrShardNotFound, i)
+	}
+	return r.topology.Shards[i], nil
+}
+
+/*
+DB
+
+	@Description: 分片键所在分片的 gorm 对象, 表名已替换为物理表
+	@param key: 分片键
+	@return *gorm.DB
+	@return error
+*/
+func (r *ShardRouter) DB(ctx context.Context, key interface{}) (*gorm.DB, error) {
+	s, err := r.Locate(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.DB.WithContext(ctx).Table(s.Table), nil
+}
+
+// GatherOptions
+// @Description: 全分片查询配置
+type GatherOptions struct {
+	// 合并后的排序, 同时下推到每个分片
+	Sort []SortField
+
+	// 合并后保留的数量, 同时下推到每个分片, 0 表示不限制
+	Limit int
+
+	// 并发查询的分片数, 默认 8
+	Concurrency int
+}
+
+/*
+ScatterGather
+
+	@Description: 并发查询所有分片, 合并结果后排序截断. 不支持 Offset, 翻页请使用排序列作为条件
+	@param r: 分片路由
+	@param query: 每个分片上执行的查询条件, db 的表名已替换为物理表
+	@param opts: 查询配置
+	@return []T
+	@return error
+*/
+func ScatterGather[T any](ctx context.Context, r *ShardRouter, query Scope, opts GatherOptions) ([]T, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 8
+	}
+	shards := r.Shards()
+	fields, err := sortFields[T](shards[0].DB, opts.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg    sync.WaitGroup
+		sem   = make(chan struct{}, opts.Concurrency)
+		parts = make([][]T, len(shards))
+		errs  = make([]error, len(shards))
+	)
+	for i, s := range shards {
+		wg.Add(1)
+		go func(i int, s Shard) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			db := s.DB.WithContext(ctx).Table(s.Table)
+			if query != nil {
+				db = query(db)
+			}
+			for _, f := range opts.Sort {
+				db = db.Order(clause.OrderByColumn{Column: clause.Column{Name: f.Column}, Desc: f.Desc})
+			}
+			if opts.Limit > 0 {
+				db = db.Limit(opts.Limit)
+			}
+			var part []T
+			if err := db.Find(&part).Error; err != nil {
+				errs[i] = fmt.Errorf("shard %s: %w", s.Table, TranslateError(err))
+				return
+			}
+			parts[i] = part
+		}(i, s)
+	}
+	wg.Wait()
+	if err = errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	res := make([]T, 0)
+	for _, part := range parts {
+		res = append(res, part...)
+	}
+	if len(opts.Sort) > 0 {
+		sort.SliceStable(res, func(i, j int) bool {
+			vi, vj := reflect.ValueOf(&res[i]).Elem(), reflect.ValueOf(&res[j]).Elem()
+			for k, f := range fields {
+				a, _ := f.ValueOf(ctx, vi)
+				b, _ := f.ValueOf(ctx, vj)
+				if c := compareValues(a, b); c != 0 {
+					return (c < 0) != opts.Sort[k].Desc
+				}
+			}
+			return false
+		})
+	}
+	if opts.Limit > 0 && len(res) > opts.Limit {
+		res = res[:opts.Limit]
+	}
+	return res, nil
+}
+
+// compareValues 比较排序列的值, nil 和 NULL 排在最前
+func compareValues(a, b interface{}) int {
+	av, bv := sortValue(a), sortValue(b)
+	switch {
+	case !av.IsValid() && !bv.IsValid():
+		return 0
+	case !av.IsValid():
+		return -1
+	case !bv.IsValid():
+		return 1
+	}
+
+	if at, ok := av.Interface().(time.Time); ok {
+		if bt, ok := bv.Interface().(time.Time); ok {
+			return at.Compare(bt)
+		}
+	}
+	switch av.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(av.Int(), bv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cmp.Compare(av.Uint(), bv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(av.Float(), bv.Float())
+	case reflect.String:
+		return cmp.Compare(av.String(), bv.String())
+	case reflect.Bool:
+		return cmp.Compare(boolInt(av.Bool()), boolInt(bv.Bool()))
+	}
+	return cmp.Compare(fmt.Sprint(av.Interface()), fmt.Sprint(bv.Interface()))
+}
+
+// sortValue 解引用指针并取出 sql.NullXxx、gorm.DeletedAt 等 driver.Valuer 的值, nil 和 NULL 返回无效值
+func sortValue(v interface{}) reflect.Value {
+	rv := reflect.ValueOf(v)
+	if valuer, ok := v.(driver.Valuer); ok && (rv.Kind() != reflect.Pointer || !rv.IsNil()) {
+		if dv, err := valuer.Value(); err == nil {
+			rv = reflect.ValueOf(dv)
+		}
+	}
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return reflect.Value{}
+		}
+		rv = rv.Elem()
+	}
+	return rv
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
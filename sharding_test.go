@@ -0,0 +1,159 @@
+package Base_PKG
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gorm.io/gorm"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestShardStrategy_Locate(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	ranges := MonthlyRanges(time.Date(2024, 11, 15, 0, 0, 0, 0, loc), 3)
+
+	tests := []struct {
+		name     string
+		strategy ShardStrategy
+		key      interface{}
+		want     int
+		wantErr  error
+	}{
+		{name: "取模 整数", strategy: ModHashStrategy{Shards: 64}, key: int64(130), want: 2},
+		{name: "取模 负数", strategy: ModHashStrategy{Shards: 64}, key: -130, want: 2},
+		{name: "取模 指针", strategy: ModHashStrategy{Shards: 64}, key: func() *uint { v := uint(65); return &v }(), want: 1},
+		{name: "时间范围 跨年", strategy: DateRangeStrategy{Ranges: ranges}, key: time.Date(2025, 1, 31, 23, 59, 59, 0, loc), want: 2},
+		{name: "时间范围 起始边界", strategy: DateRangeStrategy{Ranges: ranges}, key: time.Date(2024, 12, 1, 0, 0, 0, 0, loc), want: 1},
+		{name: "时间范围 超出", strategy: DateRangeStrategy{Ranges: ranges}, key: time.Date(2025, 2, 1, 0, 0, 0, 0, loc), wantErr: ErrShardNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.strategy.Locate(tt.key)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Locate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("Locate() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsistentHashStrategy_Locate(t *testing.T) {
+	before := NewConsistentHashStrategy(8, 0)
+	after := NewConsistentHashStrategy(9, 0)
+
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		a, _ := before.Locate(i)
+		b, _ := after.Locate(i)
+		if a != b {
+			moved++
+			if b != 8 {
+				t.Fatalf("key %d moved from shard %d to existing shard %d", i, a, b)
+			}
+		}
+	}
+	// 新增一个分片时大约 1/9 的分片键迁移
+	if moved == 0 || moved > 2500 {
+		t.Errorf("moved keys = %d, want about 1111", moved)
+	}
+}
+
+func TestNewModTopology(t *testing.T) {
+	top := NewModTopology("order", 64, nil, nil)
+	if got := top.Shards[0].Table; got != "order_00" {
+		t.Errorf("first table = %s", got)
+	}
+	if got := top.Shards[63].Table; got != "order_63" {
+		t.Errorf("last table = %s", got)
+	}
+}
+
+func TestTopology_Empty(t *testing.T) {
+	db := &gorm.DB{}
+	prev := SetDBConn(db)
+	defer SetDBConn(prev)
+
+	tests := []struct {
+		name      string
+		top       ShardTopology
+		wantDB    *gorm.DB
+		wantError bool
+	}{
+		{name: "未传入分库使用全局连接", top: NewModTopology("order", 4), wantDB: db},
+		{name: "按月未传入分库使用全局连接", top: NewMonthlyTopology("log", []DateRange{{Start: time.Now(), End: time.Now().AddDate(0, 1, 0)}}), wantDB: db},
+		{name: "分表数量为 0", top: NewModTopology("order", 0, db), wantError: true},
+		{name: "分表数量为负数", top: NewModTopology("order", -1), wantError: true},
+		{name: "没有时间范围", top: NewMonthlyTopology("log", nil), wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewShardRouter(tt.top, ModHashStrategy{Shards: len(tt.top.Shards)})
+			if (err != nil) != tt.wantError {
+				t.Fatalf("NewShardRouter() error = %v, wantError %v", err, tt.wantError)
+			}
+			for _, s := range tt.top.Shards {
+				if s.DB != tt.wantDB {
+					t.Errorf("shard %s db = %p, want %p", s.Table, s.DB, tt.wantDB)
+				}
+			}
+		})
+	}
+}
+
+type gatherItem struct {
+	ID    uint
+	Score *int
+	Seen  sql.NullInt64
+}
+
+func TestScatterGather_NullableSort(t *testing.T) {
+	db := openSQLiteDB(t, nil).Conn()
+	top := NewModTopology("gather_item", 2, db)
+	score := func(v int) *int { return &v }
+	seen := func(v int64) sql.NullInt64 { return sql.NullInt64{Int64: v, Valid: true} }
+	rows := [][]gatherItem{
+		{{ID: 1, Score: score(5), Seen: seen(10)}, {ID: 2}},
+		{{ID: 3, Score: score(1), Seen: seen(2)}, {ID: 4, Score: score(5)}},
+	}
+	for i, s := range top.Shards {
+		if err := db.Table(s.Table).AutoMigrate(&gatherItem{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Table(s.Table).Create(rows[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := NewShardRouter(top, ModHashStrategy{Shards: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		sort []SortField
+		want []uint
+	}{
+		{name: "指针列 NULL 在前", sort: []SortField{{Column: "score"}, {Column: "id"}}, want: []uint{2, 3, 1, 4}},
+		{name: "指针列倒序", sort: []SortField{{Column: "score", Desc: true}, {Column: "id"}}, want: []uint{1, 4, 3, 2}},
+		{name: "sql.NullInt64 按值比较", sort: []SortField{{Column: "seen"}, {Column: "id"}}, want: []uint{2, 4, 3, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := ScatterGather[gatherItem](context.Background(), r, nil, GatherOptions{Sort: tt.sort})
+			if err != nil {
+				t.Fatalf("ScatterGather() error = %v", err)
+			}
+			ids := make([]uint, 0, len(items))
+			for _, item := range items {
+				ids = append(ids, item.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("ScatterGather() ids = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
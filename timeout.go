@@ -0,0 +1,360 @@
+package Base_PKG
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	按操作类型的默认语句超时, 上下文取消时通过 KILL QUERY 终止 mysql 上正在执行的语句.
+	只作用于通过 Ctx/DB.Ctx 获取的连接, 直接使用 Conn()/GetDBConn() 的语句只计入查询预算
+*/
+
+const (
+	// timeoutRestoreKey 语句结束后取消超时、释放固定的连接并恢复原上下文和连接池的方法
+	timeoutRestoreKey = "base_pkg:timeout_restore"
+
+	// 连接 id 缓存上限, 超过后清空
+	maxCachedConnIDs = 4096
+)
+
+// ErrQueryBudgetExceeded 请求的查询次数超过预算
+var ErrQueryBudgetExceeded = errors.New("查询次数超过预算")
+
+// OpClass 语句的操作类型
+type OpClass int
+
+const (
+	OpRead OpClass = iota
+	OpWrite
+	OpDDL
+)
+
+// TimeoutOptions
+// @Description: Ctx/DB.Ctx 获取的连接上各操作类型的默认超时, 只在上下文没有截止时间时生效, 0 表示不限制
+type TimeoutOptions struct {
+	Read  time.Duration
+	Write time.Duration
+	DDL   time.Duration
+
+	// 上下文取消或超时时在 mysql 上执行 KILL QUERY, 事务中的语句和 Row/Rows 查询不执行.
+	// 开启后每条语句独占一个连接直到回调结束, 连接第一次使用时多一次 SELECT CONNECTION_ID() 查询,
+	// 高并发时连接池占用增加, 不需要时设置为 false 只由驱动中断等待
+	KillOnCancel bool
+}
+
+/*
+DefaultTimeoutOptions
+
+	@Description: 默认超时: 读 10s, 写 30s, DDL 不限制, 取消或超时时 KILL QUERY
+	@return TimeoutOptions
+*/
+func DefaultTimeoutOptions() TimeoutOptions {
+	return TimeoutOptions{
+		Read:         10 * time.Second,
+		Write:        30 * time.Second,
+		KillOnCancel: true,
+	}
+}
+
+func (o TimeoutOptions) timeout(class OpClass) time.Duration {
+	switch class {
+	case OpRead:
+		return o.Read
+	case OpWrite:
+		return o.Write
+	default:
+		return o.DDL
+	}
+}
+
+type timeoutCtxKey struct{}
+
+/*
+Ctx
+
+	@Description: 以上下文为先的连接获取方法, 上下文中存在 WithTx 事务时返回该事务
+	@param ctx: 上下文, 没有截止时间时按操作类型使用默认超时, 取消或超时时按 KillOnCancel 终止语句
+	@return *gorm.DB
+*/
+func Ctx(ctx context.Context) *gorm.DB {
+	ctx = context.WithValue(ctx, timeoutCtxKey{}, true)
+	if tx := TxFromContext(ctx); tx != nil {
+		return tx.WithContext(ctx)
+	}
+	return GetDBConn().WithContext(ctx)
+}
+
+// Ctx 以上下文为先的连接获取方法, 同包级 Ctx
+func (d *DB) Ctx(ctx context.Context) *gorm.DB {
+	ctx = context.WithValue(ctx, timeoutCtxKey{}, true)
+	if tx := TxFromContext(ctx); tx != nil {
+		return tx.WithContext(ctx)
+	}
+	return d.Conn().WithContext(ctx)
+}
+
+/*
+TimeoutPlugin
+
+	@Description: 语句超时插件, OpenDB 按 DBOptions.Timeouts 自动注册
+*/
+type TimeoutPlugin struct {
+	opts TimeoutOptions
+
+	// 驱动连接到 mysql 连接 id 的缓存
+	connIDs sync.Map
+	cached  atomic.Int64
+}
+
+func NewTimeoutPlugin(opts TimeoutOptions) *TimeoutPlugin {
+	return &TimeoutPlugin{opts: opts}
+}
+
+// Name 实现 gorm.Plugin
+func (p *TimeoutPlugin) Name() string {
+	return "base_pkg:timeout"
+}
+
+// Initialize 实现 gorm.Plugin, 读写分离的连接池选择在所有回调之前执行, 这里固定的是选中的连接池.
+// 释放连接和取消超时在所有回调之后执行, 之后注册的插件(审计、缓存等)仍使用有效的上下文和连接
+func (p *TimeoutPlugin) Initialize(db *gorm.DB) error {
+	kill := p.opts.KillOnCancel && db.Dialector.Name() == "mysql"
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Query().Before("gorm:query").Register("base_pkg:timeout_before", p.before(OpRead, kill)),
+		cb.Create().Before("gorm:create").Register("base_pkg:timeout_before", p.before(OpWrite, kill)),
+		cb.Update().Before("gorm:update").Register("base_pkg:timeout_before", p.before(OpWrite, kill)),
+		cb.Delete().Before("gorm:delete").Register("base_pkg:timeout_before", p.before(OpWrite, kill)),
+		cb.Raw().Before("gorm:raw").Register("base_pkg:timeout_before", p.before(-1, kill)),
+
+		// Row/Rows 返回后仍需使用上下文, 只计入预算不设置超时
+		cb.Row().Before("gorm:row").Register("base_pkg:timeout_budget", chargeCallback),
+
+		cb.Query().After("*").Register("base_pkg:timeout_after", p.after),
+		cb.Create().After("*").Register("base_pkg:timeout_after", p.after),
+		cb.Update().After("*").Register("base_pkg:timeout_after", p.after),
+		cb.Delete().After("*").Register("base_pkg:timeout_after", p.after),
+		cb.Raw().After("*").Register("base_pkg:timeout_after", p.after),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClassifySQL 根据语句判断操作类型
+func ClassifySQL(sql string) OpClass {
+	word := strings.TrimLeft(sql, " \t\r\n(")
+	if i := strings.IndexAny(word, " \t\r\n("); i > 0 {
+		word = word[:i]
+	}
+	switch strings.ToUpper(word) {
+	case "SELECT", "SHOW", "EXPLAIN", "DESC", "DESCRIBE", "WITH":
+		return OpRead
+	case "CREATE", "ALTER", "DROP", "TRUNCATE", "RENAME":
+		return OpDDL
+	default:
+		return OpWrite
+	}
+}
+
+// before 通过 Ctx 获取的连接设置默认超时, 需要时固定连接以便取消时 KILL QUERY. class 为 -1 时根据 SQL 判断
+func (p *TimeoutPlugin) before(class OpClass, kill bool) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		stmt := db.Statement
+		if db.Error != nil || db.DryRun || stmt.Context == nil {
+			return
+		}
+		if err := chargeBudget(stmt.Context); err != nil {
+			_ = db.AddError(err)
+			return
+		}
+		if viaCtx, _ := stmt.Context.Value(timeoutCtxKey{}).(bool); !viaCtx {
+			return
+		}
+		op := class
+		if op < 0 {
+			op = ClassifySQL(stmt.SQL.String())
+		}
+
+		// 链式调用会复用 Statement, 结束后需要恢复原上下文和连接池
+		origCtx, origPool := stmt.Context, stmt.ConnPool
+		var restores []func()
+		defer func() {
+			if len(restores) == 0 {
+				return
+			}
+			db.InstanceSet(timeoutRestoreKey, func() {
+				for i := len(restores) - 1; i >= 0; i-- {
+					restores[i]()
+				}
+				stmt.Context = origCtx
+			})
+		}()
+
+		ctx := stmt.Context
+		if _, ok := ctx.Deadline(); !ok {
+			if d := p.opts.timeout(op); d > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, d)
+				stmt.Context = ctx
+				restores = append(restores, cancel)
+			}
+		}
+		if !kill || ctx.Done() == nil {
+			return
+		}
+		pool, ok := stmt.ConnPool.(*sql.DB)
+		if !ok {
+			return
+		}
+		conn, id, err := p.pin(ctx, pool)
+		if err != nil {
+			// 无法获取连接 id 时不影响语句执行
+			zap.L().Debug("timeout plugin pin conn failed", zap.Error(err))
+			return
+		}
+		done := make(chan struct{})
+		stop := context.AfterFunc(ctx, func() {
+			defer close(done)
+			killCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			if _, err := pool.ExecContext(killCtx, fmt.Sprintf("KILL QUERY %d", id)); err != nil {
+				zap.L().Warn("kill query found error", zap.Int64("connectionId", id), zap.Error(err))
+			}
+		})
+		stmt.ConnPool = conn
+		restores = append(restores, func() {
+			stmt.ConnPool = origPool
+			releaseConn(conn, stop, done)
+		})
+	}
+}
+
+// releaseConn 释放固定的连接. KILL QUERY 已经开始时等待其结束并丢弃连接,
+// 避免连接回到连接池后 KILL 作用在其他请求的语句上
+func releaseConn(conn *sql.Conn, stop func() bool, done <-chan struct{}) {
+	if stop() {
+		_ = conn.Close()
+		return
+	}
+	<-done
+	_ = conn.Raw(func(interface{}) error {
+		return driver.ErrBadConn
+	})
+	_ = conn.Close()
+}
+
+// pin 从连接池取出一个连接并获取其 mysql 连接 id
+func (p *TimeoutPlugin) pin(ctx context.Context, pool *sql.DB) (*sql.Conn, int64, error) {
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	var key interface{}
+	_ = conn.Raw(func(driverConn interface{}) error {
+		key = driverConn
+		return nil
+	})
+	if v, ok := p.connIDs.Load(key); ok {
+		return conn, v.(int64), nil
+	}
+	var id int64
+	if err = conn.QueryRowContext(ctx, "SELECT CONNECTION_ID()").Scan(&id); err != nil {
+		_ = conn.Close()
+		return nil, 0, err
+	}
+	if p.cached.Add(1) > maxCachedConnIDs {
+		p.connIDs.Range(func(k, _ interface{}) bool {
+			p.connIDs.Delete(k)
+			return true
+		})
+		p.cached.Store(1)
+	}
+	p.connIDs.Store(key, id)
+	return conn, id, nil
+}
+
+// after 释放固定的连接并取消超时, 执行后置空, 复用的 Statement 不会再次执行
+func (p *TimeoutPlugin) after(db *gorm.DB) {
+	v, _ := db.InstanceGet(timeoutRestoreKey)
+	if restore, ok := v.(func()); ok {
+		db.InstanceSet(timeoutRestoreKey, nil)
+		restore()
+	}
+}
+
+type budgetCtxKey struct{}
+
+// queryBudget 单个请求的查询预算
+type queryBudget struct {
+	max  int64
+	used atomic.Int64
+}
+
+// chargeCallback 只计入预算的回调
+func chargeCallback(db *gorm.DB) {
+	if db.Error != nil || db.DryRun || db.Statement.Context == nil {
+		return
+	}
+	if err := chargeBudget(db.Statement.Context); err != nil {
+		_ = db.AddError(err)
+	}
+}
+
+func chargeBudget(ctx context.Context) error {
+	b, ok := ctx.Value(budgetCtxKey{}).(*queryBudget)
+	if !ok || b.max <= 0 {
+		return nil
+	}
+	if b.used.Add(1) > b.max {
+		return ErrQueryBudgetExceeded
+	}
+	return nil
+}
+
+// QueryBudgetOptions
+// @Description: 单个 http 请求的数据库预算
+type QueryBudgetOptions struct {
+	// 请求内所有查询共享的截止时间, 0 表示不限制
+	Timeout time.Duration
+
+	// 最大查询次数, 超过后的语句返回 ErrQueryBudgetExceeded, 0 表示不限制
+	MaxQueries int
+}
+
+/*
+QueryBudget
+
+	@Description: http 中间件, 为请求上下文设置数据库预算, 处理方法需要通过 Ctx(r.Context()) 访问数据库
+	@param opts: 预算配置
+	@return func(http.Handler) http.Handler
+*/
+func QueryBudget(opts QueryBudgetOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if opts.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+				defer cancel()
+			}
+			if opts.MaxQueries > 0 {
+				ctx = context.WithValue(ctx, budgetCtxKey{}, &queryBudget{max: int64(opts.MaxQueries)})
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
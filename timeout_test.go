@@ -0,0 +1,190 @@
+package Base_PKG
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClassifySQL(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want OpClass
+	}{
+		{sql: "SELECT 1", want: OpRead},
+		{sql: "  (select * from t) union (select * from s)", want: OpRead},
+		{sql: "WITH x AS (SELECT 1) SELECT * FROM x", want: OpRead},
+		{sql: "show tables", want: OpRead},
+		{sql: "INSERT INTO t VALUES (1)", want: OpWrite},
+		{sql: "update t set a = 1", want: OpWrite},
+		{sql: "ALTER TABLE t ADD COLUMN a int", want: OpDDL},
+		{sql: "\ncreate index i on t (a)", want: OpDDL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			if got := ClassifySQL(tt.sql); got != tt.want {
+				t.Errorf("ClassifySQL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutOptions_timeout(t *testing.T) {
+	opts := TimeoutOptions{Read: time.Second, Write: 2 * time.Second, DDL: 3 * time.Second}
+	for class, want := range map[OpClass]time.Duration{OpRead: time.Second, OpWrite: 2 * time.Second, OpDDL: 3 * time.Second} {
+		if got := opts.timeout(class); got != want {
+			t.Errorf("timeout(%v) = %v, want %v", class, got, want)
+		}
+	}
+}
+
+func TestTimeoutPlugin_Callbacks(t *testing.T) {
+	d := openSQLiteDB(t, nil, &testItem{})
+	db := d.Ctx(context.Background())
+
+	// OpenDB 之后注册的回调需要看到带默认超时且未取消的上下文
+	var probes []error
+	probe := func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if _, ok := ctx.Deadline(); !ok {
+			probes = append(probes, errors.New("no deadline"))
+			return
+		}
+		probes = append(probes, ctx.Err())
+	}
+	cb := db.Callback()
+	if err := cb.Create().After("gorm:create").Register("test:probe", probe); err != nil {
+		t.Fatal(err)
+	}
+	if err := cb.Query().After("gorm:query").Register("test:probe", probe); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Create(&testItem{Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	q := db.Model(&testItem{}).Where("name = ?", "a")
+	var n int64
+	if err := q.Count(&n).Error; err != nil || n != 1 {
+		t.Fatalf("Count() = %d, %v", n, err)
+	}
+	// 复用的 Statement 恢复为原上下文
+	if _, ok := q.Statement.Context.Deadline(); ok {
+		t.Errorf("statement context not restored")
+	}
+	if v, _ := q.InstanceGet(timeoutRestoreKey); v != nil {
+		t.Errorf("restore state not cleared after statement")
+	}
+	var items []testItem
+	if err := q.Find(&items).Error; err != nil || len(items) != 1 {
+		t.Fatalf("Find() = %v, %v", items, err)
+	}
+	if len(probes) != 3 {
+		t.Fatalf("probes = %d, want 3", len(probes))
+	}
+	for i, err := range probes {
+		if err != nil {
+			t.Errorf("probe %d: %v", i, err)
+		}
+	}
+
+	// 直接使用 Conn() 的语句不设置默认超时
+	probes = nil
+	if err := d.Conn().Create(&testItem{Name: "b"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(probes) != 1 || probes[0] == nil {
+		t.Errorf("Conn() probes = %v, want no deadline", probes)
+	}
+}
+
+func TestQueryBudget(t *testing.T) {
+	db := openSQLiteDB(t, nil, &testItem{})
+
+	tests := []struct {
+		name    string
+		opts    QueryBudgetOptions
+		queries int
+		wantErr []error
+	}{
+		{name: "未超出", opts: QueryBudgetOptions{MaxQueries: 2}, queries: 2, wantErr: []error{nil, nil}},
+		{name: "超出次数", opts: QueryBudgetOptions{MaxQueries: 2}, queries: 3, wantErr: []error{nil, nil, ErrQueryBudgetExceeded}},
+		{name: "不限制", opts: QueryBudgetOptions{}, queries: 3, wantErr: []error{nil, nil, nil}},
+		{name: "请求截止时间", opts: QueryBudgetOptions{Timeout: time.Minute}, queries: 1, wantErr: []error{nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs []error
+			var deadline bool
+			h := QueryBudget(tt.opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, deadline = r.Context().Deadline()
+				for i := 0; i < tt.queries; i++ {
+					var items []testItem
+					// 交替使用 Find 和 Row 回调
+					if i%2 == 0 {
+						errs = append(errs, db.Ctx(r.Context()).Find(&items).Error)
+					} else {
+						var n int
+						errs = append(errs, db.Ctx(r.Context()).Raw("SELECT COUNT(*) FROM test_item").Row().Scan(&n))
+					}
+				}
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			if deadline != (tt.opts.Timeout > 0) {
+				t.Errorf("deadline = %v", deadline)
+			}
+			for i, err := range errs {
+				if !errors.Is(err, tt.wantErr[i]) {
+					t.Errorf("query %d error = %v, want %v", i, err, tt.wantErr[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReleaseConn(t *testing.T) {
+	tests := []struct {
+		name     string
+		killing  bool
+		wantIdle int
+	}{
+		{name: "未触发 KILL 连接放回连接池", killing: false, wantIdle: 1},
+		{name: "KILL 执行中等待结束并丢弃连接", killing: true, wantIdle: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := sql.Open("basepkg_fake", "release")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pool.Close()
+			conn, err := pool.Conn(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan struct{})
+			var killed atomic.Bool
+			if tt.killing {
+				go func() {
+					time.Sleep(20 * time.Millisecond)
+					killed.Store(true)
+					close(done)
+				}()
+			}
+			releaseConn(conn, func() bool { return !tt.killing }, done)
+			if tt.killing && !killed.Load() {
+				t.Errorf("released before kill finished")
+			}
+			if got := pool.Stats().Idle; got != tt.wantIdle {
+				t.Errorf("idle = %d, want %d", got, tt.wantIdle)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package Base_PKG
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+/*
+	gorm 查询链路追踪, Tracer 接口可适配 OpenTelemetry 等实现
+*/
+
+const (
+	tracingSpanKey = "base_pkg:tracing_span"
+	// 创建 Span 前的上下文, 操作结束后恢复
+	tracingCtxKey = "base_pkg:tracing_ctx"
+)
+
+// Span 链路中的一个操作
+type Span interface {
+	SetAttribute(key string, value interface{})
+
+	RecordError(err error)
+
+	End()
+}
+
+// Tracer 创建 Span, ctx 为 WithContext 传入的上下文
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+/*
+TracingPlugin
+
+	@Description: 为每个 gorm 操作创建 Span 的插件, 通过 db.Use 注册
+*/
+type TracingPlugin struct {
+	tracer Tracer
+}
+
+/*
+NewTracingPlugin
+
+	@Description: 创建链路追踪插件
+	@param tracer: Span 的创建者
+	@return *TracingPlugin
+*/
+func NewTracingPlugin(tracer Tracer) *TracingPlugin {
+	return &TracingPlugin{tracer: tracer}
+}
+
+// Name 实现 gorm.Plugin
+func (p *TracingPlugin) Name() string {
+	return "base_pkg:tracing"
+}
+
+// Initialize 实现 gorm.Plugin, 注册各操作的回调
+func (p *TracingPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("base_pkg:tracing_before", p.before("create")),
+		cb.Create().After("gorm:create").Register("base_pkg:tracing_after", p.after),
+		cb.Query().Before("gorm:query").Register("base_pkg:tracing_before", p.before("query")),
+		// Preload 的查询在 gorm:preload 中执行, 需要在其之后恢复上下文
+		cb.Query().After("gorm:preload").Register("base_pkg:tracing_after", p.after),
+		cb.Update().Before("gorm:update").Register("base_pkg:tracing_before", p.before("update")),
+		cb.Update().After("gorm:update").Register("base_pkg:tracing_after", p.after),
+		cb.Delete().Before("gorm:delete").Register("base_pkg:tracing_before", p.before("delete")),
+		cb.Delete().After("gorm:delete").Register("base_pkg:tracing_after", p.after),
+		cb.Row().Before("gorm:row").Register("base_pkg:tracing_before", p.before("row")),
+		cb.Row().After("gorm:row").Register("base_pkg:tracing_after", p.after),
+		cb.Raw().Before("gorm:raw").Register("base_pkg:tracing_before", p.before("raw")),
+		cb.Raw().After("gorm:raw").Register("base_pkg:tracing_after", p.after),
+	} {
+		if err != nil {
+			return fmt.Errorf("register tracing callback error: %w", err)
+		}
+	}
+	return nil
+}
+
+func (p *TracingPlugin) before(op string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		spanCtx, span := p.tracer.Start(ctx, "gorm."+op)
+		span.SetAttribute("db.system", db.Dialector.Name())
+		span.SetAttribute("db.operation", op)
+		db.InstanceSet(tracingSpanKey, span)
+		db.InstanceSet(tracingCtxKey, db.Statement.Context)
+		// 之后的回调 (如 Preload 的查询) 以该 Span 为父节点
+		db.Statement.Context = spanCtx
+	}
+}
+
+func (p *TracingPlugin) after(db *gorm.DB) {
+	v, ok := db.InstanceGet(tracingSpanKey)
+	if !ok {
+		return
+	}
+	span := v.(Span)
+	defer span.End()
+	if ctx, ok := db.InstanceGet(tracingCtxKey); ok {
+		db.Statement.Context, _ = ctx.(context.Context)
+	}
+
+	// 只记录带占位符的 sql, 参数不进入链路数据
+	span.SetAttribute("db.sql.table", db.Statement.Table)
+	span.SetAttribute("db.statement", db.Statement.SQL.String())
+	span.SetAttribute("db.rows_affected", db.RowsAffected)
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+	}
+}
+
+// RecordedSpan 内存中记录的 Span
+type RecordedSpan struct {
+	Name string
+
+	// 父 Span 的名称, 没有父 Span 时为空
+	Parent string
+
+	Attributes map[string]interface{}
+	Errors     []error
+	StartAt    time.Time
+	EndAt      time.Time
+
+	// 所属的 RecordingTracer
+	tracer *RecordingTracer
+}
+
+func (s *RecordedSpan) SetAttribute(key string, value interface{}) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+func (s *RecordedSpan) RecordError(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.Errors = append(s.Errors, err)
+}
+
+func (s *RecordedSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.EndAt = time.Now()
+}
+
+/*
+RecordingTracer
+
+	@Description: 在内存中记录 Span 的 Tracer, 用于测试
+*/
+type RecordingTracer struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+}
+
+func NewRecordingTracer() *RecordingTracer {
+	return &RecordingTracer{}
+}
+
+// recordedSpanKey 上下文中当前 RecordedSpan 的 key
+type recordedSpanKey struct{}
+
+// Start 实现 Tracer, 返回的上下文携带新建的 Span
+func (t *RecordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &RecordedSpan{Name: name, Attributes: make(map[string]interface{}), StartAt: time.Now(), tracer: t}
+	if parent, ok := ctx.Value(recordedSpanKey{}).(*RecordedSpan); ok {
+		span.Parent = parent.Name
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+// Spans 已记录的 Span 副本
+func (t *RecordingTracer) Spans() []RecordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make([]RecordedSpan, 0, len(t.spans))
+	for _, s := range t.spans {
+		c := *s
+		c.Attributes = make(map[string]interface{}, len(s.Attributes))
+		for k, v := range s.Attributes {
+			c.Attributes[k] = v
+		}
+		c.Errors = append([]error(nil), s.Errors...)
+		res = append(res, c)
+	}
+	return res
+}
+
+// Reset 清空已记录的 Span
+func (t *RecordingTracer) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = nil
+}
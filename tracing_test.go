@@ -0,0 +1,128 @@
+package Base_PKG
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+)
+
+type traceUser struct {
+	ID     uint
+	Name   string
+	Orders []traceOrder `gorm:"foreignKey:UserID"`
+}
+
+type traceOrder struct {
+	ID     uint
+	UserID uint
+}
+
+func tracingDB(t *testing.T) (*DB, *RecordingTracer) {
+	t.Helper()
+	d := openSQLiteDB(t, nil, &traceUser{}, &traceOrder{})
+	db := d.Conn()
+	if err := db.Create(&traceUser{Name: "a", Orders: []traceOrder{{}}}).Error; err != nil {
+		t.Fatal(err)
+	}
+	tracer := NewRecordingTracer()
+	if err := db.Use(NewTracingPlugin(tracer)); err != nil {
+		t.Fatal(err)
+	}
+	return d, tracer
+}
+
+func TestTracingPlugin(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     func(ctx context.Context, d *DB) error
+		wantSpans []RecordedSpan
+		wantErr   bool
+	}{
+		{
+			name: "父 Span 来自 WithContext",
+			query: func(ctx context.Context, d *DB) error {
+				return d.Conn().WithContext(ctx).Where("name = ?", "a").Find(&[]traceUser{}).Error
+			},
+			wantSpans: []RecordedSpan{{Name: "gorm.query", Parent: "request"}},
+		},
+		{
+			name: "Preload 的查询以主查询为父节点",
+			query: func(ctx context.Context, d *DB) error {
+				return d.Conn().WithContext(ctx).Preload("Orders").Find(&[]traceUser{}).Error
+			},
+			wantSpans: []RecordedSpan{{Name: "gorm.query", Parent: "request"}, {Name: "gorm.query", Parent: "gorm.query"}},
+		},
+		{
+			name: "First 的 Preload 查询以主查询为父节点",
+			query: func(ctx context.Context, d *DB) error {
+				return d.Conn().WithContext(ctx).Preload("Orders").First(&traceUser{}, "name = ?", "a").Error
+			},
+			wantSpans: []RecordedSpan{{Name: "gorm.query", Parent: "request"}, {Name: "gorm.query", Parent: "gorm.query"}},
+		},
+		{
+			name: "记录错误",
+			query: func(ctx context.Context, d *DB) error {
+				return d.Conn().WithContext(ctx).Exec("SELECT * FROM missing").Error
+			},
+			wantSpans: []RecordedSpan{{Name: "gorm.raw", Parent: "request"}},
+			wantErr:   true,
+		},
+		{
+			name: "未找到记录不是错误",
+			query: func(ctx context.Context, d *DB) error {
+				if err := d.Conn().WithContext(ctx).Where("name = ?", "b").First(&traceUser{}).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+					return err
+				}
+				return nil
+			},
+			wantSpans: []RecordedSpan{{Name: "gorm.query", Parent: "request"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, tracer := tracingDB(t)
+			ctx, root := tracer.Start(context.Background(), "request")
+			err := tt.query(ctx, d)
+			root.End()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("query error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			spans := tracer.Spans()[1:]
+			if len(spans) != len(tt.wantSpans) {
+				t.Fatalf("spans = %+v, want %d", spans, len(tt.wantSpans))
+			}
+			for i, want := range tt.wantSpans {
+				s := spans[i]
+				if s.Name != want.Name || s.Parent != want.Parent {
+					t.Errorf("span #%d = %s (parent %s), want %s (parent %s)", i, s.Name, s.Parent, want.Name, want.Parent)
+				}
+				if s.Attributes["db.system"] != "sqlite" || s.EndAt.IsZero() {
+					t.Errorf("span #%d = %+v", i, s)
+				}
+				if (len(s.Errors) > 0) != tt.wantErr {
+					t.Errorf("span #%d errors = %v, wantErr %v", i, s.Errors, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestTracingPlugin_RestoreContext(t *testing.T) {
+	d, tracer := tracingDB(t)
+	ctx, _ := tracer.Start(context.Background(), "request")
+	tx := d.Conn().WithContext(ctx)
+	if err := tx.Find(&[]traceUser{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// 操作结束后语句的上下文恢复为调用方传入的上下文
+	if err := tx.Find(&[]traceUser{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range tracer.Spans()[1:] {
+		if s.Parent != "request" {
+			t.Errorf("span %s parent = %s, want request", s.Name, s.Parent)
+		}
+	}
+}
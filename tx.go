@@ -0,0 +1,203 @@
+package Base_PKG
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*
+	事务辅助方法, 嵌套调用使用 SAVEPOINT, 死锁和锁等待超时自动重试
+*/
+
+const (
+	// mysql 死锁
+	errCodeDeadlock uint16 = 1213
+
+	// mysql 锁等待超时
+	errCodeLockWaitTimeout uint16 = 1205
+)
+
+type txCtxKey struct{}
+
+type txHooksCtxKey struct{}
+
+// txHooks 最外层事务提交后执行的方法
+type txHooks struct {
+	mu  sync.Mutex
+	fns []func()
+}
+
+// TxOptions
+// @Description: 事务配置, 仅对最外层事务生效
+type TxOptions struct {
+	// 隔离级别, 默认使用数据库默认隔离级别
+	Isolation sql.IsolationLevel
+
+	// 只读事务
+	ReadOnly bool
+
+	// 死锁/锁等待超时的最大重试次数, 0 使用默认值 3, 负数不重试
+	MaxRetries int
+
+	// 重试退避的初始时间, 默认 20ms
+	BaseBackoff time.Duration
+
+	// 重试退避的最大时间, 默认 1s
+	MaxBackoff time.Duration
+}
+
+func (o *TxOptions) withDefaults() TxOptions {
+	var opts TxOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = 20 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Second
+	}
+	return opts
+}
+
+/*
+WithTx
+
+	@Description: 在 GetDBConn 的连接上执行事务, 详见 DB.WithTx
+*/
+func WithTx(ctx context.Context, fn func(tx *gorm.DB) error, opts *TxOptions) error {
+	return withTx(ctx, GetDBConn(), fn, opts)
+}
+
+/*
+WithTx
+
+	@Description: 执行事务, fn 返回错误或 panic 时回滚.
+	ctx 中已存在事务时(使用 tx.Statement.Context 调用)作为嵌套事务, 以 SAVEPOINT 执行;
+	最外层事务遇到死锁或锁等待超时时整体重试, fn 需要可重复执行
+	@param ctx: 上下文
+	@param fn: 事务内执行的方法
+	@param opts: 事务配置, 为 nil 时使用默认配置
+	@return error
+*/
+func (d *DB) WithTx(ctx context.Context, fn func(tx *gorm.DB) error, opts *TxOptions) error {
+	return withTx(ctx, d.Conn(), fn, opts)
+}
+
+/*
+TxFromContext
+
+	@Description: 获取上下文中正在执行的事务
+	@param ctx: 上下文
+	@return *gorm.DB: 不在事务中时返回 nil
+*/
+func TxFromContext(ctx context.Context) *gorm.DB {
+	if ctx == nil {
+		return nil
+	}
+	tx, _ := ctx.Value(txCtxKey{}).(*gorm.DB)
+	return tx
+}
+
+/*
+AfterCommit
+
+	@Description: 在 WithTx 开启的最外层事务提交后执行 fn, 事务回滚或重试时丢弃.
+	ctx 不在 WithTx 事务中时立即执行. 嵌套事务回滚到 SAVEPOINT 时已注册的方法仍会执行, fn 需要可重复执行
+	@param ctx: 上下文, 一般为 tx.Statement.Context
+	@param fn: 提交后执行的方法
+*/
+func AfterCommit(ctx context.Context, fn func()) {
+	if ctx != nil {
+		if hooks, ok := ctx.Value(txHooksCtxKey{}).(*txHooks); ok {
+			hooks.mu.Lock()
+			hooks.fns = append(hooks.fns, fn)
+			hooks.mu.Unlock()
+			return
+		}
+	}
+	fn()
+}
+
+// inWithTx 是否在 WithTx 事务中, 即 AfterCommit 是否延迟到提交后执行
+func inWithTx(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	_, ok := ctx.Value(txHooksCtxKey{}).(*txHooks)
+	return ok
+}
+
+func withTx(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error, opts *TxOptions) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// 嵌套事务, gorm 在已有事务上以 SAVEPOINT 执行
+	if parent := TxFromContext(ctx); parent != nil {
+		return parent.WithContext(ctx).Transaction(fn)
+	}
+
+	if db == nil {
+		return errors.New("db 未初始化")
+	}
+	o := opts.withDefaults()
+	txOpts := &sql.TxOptions{Isolation: o.Isolation, ReadOnly: o.ReadOnly}
+
+	for attempt := 0; ; attempt++ {
+		hooks := &txHooks{}
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			txCtx := context.WithValue(ctx, txCtxKey{}, tx)
+			txCtx = context.WithValue(txCtx, txHooksCtxKey{}, hooks)
+			return fn(tx.WithContext(txCtx))
+		}, txOpts)
+		if err == nil {
+			for _, hook := range hooks.fns {
+				hook()
+			}
+			return nil
+		}
+		if attempt >= o.MaxRetries || !IsRetryableTxError(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff(attempt, o.BaseBackoff, o.MaxBackoff)):
+		}
+	}
+}
+
+// backoff 带随机抖动的指数退避
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base << uint(attempt)
+	if d <= 0 || d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// IsRetryableTxError 是否为可重试的死锁或锁等待超时错误
+func IsRetryableTxError(err error) bool {
+	code, ok := mysqlErrorCode(err)
+	return ok && (code == errCodeDeadlock || code == errCodeLockWaitTimeout)
+}
+
+// mysqlErrorCode 获取 mysql 错误码
+func mysqlErrorCode(err error) (uint16, bool) {
+	var mysqlErr *mysqlDriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number, true
+	}
+	return 0, false
+}
@@ -0,0 +1,169 @@
+package Base_PKG
+
+import (
+	"context"
+	"errors"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func itemNames(t *testing.T, d *DB) []string {
+	t.Helper()
+	var names []string
+	if err := d.Conn().Model(&testItem{}).Order("id").Pluck("name", &names).Error; err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestWithTx_Savepoint(t *testing.T) {
+	d := openSQLiteDB(t, nil, &testItem{})
+	errInner := errors.New("inner")
+	err := d.WithTx(context.Background(), func(tx *gorm.DB) error {
+		if TxFromContext(tx.Statement.Context) == nil {
+			t.Error("TxFromContext() = nil in transaction")
+		}
+		if err := tx.Create(&testItem{Name: "outer"}).Error; err != nil {
+			return err
+		}
+		// 嵌套事务失败只回滚到 SAVEPOINT
+		innerErr := d.WithTx(tx.Statement.Context, func(inner *gorm.DB) error {
+			if err := inner.Create(&testItem{Name: "inner"}).Error; err != nil {
+				return err
+			}
+			return errInner
+		}, nil)
+		if !errors.Is(innerErr, errInner) {
+			t.Errorf("inner WithTx() error = %v, want %v", innerErr, errInner)
+		}
+		return d.WithTx(tx.Statement.Context, func(inner *gorm.DB) error {
+			return inner.Create(&testItem{Name: "inner2"}).Error
+		}, nil)
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := itemNames(t, d); len(names) != 2 || names[0] != "outer" || names[1] != "inner2" {
+		t.Errorf("names = %v, want [outer inner2]", names)
+	}
+	if TxFromContext(context.Background()) != nil {
+		t.Errorf("TxFromContext() outside transaction != nil")
+	}
+}
+
+func TestWithTx_Retry(t *testing.T) {
+	deadlock := &mysqlDriver.MySQLError{Number: 1213, Message: "Deadlock found"}
+	errOther := errors.New("other")
+	tests := []struct {
+		name         string
+		maxRetries   int
+		failures     int
+		failWith     error
+		wantErr      error
+		wantAttempts int
+	}{
+		{name: "死锁后重试成功", failures: 2, failWith: deadlock, wantAttempts: 3},
+		{name: "超过最大重试次数", maxRetries: 1, failures: 5, failWith: deadlock, wantErr: deadlock, wantAttempts: 2},
+		{name: "不重试", maxRetries: -1, failures: 1, failWith: deadlock, wantErr: deadlock, wantAttempts: 1},
+		{name: "其他错误不重试", failures: 1, failWith: errOther, wantErr: errOther, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := openSQLiteDB(t, nil, &testItem{})
+			var attempts, committed int
+			opts := &TxOptions{MaxRetries: tt.maxRetries, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+			err := d.WithTx(context.Background(), func(tx *gorm.DB) error {
+				attempts++
+				AfterCommit(tx.Statement.Context, func() { committed++ })
+				if err := tx.Create(&testItem{Name: "a"}).Error; err != nil {
+					return err
+				}
+				if attempts <= tt.failures {
+					return tt.failWith
+				}
+				return nil
+			}, opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("WithTx() error = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			// 失败的尝试已回滚, 其 AfterCommit 被丢弃
+			wantRows, wantCommitted := 1, 1
+			if tt.wantErr != nil {
+				wantRows, wantCommitted = 0, 0
+			}
+			if names := itemNames(t, d); len(names) != wantRows {
+				t.Errorf("rows = %d, want %d", len(names), wantRows)
+			}
+			if committed != wantCommitted {
+				t.Errorf("AfterCommit calls = %d, want %d", committed, wantCommitted)
+			}
+		})
+	}
+}
+
+func TestWithTx_ContextCanceled(t *testing.T) {
+	d := openSQLiteDB(t, nil, &testItem{})
+	ctx, cancel := context.WithCancel(context.Background())
+	deadlock := &mysqlDriver.MySQLError{Number: 1213}
+	err := d.WithTx(ctx, func(tx *gorm.DB) error {
+		cancel()
+		return deadlock
+	}, &TxOptions{BaseBackoff: time.Hour, MaxBackoff: time.Hour})
+	if !errors.Is(err, deadlock) || !errors.Is(err, context.Canceled) {
+		t.Errorf("WithTx() error = %v, want deadlock and canceled", err)
+	}
+}
+
+func TestAfterCommit(t *testing.T) {
+	d := openSQLiteDB(t, nil, &testItem{})
+	var calls []string
+	AfterCommit(context.Background(), func() { calls = append(calls, "outside") })
+	if len(calls) != 1 {
+		t.Fatalf("AfterCommit() outside transaction not run immediately")
+	}
+
+	err := d.WithTx(context.Background(), func(tx *gorm.DB) error {
+		AfterCommit(tx.Statement.Context, func() { calls = append(calls, "outer") })
+		_ = d.WithTx(tx.Statement.Context, func(inner *gorm.DB) error {
+			AfterCommit(inner.Statement.Context, func() { calls = append(calls, "inner") })
+			return nil
+		}, nil)
+		if len(calls) != 1 {
+			t.Errorf("AfterCommit() ran before commit: %v", calls)
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 3 || calls[1] != "outer" || calls[2] != "inner" {
+		t.Errorf("calls = %v, want [outside outer inner]", calls)
+	}
+
+	calls = nil
+	_ = d.WithTx(context.Background(), func(tx *gorm.DB) error {
+		AfterCommit(tx.Statement.Context, func() { calls = append(calls, "rollback") })
+		return errors.New("rollback")
+	}, nil)
+	if len(calls) != 0 {
+		t.Errorf("AfterCommit() ran after rollback: %v", calls)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := 10*time.Millisecond, 50*time.Millisecond
+	for attempt := 0; attempt < 10; attempt++ {
+		want := base << uint(attempt)
+		if want > max {
+			want = max
+		}
+		if got := backoff(attempt, base, max); got < want/2 || got > want {
+			t.Errorf("backoff(%d) = %v, want in [%v, %v]", attempt, got, want/2, want)
+		}
+	}
+}